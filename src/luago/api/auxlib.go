@@ -0,0 +1,57 @@
+package api
+
+type FuncReg map[string]GoFunction
+
+/**
+ * Helpers for writing Go functions, the equivalent of lauxlib. Names clashing
+ * with LuaState methods get a "2" suffix.
+ */
+type AuxLib interface {
+	/* error-report functions */
+	Error2(format string, a ...interface{}) int
+	ArgError(arg int, extraMsg string) int
+	TypeError(arg int, tname string) int
+	Where(level int)
+	/* argument check functions */
+	CheckStack2(sz int, msg string)
+	ArgCheck(cond bool, arg int, extraMsg string)
+	CheckAny(arg int)
+	CheckType(arg int, t LuaType)
+	CheckInteger(arg int) int64
+	CheckNumber(arg int) float64
+	CheckString(arg int) string
+	CheckOption(arg int, def string, lst []string) int
+	OptInteger(arg int, d int64) int64
+	OptNumber(arg int, d float64) float64
+	OptString(arg int, d string) string
+	/* userdata with named metatables */
+	NewMetatable(tname string) bool
+	GetMetatable2(tname string) LuaType
+	SetMetatable2(tname string)
+	TestUdata(arg int, tname string) (interface{}, bool)
+	CheckUdata(arg int, tname string) interface{}
+	/* load functions */
+	DoFile(filename string) bool
+	DoString(str string) bool
+	LoadFile(filename string) int
+	LoadFileX(filename, mode string) int
+	LoadString(s string) int
+	/* file system */
+	FileSystem() FileSystem
+	SetFileSystem(fsys FileSystem)
+	FileResult(err error, fname string) int
+	/* references */
+	Ref(t int) int
+	Unref(t, ref int)
+	/* other functions */
+	TypeName2(idx int) string
+	ToStringMeta(idx int) string
+	Len2(idx int) int64
+	GetSubTable(idx int, fname string) bool
+	GetMetafield(obj int, e string) LuaType
+	CallMeta(obj int, e string) bool
+	Traceback(l1 LuaState, msg string, level int)
+	NewLib(l FuncReg)
+	NewLibTable(l FuncReg)
+	SetFuncs(l FuncReg, nup int)
+}
@@ -46,3 +46,15 @@ const (
 	LUA_ERRERR
 	LUA_ERRFILE
 )
+
+const LUA_MULTRET = -1 // option for multiple returns in Call and PCall
+
+const (
+	LUA_NOREF  = -2 // reference that refers to no value
+	LUA_REFNIL = -1 // reference to nil
+)
+
+const (
+	LUA_LOADED_TABLE  = "_LOADED"  // registry key of the table of loaded modules
+	LUA_PRELOAD_TABLE = "_PRELOAD" // registry key of the table of preloaders
+)
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"io"
+	"io/fs"
+)
+
+// a file opened through a FileSystem
+type File interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	io.Closer
+}
+
+/**
+ * The file system seen by scripts. `loadfile`, `dofile` and the io and os
+ * libraries only touch files through it, so a host can run untrusted
+ * scripts against an in-memory file system. Names, flags and errors follow
+ * the os package: OpenFile takes os.O_* flags and failures should wrap a
+ * syscall.Errno so scripts get the usual messages and error codes.
+ */
+type FileSystem interface {
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	Remove(name string) error
+	Rename(oldName, newName string) error
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"fmt"
+	"luago/number"
+	"strconv"
+	"strings"
+)
+
+const LUA_IDSIZE = 60 // size of a chunk id, see ChunkID()
+
+/**
+ * LuaError is the value the compiler, the binary chunk reader and the
+ * VM panic with. Value is the Lua error object as seen by `pcall`, which
+ * for errors raised by the implementation itself is Message prefixed with
+ * "chunkid:line: ". ChunkName and Line locate the error (Line is 0 when
+ * unknown). There is no traceback, since most errors are caught and
+ * dropped: a message handler that wants one builds it, as lua.c does.
+ */
+type LuaError struct {
+	Value     interface{}
+	Message   string
+	ChunkName string
+	Line      int
+}
+
+func (e *LuaError) Error() string {
+	switch x := e.Value.(type) {
+	case string:
+		return x
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		return number.FloatToString(x)
+	}
+	return e.Message
+}
+
+/**
+ * Creates an error located at the given line of a chunk, the chunk name is
+ * turned into a chunk id as in "file.lua:12: message".
+ */
+func NewError(chunkName string, line int, message string) *LuaError {
+	value := message
+	if line > 0 {
+		value = fmt.Sprintf("%s:%d: %s", ChunkID(chunkName), line, message)
+	} else if chunkName != "" {
+		value = fmt.Sprintf("%s: %s", ChunkID(chunkName), message)
+	}
+	return &LuaError{
+		Value:     value,
+		Message:   message,
+		ChunkName: chunkName,
+		Line:      line,
+	}
+}
+
+/**
+ * Formats a chunk name for messages: "@file" becomes "file" (keeping its
+ * tail if too long), "=name" becomes "name" and any other source becomes
+ * [string "first line..."].
+ */
+func ChunkID(source string) string {
+	const bufflen = LUA_IDSIZE - 1
+	if strings.HasPrefix(source, "=") {
+		source = source[1:]
+		if len(source) > bufflen {
+			source = source[:bufflen]
+		}
+		return source
+	}
+	if strings.HasPrefix(source, "@") {
+		source = source[1:]
+		if len(source) > bufflen {
+			source = "..." + source[len(source)-bufflen+3:]
+		}
+		return source
+	}
+
+	const pre, rets, pos = "[string \"", "...", "\"]"
+	l := bufflen - len(pre) - len(rets) - len(pos)
+	nl := strings.IndexByte(source, '\n')
+	if len(source) < l && nl < 0 {
+		return pre + source + pos
+	}
+	if nl >= 0 {
+		source = source[:nl]
+	}
+	if len(source) > l {
+		source = source[:l]
+	}
+	return pre + source + rets + pos
+}
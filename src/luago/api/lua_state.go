@@ -1,10 +1,15 @@
 package api
 
+import "io"
+
 const (
-	LUA_MINSTACK            = 20
-	LUAI_MAXSTACK           = 1000000
-	LUA_REGISTRYINDEX       = -LUAI_MAXSTACK - 1000
-	LUA_RIDX_GLOBALS  int64 = 2
+	LUA_MINSTACK              = 20
+	LUAI_MAXSTACK             = 1000000
+	LUAI_MAXCALLS             = 200000 // default limit of nested calls
+	LUAI_MAXCCALLS            = 200    // default limit of nested calls from Go
+	LUA_REGISTRYINDEX         = -LUAI_MAXSTACK - 1000
+	LUA_RIDX_MAINTHREAD int64 = 1
+	LUA_RIDX_GLOBALS    int64 = 2
 )
 
 type LuaType = int
@@ -13,6 +18,8 @@ type ArithOp = int
 type CompareOp = int
 
 type LuaState interface {
+	AuxLib
+
 	/* basic stack manipulations */
 	GetTop() int
 	AbsIndex(idx int) int
@@ -38,6 +45,9 @@ type LuaState interface {
 	IsString(idx int) bool
 	IsFunction(idx int) bool
 	IsGoFunction(idx int) bool
+	IsThread(idx int) bool
+	IsUserdata(idx int) bool
+	IsLightUserdata(idx int) bool
 	ToBoolean(idx int) bool
 	ToInteger(idx int) int64
 	ToIntegerX(idx int) (int64, bool)
@@ -46,6 +56,8 @@ type LuaState interface {
 	ToString(idx int) string
 	ToStringX(idx int) (string, bool)
 	ToGoFunction(idx int) GoFunction
+	ToThread(idx int) LuaState
+	ToUserdata(idx int) interface{}
 	RawLen(idx int) uint
 
 	/* push functions (Go -> stack) */
@@ -54,9 +66,12 @@ type LuaState interface {
 	PushInteger(n int64)
 	PushNumber(n float64)
 	PushString(s string)
+	PushFString(format string, a ...interface{})
 	PushGoFunction(f GoFunction)
 	PushGoClosure(f GoFunction, n int)
 	PushGlobalTable()
+	PushThread() bool
+	PushLightUserdata(data interface{})
 
 	/* comparison and arithmetic functions */
 	Arith(op ArithOp)
@@ -73,6 +88,8 @@ type LuaState interface {
 	RawGetI(idx int, i int64) LuaType
 	GetMetatable(idx int) bool
 	GetGlobal(name string) LuaType
+	NewUserdata(data interface{})
+	GetUserValue(idx int) LuaType
 
 	/* set functions (stack -> Lua) */
 	SetTable(idx int)
@@ -82,18 +99,34 @@ type LuaState interface {
 	RawSetI(idx int, i int64)
 	SetMetatable(idx int)
 	SetGlobal(name string)
+	SetUserValue(idx int)
 	Register(name string, f GoFunction)
 
 	/* `load` and `call` functions (load and run Lua code) */
 	Load(chunk []byte, chunkName, mode string) int
+	LoadReader(r io.Reader, chunkName, mode string, env int) int
+	Dump(strip bool) []byte
 	Call(nArgs, nResults int)
 	PCall(nArgs, nResults, msgh int) int
+	SetCallLimits(maxCalls, maxCCalls int)
 
 	/* miscellaneous functions */
 	Len(idx int)
 	Concat(n int)
 	Next(idx int) bool
 	Error() int
+	StringToNumber(s string) bool
+	GetUpvalue(funcIdx, n int) (string, bool)
+	SetUpvalue(funcIdx, n int) (string, bool)
+
+	/* coroutine functions */
+	NewThread() LuaState
+	Resume(from LuaState, nArgs int) int
+	Yield(nResults int) int
+	Status() int
+	IsYieldable() bool
+	GetStack() bool
+	XMove(to LuaState, n int)
 }
 
 type GoFunction func(LuaState) int
@@ -9,4 +9,10 @@ type LuaVM interface {
 	LoadVararg(n int)
 	LoadProto(idx int)
 	CloseUpvalues(a int)
+	RegArith(a, b, c int, op ArithOp)
+	RegCompare(b, c int, op CompareOp) bool
+	ForPrep(a int)
+	ForLoop(a int) bool
+	PreCall(nArgs, nResults int) bool
+	TailCall(nArgs int)
 }
@@ -72,9 +72,30 @@ func IsBinaryChunk(data []byte) bool {
 	return len(data) > 4 && string(data[:4]) == LUA_SIGNATURE
 }
 
-func Parse(data []byte) *Prototype {
-	reader := &reader{data}
+/**
+ * Reads a binary chunk and verifies its code. Malformed chunks are reported
+ * by panicking with an *api.LuaError naming the chunk, as in "file.luac:
+ * truncated chunk at offset 42".
+ */
+func Parse(data []byte, chunkName string) *Prototype {
+	if chunkName != "" && chunkName[0] == LUA_SIGNATURE[0] {
+		chunkName = "=binary string"
+	}
+	reader := &reader{data: data, name: chunkName}
 	reader.checkHeader()
-	reader.readByte()
-	return reader.readProto("")
+	nUpvals := int(reader.readByte())
+	proto := reader.readProto("")
+	if nUpvals != len(proto.Upvalues) {
+		reader.errorf("upvalue count %d does not match the main function (%d)", nUpvals, len(proto.Upvalues))
+	}
+	verify(proto, nil, chunkName)
+	return proto
+}
+
+func Dump(proto *Prototype, stripDebug bool) []byte {
+	writer := &writer{strip: stripDebug}
+	writer.writeHeader()
+	writer.writeByte(byte(len(proto.Upvalues)))
+	writer.writeProto(proto, nil)
+	return writer.data
 }
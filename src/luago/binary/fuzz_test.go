@@ -0,0 +1,63 @@
+package binary_test
+
+import (
+	"luago/api"
+	"luago/binary"
+	"luago/compiler"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sources compiled into the seeds of the fuzz test
+const seedDir = "../../../tests/luac"
+
+/**
+ * Feeds corrupted chunks to binary.Parse, which must either accept them or
+ * reject them with an *api.LuaError; any other panic fails the test. The
+ * seeds are the chunks of the Lua files under tests/luac, with and without
+ * debug information, and the inputs under testdata/fuzz/FuzzUndump, which
+ * crashed the unchecked reader or pass it and fail the verifier. An accepted
+ * chunk must also be accepted again once dumped.
+ *
+ *	go test ./binary -fuzz FuzzUndump
+ */
+func FuzzUndump(f *testing.F) {
+	files, err := filepath.Glob(filepath.Join(seedDir, "*.lua"))
+	if err != nil || len(files) == 0 {
+		f.Fatalf("no seeds in %s", seedDir)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		proto := compiler.Compile(string(data), "@"+filepath.Base(file))
+		f.Add(binary.Dump(proto, false))
+		f.Add(binary.Dump(proto, true))
+	}
+
+	f.Fuzz(func(t *testing.T, chunk []byte) {
+		proto, err := parse(chunk)
+		if err != nil {
+			return // rejected
+		}
+		if _, err := parse(binary.Dump(proto, false)); err != nil {
+			t.Fatalf("dump of an accepted chunk is rejected: %v", err)
+		}
+	})
+}
+
+// parses chunk, returning the Lua error it is rejected with
+func parse(chunk []byte) (proto *binary.Prototype, err *api.LuaError) {
+	defer func() {
+		if r := recover(); r != nil {
+			if luaErr, ok := r.(*api.LuaError); ok {
+				err = luaErr
+			} else {
+				panic(r)
+			}
+		}
+	}()
+	return binary.Parse(chunk, "=fuzz"), nil
+}
@@ -2,31 +2,78 @@ package binary
 
 import (
 	"encoding/binary"
+	"fmt"
+	"luago/api"
 	"math"
 )
 
+// limit for the nesting of functions, as LUAI_MAXCCALLS limits the parser
+const maxNestedProtos = 200
+
 type reader struct {
-	data []byte
+	data  []byte
+	pos   int    // offset of data[0] in the chunk, for error messages
+	name  string // chunk name for error messages
+	level int    // nesting of the function being read
+}
+
+// reports a malformed header, as in "file.luac: not a precompiled chunk"
+func (reader *reader) error(why string) {
+	panic(api.NewError(reader.name, 0, why+" precompiled chunk"))
+}
+
+// reports malformed contents, as in "file.luac: truncated chunk at offset 42"
+func (reader *reader) errorf(format string, a ...interface{}) {
+	panic(api.NewError(reader.name, 0, fmt.Sprintf(format, a...)))
+}
+
+// makes sure that n more bytes can be read
+func (reader *reader) need(n uint64) {
+	if uint64(len(reader.data)) < n {
+		reader.errorf("truncated chunk at offset %d", reader.pos)
+	}
+}
+
+// consumes the n bytes already checked by need
+func (reader *reader) skip(n int) {
+	reader.data = reader.data[n:]
+	reader.pos += n
 }
 
 func (reader *reader) readByte() byte {
+	reader.need(1)
 	b := reader.data[0]
-	reader.data = reader.data[1:]
+	reader.skip(1)
 	return b
 }
 
 func (reader *reader) readUint32() uint32 {
+	reader.need(4)
 	i := binary.LittleEndian.Uint32(reader.data)
-	reader.data = reader.data[4:]
+	reader.skip(4)
 	return i
 }
 
 func (reader *reader) readUint64() uint64 {
+	reader.need(8)
 	i := binary.LittleEndian.Uint64(reader.data)
-	reader.data = reader.data[8:]
+	reader.skip(8)
 	return i
 }
 
+/**
+ * Reads the size of a list whose items take at least itemSize bytes each,
+ * so that a hostile count cannot make us allocate more than the chunk holds.
+ */
+func (reader *reader) readCount(itemSize int) int {
+	pos := reader.pos
+	n := reader.readUint32()
+	if uint64(n)*uint64(itemSize) > uint64(len(reader.data)) {
+		reader.errorf("count %d too large for the chunk at offset %d", n, pos)
+	}
+	return int(n)
+}
+
 func (reader *reader) readLuaInteger() int64 {
 	return int64(reader.readUint64())
 }
@@ -47,38 +94,44 @@ func (reader *reader) readString() string {
 }
 
 func (reader *reader) readBytes(n uint64) []byte {
+	reader.need(n)
 	bytes := reader.data[:n]
-	reader.data = reader.data[n:]
+	reader.skip(int(n))
 	return bytes
 }
 
 func (reader *reader) checkHeader() {
 	if string(reader.readBytes(4)) != LUA_SIGNATURE {
-		panic("not a precompiled chunk")
+		reader.error("not a")
 	} else if reader.readByte() != LUAC_VERSION {
-		panic("version mismatch")
+		reader.error("version mismatch in")
 	} else if reader.readByte() != LUAC_FORMAT {
-		panic("format mismatch")
+		reader.error("format mismatch in")
 	} else if string(reader.readBytes(6)) != LUAC_DATA {
-		panic("corrupted")
+		reader.error("corrupted")
 	} else if reader.readByte() != CINT_SIZE {
-		panic("int size missmatch")
+		reader.error("int size mismatch in")
 	} else if reader.readByte() != CSIZET_SIZE {
-		panic("size_t size missmatch")
+		reader.error("size_t size mismatch in")
 	} else if reader.readByte() != INSTRUCTION_SIZE {
-		panic("instruction size missmatch")
+		reader.error("Instruction size mismatch in")
 	} else if reader.readByte() != LUA_INTEGER_SIZE {
-		panic("lua_Integer size missmatch")
+		reader.error("lua_Integer size mismatch in")
 	} else if reader.readByte() != LUA_NUMBER_SIZE {
-		panic("lua_Number size missmatch")
+		reader.error("lua_Number size mismatch in")
 	} else if reader.readLuaInteger() != LUAC_INT {
-		panic("endianness missmatch")
+		reader.error("endianness mismatch in")
 	} else if reader.readLuaNumber() != LUAC_NUM {
-		panic("float format missmatch")
+		reader.error("float format mismatch in")
 	}
 }
 
 func (reader *reader) readProto(parentSource string) *Prototype {
+	if reader.level++; reader.level > maxNestedProtos {
+		reader.errorf("too many nested functions at offset %d", reader.pos)
+	}
+	defer func() { reader.level-- }()
+
 	source := reader.readString()
 	if source == "" {
 		source = parentSource
@@ -93,14 +146,14 @@ func (reader *reader) readProto(parentSource string) *Prototype {
 		MaxStackSize: reader.readByte(),
 	}
 
-	proto.Code = make([]uint32, reader.readUint32())
+	proto.Code = make([]uint32, reader.readCount(4))
 	for i := range proto.Code {
 		proto.Code[i] = reader.readUint32()
 	}
 
-	proto.Constants = make([]interface{}, reader.readUint32())
+	proto.Constants = make([]interface{}, reader.readCount(1))
 	for i := range proto.Constants {
-		switch reader.readByte() {
+		switch tag := reader.readByte(); tag {
 		case TAG_NIL:
 			proto.Constants[i] = nil
 		case TAG_BOOLEAN:
@@ -111,10 +164,12 @@ func (reader *reader) readProto(parentSource string) *Prototype {
 			proto.Constants[i] = reader.readLuaInteger()
 		case TAG_SHORT_STRING, TAG_LONG_STRING:
 			proto.Constants[i] = reader.readString()
+		default:
+			reader.errorf("bad constant tag 0x%02x at offset %d", tag, reader.pos-1)
 		}
 	}
 
-	proto.Upvalues = make([]Upvalue, reader.readUint32())
+	proto.Upvalues = make([]Upvalue, reader.readCount(2))
 	for i := range proto.Upvalues {
 		proto.Upvalues[i] = Upvalue{
 			InStack: reader.readByte(),
@@ -122,17 +177,17 @@ func (reader *reader) readProto(parentSource string) *Prototype {
 		}
 	}
 
-	proto.Protos = make([]*Prototype, reader.readUint32())
+	proto.Protos = make([]*Prototype, reader.readCount(1))
 	for i := range proto.Protos {
 		proto.Protos[i] = reader.readProto(source)
 	}
 
-	proto.LineInfo = make([]uint32, reader.readUint32())
+	proto.LineInfo = make([]uint32, reader.readCount(4))
 	for i := range proto.LineInfo {
 		proto.LineInfo[i] = reader.readUint32()
 	}
 
-	proto.LocVars = make([]LocVar, reader.readUint32())
+	proto.LocVars = make([]LocVar, reader.readCount(9))
 	for i := range proto.LocVars {
 		proto.LocVars[i] = LocVar{
 			VarName: reader.readString(),
@@ -141,7 +196,7 @@ func (reader *reader) readProto(parentSource string) *Prototype {
 		}
 	}
 
-	proto.UpvalueNames = make([]string, reader.readUint32())
+	proto.UpvalueNames = make([]string, reader.readCount(1))
 	for i := range proto.UpvalueNames {
 		proto.UpvalueNames[i] = reader.readString()
 	}
@@ -0,0 +1,285 @@
+package binary
+
+import (
+	"fmt"
+	"luago/api"
+	"luago/vm"
+)
+
+/**
+ * The bytecode verifier. The VM trusts its code: registers, constants,
+ * upvalues, nested functions and jump targets are indexed without checks,
+ * so a binary chunk must not reach it before its code has been checked
+ * against the sizes declared by its prototype.
+ */
+type verifier struct {
+	proto  *Prototype
+	parent *Prototype // nil for the main function
+	name   string     // chunk name for error messages
+	pc     int        // instruction being checked, or -1
+}
+
+// checks proto and its nested functions, reporting the first error found
+func verify(proto, parent *Prototype, chunkName string) {
+	v := &verifier{proto: proto, parent: parent, name: chunkName, pc: -1}
+	v.checkProto()
+	v.checkCode()
+	for _, p := range proto.Protos {
+		verify(p, proto, chunkName)
+	}
+}
+
+func (v *verifier) check(cond bool, format string, a ...interface{}) {
+	if cond {
+		return
+	}
+	msg := fmt.Sprintf(format, a...)
+	if v.pc >= 0 {
+		msg = fmt.Sprintf("%s at instruction %d", msg, v.pc+1)
+	}
+	panic(api.NewError(v.name, 0, fmt.Sprintf("bad code in precompiled chunk: %s of function at line %d",
+		msg, v.proto.LineBegin)))
+}
+
+func (v *verifier) checkProto() {
+	proto := v.proto
+	v.check(int(proto.NumParams) <= int(proto.MaxStackSize), "%d parameters for %d registers",
+		proto.NumParams, proto.MaxStackSize)
+	v.check(len(proto.Code) > 0 && vm.Instruction(proto.Code[len(proto.Code)-1]).Opcode() == vm.OP_RETURN,
+		"missing final return")
+	if v.parent == nil {
+		return // the upvalues of the main function are set by whoever loads it
+	}
+	for i, upval := range proto.Upvalues {
+		switch upval.InStack {
+		case 1: // a register of the enclosing function
+			v.check(int(upval.Index) < int(v.parent.MaxStackSize), "upvalue %d refers to register %d", i, upval.Index)
+		case 0: // an upvalue of the enclosing function
+			v.check(int(upval.Index) < len(v.parent.Upvalues), "upvalue %d refers to upvalue %d", i, upval.Index)
+		default:
+			v.check(false, "upvalue %d has bad kind %d", i, upval.InStack)
+		}
+	}
+}
+
+/**
+ * Checks every instruction on its own, plus the few constraints between
+ * neighbours that the VM relies on: tests are followed by a jump, an
+ * EXTRAARG only follows the instruction that consumes it, and an instruction
+ * leaving an open number of values on the stack is followed by one taking
+ * them.
+ */
+func (v *verifier) checkCode() {
+	code := v.proto.Code
+	targets := make([]bool, len(code)+1) // pcs the VM can reach by jumping
+	for v.pc = 0; v.pc < len(code); v.pc++ {
+		inst := vm.Instruction(code[v.pc])
+		op := inst.Opcode()
+		v.check(op <= vm.OP_EXTRAARG, "bad opcode %d", op)
+		a, b, c := inst.ABC()
+		_, bx := inst.ABx()
+		_, sbx := inst.AsBx()
+
+		switch op {
+		case vm.OP_MOVE, vm.OP_UNM, vm.OP_BNOT, vm.OP_NOT, vm.OP_LEN:
+			v.checkReg(a)
+			v.checkReg(b)
+		case vm.OP_LOADK:
+			v.checkReg(a)
+			v.checkConst(bx)
+		case vm.OP_LOADKX:
+			v.checkReg(a)
+			v.checkConst(v.extraArg())
+		case vm.OP_LOADBOOL:
+			v.checkReg(a)
+			if c != 0 {
+				v.check(v.pc+2 < len(code), "skip out of code")
+				targets[v.pc+2] = true
+			}
+		case vm.OP_LOADNIL:
+			v.checkReg(a + b)
+		case vm.OP_GETUPVAL, vm.OP_SETUPVAL:
+			v.checkReg(a)
+			v.checkUpval(b)
+		case vm.OP_GETTABUP:
+			v.checkReg(a)
+			v.checkUpval(b)
+			v.checkRK(c)
+		case vm.OP_SETTABUP:
+			v.checkUpval(a)
+			v.checkRK(b)
+			v.checkRK(c)
+		case vm.OP_GETTABLE:
+			v.checkReg(a)
+			v.checkReg(b)
+			v.checkRK(c)
+		case vm.OP_SETTABLE, vm.OP_ADD, vm.OP_SUB, vm.OP_MUL, vm.OP_MOD, vm.OP_POW, vm.OP_DIV,
+			vm.OP_IDIV, vm.OP_BAND, vm.OP_BOR, vm.OP_BXOR, vm.OP_SHL, vm.OP_SHR:
+			v.checkReg(a)
+			v.checkRK(b)
+			v.checkRK(c)
+		case vm.OP_NEWTABLE:
+			v.checkReg(a)
+		case vm.OP_SELF:
+			v.checkReg(a + 1)
+			v.checkReg(b)
+			v.checkRK(c)
+		case vm.OP_CONCAT:
+			v.checkReg(a)
+			v.check(b <= c, "empty concatenation")
+			v.checkReg(c)
+		case vm.OP_JMP:
+			v.check(a <= int(v.proto.MaxStackSize), "register %d out of range", a-1)
+			targets[v.checkJump(sbx)] = true
+		case vm.OP_EQ, vm.OP_LT, vm.OP_LE:
+			v.checkRK(b)
+			v.checkRK(c)
+			v.checkTest()
+		case vm.OP_TEST:
+			v.checkReg(a)
+			v.checkTest()
+		case vm.OP_TESTSET:
+			v.checkReg(a)
+			v.checkReg(b)
+			v.checkTest()
+		case vm.OP_CALL:
+			v.checkReg(a)
+			v.checkOpenArgs(b, a+b-1)
+			if c == 0 {
+				v.checkOpenResults()
+			} else {
+				v.checkReg(a + c - 2)
+			}
+		case vm.OP_TAILCALL:
+			v.checkReg(a)
+			v.checkOpenArgs(b, a+b-1)
+			v.checkOpenResults() // the results are returned as they are
+		case vm.OP_RETURN:
+			if b != 1 {
+				v.checkReg(a)
+			}
+			v.checkOpenArgs(b, a+b-2)
+		case vm.OP_FORLOOP, vm.OP_FORPREP:
+			v.checkReg(a + 3)
+			targets[v.checkJump(sbx)] = true
+		case vm.OP_TFORCALL:
+			v.checkReg(a + 2 + c)
+		case vm.OP_TFORLOOP:
+			v.checkReg(a + 1)
+			targets[v.checkJump(sbx)] = true
+		case vm.OP_SETLIST:
+			v.checkReg(a)
+			v.checkOpenArgs(b, a+b)
+			if c == 0 {
+				v.extraArg()
+			}
+		case vm.OP_CLOSURE:
+			v.checkReg(a)
+			v.check(bx < len(v.proto.Protos), "function %d out of range", bx)
+		case vm.OP_VARARG:
+			v.checkReg(a)
+			if b == 0 {
+				v.checkOpenResults()
+			} else {
+				v.checkReg(a + b - 2)
+			}
+		case vm.OP_EXTRAARG:
+			v.check(false, "unexpected EXTRAARG")
+		}
+	}
+
+	// the pcs skipped over by extraArg and the open operands may only be
+	// reached from the instruction before them
+	for v.pc = 0; v.pc < len(code); v.pc++ {
+		if targets[v.pc] {
+			v.check(!isExtraArg(code, v.pc) && !isOpenArgs(code, v.pc), "bad jump target")
+		}
+	}
+	v.pc = -1
+}
+
+func (v *verifier) checkReg(r int) {
+	v.check(r < int(v.proto.MaxStackSize), "register %d out of range", r)
+}
+
+func (v *verifier) checkConst(k int) {
+	v.check(k < len(v.proto.Constants), "constant %d out of range", k)
+}
+
+// checks an operand which is a constant if its high bit is set, as in _getRK
+func (v *verifier) checkRK(rk int) {
+	if rk > 0xff {
+		v.checkConst(rk & 0xff)
+	} else {
+		v.checkReg(rk)
+	}
+}
+
+func (v *verifier) checkUpval(i int) {
+	v.check(i < len(v.proto.Upvalues), "upvalue %d out of range", i)
+}
+
+// checks that the jump by sbx lands inside the code, and returns its target
+func (v *verifier) checkJump(sbx int) int {
+	dest := v.pc + 1 + sbx
+	v.check(0 <= dest && dest < len(v.proto.Code), "jump to %d out of code", dest+1)
+	return dest
+}
+
+// a test skips the jump after it, or falls through to it
+func (v *verifier) checkTest() {
+	code := v.proto.Code
+	v.check(v.pc+2 < len(code) && vm.Instruction(code[v.pc+1]).Opcode() == vm.OP_JMP,
+		"test not followed by a jump")
+}
+
+// consumes the EXTRAARG after the current instruction and returns its Ax
+func (v *verifier) extraArg() int {
+	code := v.proto.Code
+	v.check(isExtraArg(code, v.pc+1), "missing EXTRAARG")
+	v.pc++
+	return vm.Instruction(code[v.pc]).Ax()
+}
+
+// checks the registers of an operand list ending at last, or taken up to the top when b is 0
+func (v *verifier) checkOpenArgs(b, last int) {
+	if b != 0 {
+		v.checkReg(last)
+		return
+	}
+	v.check(v.pc > 0 && leavesOpenResults(v.proto.Code[v.pc-1]), "open operands without open results")
+}
+
+// an open number of results must be taken by the next instruction
+func (v *verifier) checkOpenResults() {
+	code := v.proto.Code
+	v.check(v.pc+1 < len(code) && isOpenArgs(code, v.pc+1), "open results not taken")
+}
+
+func isExtraArg(code []uint32, pc int) bool {
+	return pc < len(code) && vm.Instruction(code[pc]).Opcode() == vm.OP_EXTRAARG
+}
+
+func leavesOpenResults(i uint32) bool {
+	inst := vm.Instruction(i)
+	_, b, c := inst.ABC()
+	switch inst.Opcode() {
+	case vm.OP_CALL:
+		return c == 0
+	case vm.OP_TAILCALL:
+		return true
+	case vm.OP_VARARG:
+		return b == 0
+	}
+	return false
+}
+
+func isOpenArgs(code []uint32, pc int) bool {
+	inst := vm.Instruction(code[pc])
+	_, b, _ := inst.ABC()
+	switch inst.Opcode() {
+	case vm.OP_CALL, vm.OP_TAILCALL, vm.OP_RETURN, vm.OP_SETLIST:
+		return b == 0
+	}
+	return false
+}
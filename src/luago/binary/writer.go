@@ -0,0 +1,152 @@
+package binary
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+const LUAI_MAXSHORTLEN = 40
+
+type writer struct {
+	data  []byte
+	strip bool
+}
+
+func (writer *writer) writeByte(b byte) {
+	writer.data = append(writer.data, b)
+}
+
+func (writer *writer) writeUint32(i uint32) {
+	writer.data = binary.LittleEndian.AppendUint32(writer.data, i)
+}
+
+func (writer *writer) writeUint64(i uint64) {
+	writer.data = binary.LittleEndian.AppendUint64(writer.data, i)
+}
+
+func (writer *writer) writeLuaInteger(i int64) {
+	writer.writeUint64(uint64(i))
+}
+
+func (writer *writer) writeLuaNumber(f float64) {
+	writer.writeUint64(math.Float64bits(f))
+}
+
+// writes a string, `nil` (no string at all) is written as size 0
+func (writer *writer) writeString(s *string) {
+	if s == nil {
+		writer.writeByte(0)
+		return
+	}
+	size := uint64(len(*s)) + 1
+	if size < 0xff {
+		writer.writeByte(byte(size))
+	} else {
+		writer.writeByte(0xff)
+		writer.writeUint64(size)
+	}
+	writer.writeBytes([]byte(*s))
+}
+
+func (writer *writer) writeBytes(bytes []byte) {
+	writer.data = append(writer.data, bytes...)
+}
+
+func (writer *writer) writeHeader() {
+	writer.writeBytes([]byte(LUA_SIGNATURE))
+	writer.writeByte(LUAC_VERSION)
+	writer.writeByte(LUAC_FORMAT)
+	writer.writeBytes([]byte(LUAC_DATA))
+	writer.writeByte(CINT_SIZE)
+	writer.writeByte(CSIZET_SIZE)
+	writer.writeByte(INSTRUCTION_SIZE)
+	writer.writeByte(LUA_INTEGER_SIZE)
+	writer.writeByte(LUA_NUMBER_SIZE)
+	writer.writeLuaInteger(LUAC_INT)
+	writer.writeLuaNumber(LUAC_NUM)
+}
+
+func (writer *writer) writeProto(proto *Prototype, parentSource *string) {
+	if writer.strip || parentSource != nil && proto.Source == *parentSource {
+		writer.writeString(nil)
+	} else {
+		writer.writeString(&proto.Source)
+	}
+
+	writer.writeUint32(proto.LineBegin)
+	writer.writeUint32(proto.LineEnd)
+	writer.writeByte(proto.NumParams)
+	writer.writeByte(proto.IsVararg)
+	writer.writeByte(proto.MaxStackSize)
+
+	writer.writeUint32(uint32(len(proto.Code)))
+	for _, inst := range proto.Code {
+		writer.writeUint32(inst)
+	}
+
+	writer.writeUint32(uint32(len(proto.Constants)))
+	for _, k := range proto.Constants {
+		switch x := k.(type) {
+		case nil:
+			writer.writeByte(TAG_NIL)
+		case bool:
+			writer.writeByte(TAG_BOOLEAN)
+			if x {
+				writer.writeByte(1)
+			} else {
+				writer.writeByte(0)
+			}
+		case float64:
+			writer.writeByte(TAG_NUMBER)
+			writer.writeLuaNumber(x)
+		case int64:
+			writer.writeByte(TAG_INTEGER)
+			writer.writeLuaInteger(x)
+		case string:
+			if len(x) <= LUAI_MAXSHORTLEN {
+				writer.writeByte(TAG_SHORT_STRING)
+			} else {
+				writer.writeByte(TAG_LONG_STRING)
+			}
+			writer.writeString(&x)
+		default:
+			panic("invalid constant")
+		}
+	}
+
+	writer.writeUint32(uint32(len(proto.Upvalues)))
+	for _, upval := range proto.Upvalues {
+		writer.writeByte(upval.InStack)
+		writer.writeByte(upval.Index)
+	}
+
+	writer.writeUint32(uint32(len(proto.Protos)))
+	for _, subProto := range proto.Protos {
+		writer.writeProto(subProto, &proto.Source)
+	}
+
+	if writer.strip {
+		writer.writeUint32(0) // line info
+		writer.writeUint32(0) // local variables
+		writer.writeUint32(0) // upvalue names
+		return
+	}
+
+	writer.writeUint32(uint32(len(proto.LineInfo)))
+	for _, line := range proto.LineInfo {
+		writer.writeUint32(line)
+	}
+
+	writer.writeUint32(uint32(len(proto.LocVars)))
+	for i := range proto.LocVars {
+		locVar := &proto.LocVars[i]
+		writer.writeString(&locVar.VarName)
+		writer.writeUint32(locVar.StartPC)
+		writer.writeUint32(locVar.EndPC)
+	}
+
+	writer.writeUint32(uint32(len(proto.UpvalueNames)))
+	for i := range proto.UpvalueNames {
+		writer.writeString(&proto.UpvalueNames[i])
+	}
+}
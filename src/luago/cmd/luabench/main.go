@@ -0,0 +1,72 @@
+/**
+ * luabench runs Lua files one after another, each in a fresh state with the
+ * standard libraries, and reports for each the time it took, the number of
+ * heap allocations made and the bytes they added up to. It is meant for the
+ * bench_*.lua scripts under tests/, to compare the cost of the VM and of its
+ * value representation between two builds.
+ *
+ *	go run ./cmd/luabench file.lua...
+ */
+package main
+
+import (
+	"fmt"
+	"luago/api"
+	"luago/state"
+	"luago/stdlib"
+	"os"
+	"runtime"
+	"time"
+)
+
+const progName = "luabench"
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, "usage: %s file.lua...\n", progName)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-24s %10s %12s %14s\n", "file", "time", "allocs", "bytes")
+	for _, file := range os.Args[1:] {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fatal(fmt.Sprintf("cannot open %s", file))
+		}
+		elapsed, allocs, bytes := run(data, file)
+		fmt.Printf("%-24s %9.2fs %12d %14d\n", file, elapsed.Seconds(), allocs, bytes)
+	}
+}
+
+// runs a chunk in a new state, measuring the allocations made from loading to the end
+func run(data []byte, file string) (elapsed time.Duration, allocs, bytes uint64) {
+	defer func() {
+		if err := recover(); err != nil {
+			if luaErr, ok := err.(*api.LuaError); ok {
+				fatal(luaErr.Error())
+			}
+			panic(err)
+		}
+	}()
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	ls := state.New()
+	stdlib.OpenLibs(ls)
+	if ls.Load(data, "@"+file, "t") != api.LUA_OK {
+		fatal(ls.ToString(-1))
+	}
+	ls.Call(0, 0)
+
+	elapsed = time.Since(start)
+	runtime.ReadMemStats(&after)
+	return elapsed, after.Mallocs - before.Mallocs, after.TotalAlloc - before.TotalAlloc
+}
+
+func fatal(message string) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", progName, message)
+	os.Exit(1)
+}
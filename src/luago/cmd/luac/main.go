@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"luago/binary"
+	"luago/compiler"
+	"luago/vm"
+	"os"
+)
+
+const progName = "luac"
+
+var (
+	listing   = 0          // list bytecodes?
+	dumping   = true       // dump bytecodes?
+	stripping = false      // strip debug information?
+	output    = "luac.out" // default output file name
+)
+
+func main() {
+	files := doArgs(os.Args[1:])
+
+	defer func() {
+		if err := recover(); err != nil {
+			fatal(fmt.Sprint(err))
+		}
+	}()
+
+	protos := make([]*binary.Prototype, len(files))
+	for i, file := range files {
+		protos[i] = load(file)
+	}
+
+	proto := combine(protos)
+	if listing > 0 {
+		printFunction(proto, listing > 1)
+	}
+	if dumping {
+		if err := os.WriteFile(output, binary.Dump(proto, stripping), 0644); err != nil {
+			fatal(fmt.Sprintf("cannot write %s: %v", output, err))
+		}
+	}
+}
+
+func doArgs(args []string) []string {
+	version := false
+	i := 0
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if len(arg) == 0 || arg[0] != '-' { // end of options; keep it
+			break
+		} else if arg == "--" { // end of options; skip it
+			i++
+			break
+		} else if arg == "-" { // end of options; use stdin
+			break
+		} else if arg == "-l" { // list
+			listing++
+		} else if arg == "-o" { // output file
+			i++
+			if i == len(args) || args[i] == "" || args[i][0] == '-' && len(args[i]) > 1 {
+				usage("'-o' needs argument")
+			}
+			output = args[i]
+			if output == "-" { // use stdout
+				output = os.Stdout.Name()
+			}
+		} else if arg == "-p" { // parse only
+			dumping = false
+		} else if arg == "-s" { // strip debug information
+			stripping = true
+		} else if arg == "-v" { // show version
+			version = true
+		} else { // unknown option
+			usage(fmt.Sprintf("unrecognized option '%s'", arg))
+		}
+	}
+
+	files := args[i:]
+	if len(files) == 0 && (listing > 0 || !dumping) {
+		dumping = false
+		files = []string{output}
+	}
+	if version {
+		fmt.Println("Lua 5.3 (luago)")
+		if len(files) == 0 {
+			os.Exit(0)
+		}
+	}
+	if len(files) == 0 {
+		usage("no input files given")
+	}
+	return files
+}
+
+func usage(message string) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", progName, message)
+	fmt.Fprintf(os.Stderr, `usage: %s [options] [filenames]
+Available options are:
+  -l       list (use -l -l for full listing)
+  -o name  output to file 'name' (default is "luac.out")
+  -p       parse only
+  -s       strip debug information
+  -v       show version information
+  --       stop handling options
+  -        stop handling options and process stdin
+`, progName)
+	os.Exit(1)
+}
+
+func fatal(message string) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", progName, message)
+	os.Exit(1)
+}
+
+func load(file string) *binary.Prototype {
+	var data []byte
+	var err error
+	chunkName := "@" + file
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+		chunkName = "=stdin"
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		fatal(fmt.Sprintf("cannot open %s", file))
+	}
+
+	if binary.IsBinaryChunk(data) {
+		return binary.Parse(data, chunkName)
+	}
+	return compiler.Compile(string(data), chunkName)
+}
+
+/**
+ * Combines several main chunks into a single one that calls each of them in
+ * turn, passing its own `_ENV` along.
+ */
+func combine(protos []*binary.Prototype) *binary.Prototype {
+	if len(protos) == 1 {
+		return protos[0]
+	}
+
+	main := &binary.Prototype{
+		Source:       "=(" + progName + ")",
+		IsVararg:     1,
+		MaxStackSize: 2,
+		Upvalues:     []binary.Upvalue{{InStack: 1, Index: 0}},
+		Protos:       protos,
+		UpvalueNames: []string{"_ENV"},
+	}
+	for i, proto := range protos {
+		if len(proto.Upvalues) > 0 { // `_ENV` of the chunk is `_ENV` of the combined chunk
+			proto.Upvalues[0] = binary.Upvalue{InStack: 0, Index: 0}
+		}
+		main.Code = append(main.Code,
+			uint32(i<<14|vm.OP_CLOSURE),    // CLOSURE 0 i
+			uint32(1<<23|1<<14|vm.OP_CALL)) // CALL 0 1 1
+	}
+	main.Code = append(main.Code, uint32(1<<23|vm.OP_RETURN)) // RETURN 0 1
+	return main
+}
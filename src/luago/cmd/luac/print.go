@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"luago/binary"
+	"luago/number"
+	"luago/vm"
+	"strconv"
+	"strings"
+)
+
+func printFunction(proto *binary.Prototype, full bool) {
+	printHeader(proto)
+	printCode(proto)
+	if full {
+		printDebug(proto)
+	}
+	for _, subProto := range proto.Protos {
+		printFunction(subProto, full)
+	}
+}
+
+func printHeader(proto *binary.Prototype) {
+	funcType := "main"
+	if proto.LineBegin > 0 {
+		funcType = "function"
+	}
+
+	source := proto.Source
+	if source == "" {
+		source = "=?"
+	}
+	if source[0] == '@' || source[0] == '=' {
+		source = source[1:]
+	} else if source[0] == binary.LUA_SIGNATURE[0] {
+		source = "(bstring)"
+	} else {
+		source = "(string)"
+	}
+
+	varargFlag := ""
+	if proto.IsVararg > 0 {
+		varargFlag = "+"
+	}
+
+	fmt.Printf("\n%s <%s:%d,%d> (%d instruction%s at %p)\n",
+		funcType, source, proto.LineBegin, proto.LineEnd,
+		len(proto.Code), plural(len(proto.Code)), proto)
+	fmt.Printf("%d%s param%s, %d slot%s, %d upvalue%s, ",
+		proto.NumParams, varargFlag, plural(int(proto.NumParams)),
+		proto.MaxStackSize, plural(int(proto.MaxStackSize)),
+		len(proto.Upvalues), plural(len(proto.Upvalues)))
+	fmt.Printf("%d local%s, %d constant%s, %d function%s\n",
+		len(proto.LocVars), plural(len(proto.LocVars)),
+		len(proto.Constants), plural(len(proto.Constants)),
+		len(proto.Protos), plural(len(proto.Protos)))
+}
+
+func printCode(proto *binary.Prototype) {
+	for pc := 0; pc < len(proto.Code); pc++ {
+		inst := vm.Instruction(proto.Code[pc])
+
+		line := "-"
+		if pc < len(proto.LineInfo) && proto.LineInfo[pc] > 0 {
+			line = strconv.Itoa(int(proto.LineInfo[pc]))
+		}
+		fmt.Printf("\t%d\t[%s]\t%-9s\t", pc+1, line, inst.Name())
+
+		switch inst.Mode() {
+		case vm.IABC:
+			a, b, c := inst.ABC()
+			fmt.Printf("%d", a)
+			if inst.BMode() != vm.OpArgN {
+				fmt.Printf(" %d", rkOperand(b))
+			}
+			if inst.CMode() != vm.OpArgN {
+				fmt.Printf(" %d", rkOperand(c))
+			}
+		case vm.IABx:
+			a, bx := inst.ABx()
+			fmt.Printf("%d", a)
+			if inst.BMode() == vm.OpArgK {
+				fmt.Printf(" %d", -1-bx)
+			} else if inst.BMode() == vm.OpArgU {
+				fmt.Printf(" %d", bx)
+			}
+		case vm.IAsBx:
+			a, sbx := inst.AsBx()
+			fmt.Printf("%d %d", a, sbx)
+		case vm.IAx:
+			fmt.Printf("%d", -1-inst.Ax())
+		}
+
+		switch inst.Opcode() {
+		case vm.OP_LOADK:
+			_, bx := inst.ABx()
+			fmt.Printf("\t; %s", constantToString(proto, bx))
+		case vm.OP_GETUPVAL, vm.OP_SETUPVAL:
+			_, b, _ := inst.ABC()
+			fmt.Printf("\t; %s", upvalName(proto, b))
+		case vm.OP_GETTABUP:
+			_, b, c := inst.ABC()
+			fmt.Printf("\t; %s", upvalName(proto, b))
+			if isK(c) {
+				fmt.Printf(" %s", constantToString(proto, indexK(c)))
+			}
+		case vm.OP_SETTABUP:
+			a, b, c := inst.ABC()
+			fmt.Printf("\t; %s", upvalName(proto, a))
+			if isK(b) {
+				fmt.Printf(" %s", constantToString(proto, indexK(b)))
+			}
+			if isK(c) {
+				fmt.Printf(" %s", constantToString(proto, indexK(c)))
+			}
+		case vm.OP_GETTABLE, vm.OP_SELF:
+			_, _, c := inst.ABC()
+			if isK(c) {
+				fmt.Printf("\t; %s", constantToString(proto, indexK(c)))
+			}
+		case vm.OP_SETTABLE, vm.OP_ADD, vm.OP_SUB, vm.OP_MUL, vm.OP_MOD,
+			vm.OP_POW, vm.OP_DIV, vm.OP_IDIV, vm.OP_BAND, vm.OP_BOR,
+			vm.OP_BXOR, vm.OP_SHL, vm.OP_SHR, vm.OP_EQ, vm.OP_LT, vm.OP_LE:
+			_, b, c := inst.ABC()
+			if isK(b) || isK(c) {
+				fmt.Printf("\t; %s %s", rkToString(proto, b), rkToString(proto, c))
+			}
+		case vm.OP_JMP, vm.OP_FORLOOP, vm.OP_FORPREP, vm.OP_TFORLOOP:
+			_, sbx := inst.AsBx()
+			fmt.Printf("\t; to %d", sbx+pc+2)
+		case vm.OP_CLOSURE:
+			_, bx := inst.ABx()
+			fmt.Printf("\t; %p", proto.Protos[bx])
+		case vm.OP_SETLIST:
+			_, _, c := inst.ABC()
+			if c == 0 {
+				pc++
+				fmt.Printf("\t; %d", proto.Code[pc])
+			} else {
+				fmt.Printf("\t; %d", c)
+			}
+		case vm.OP_EXTRAARG:
+			fmt.Printf("\t; %s", constantToString(proto, inst.Ax()))
+		}
+
+		fmt.Println()
+	}
+}
+
+func printDebug(proto *binary.Prototype) {
+	fmt.Printf("constants (%d) for %p:\n", len(proto.Constants), proto)
+	for i := range proto.Constants {
+		fmt.Printf("\t%d\t%s\n", i+1, constantToString(proto, i))
+	}
+
+	fmt.Printf("locals (%d) for %p:\n", len(proto.LocVars), proto)
+	for i, locVar := range proto.LocVars {
+		fmt.Printf("\t%d\t%s\t%d\t%d\n", i, locVar.VarName, locVar.StartPC+1, locVar.EndPC+1)
+	}
+
+	fmt.Printf("upvalues (%d) for %p:\n", len(proto.Upvalues), proto)
+	for i, upval := range proto.Upvalues {
+		fmt.Printf("\t%d\t%s\t%d\t%d\n", i, upvalName(proto, i), upval.InStack, upval.Index)
+	}
+}
+
+func constantToString(proto *binary.Prototype, idx int) string {
+	switch k := proto.Constants[idx].(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(k)
+	case float64:
+		return number.FloatToString(k)
+	case int64:
+		return strconv.FormatInt(k, 10)
+	case string:
+		return quoteString(k)
+	default:
+		return "?"
+	}
+}
+
+func quoteString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			sb.WriteString("\\\"")
+		case '\\':
+			sb.WriteString("\\\\")
+		case '\a':
+			sb.WriteString("\\a")
+		case '\b':
+			sb.WriteString("\\b")
+		case '\f':
+			sb.WriteString("\\f")
+		case '\n':
+			sb.WriteString("\\n")
+		case '\r':
+			sb.WriteString("\\r")
+		case '\t':
+			sb.WriteString("\\t")
+		case '\v':
+			sb.WriteString("\\v")
+		default:
+			if c >= 0x20 && c < 0x7f {
+				sb.WriteByte(c)
+			} else {
+				fmt.Fprintf(&sb, "\\%03d", c)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+func upvalName(proto *binary.Prototype, idx int) string {
+	if idx < len(proto.UpvalueNames) && proto.UpvalueNames[idx] != "" {
+		return proto.UpvalueNames[idx]
+	}
+	return "-"
+}
+
+func isK(x int) bool {
+	return x > 0xff
+}
+
+func indexK(x int) int {
+	return x & 0xff
+}
+
+// constants are listed as negative numbers
+func rkOperand(x int) int {
+	if isK(x) {
+		return -1 - indexK(x)
+	}
+	return x
+}
+
+func rkToString(proto *binary.Prototype, x int) string {
+	if isK(x) {
+		return constantToString(proto, indexK(x))
+	}
+	return "-"
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
@@ -0,0 +1,127 @@
+/**
+ * luatest runs a Lua file with the standard libraries and a module `T`
+ * exposing parts of the Go API that scripts cannot reach otherwise, as the
+ * T library of the reference implementation's test suite. It is meant for
+ * the test_*.lua scripts under tests/ that `require "T"`.
+ *
+ *	go run ./cmd/luatest file.lua
+ */
+package main
+
+import (
+	"fmt"
+	"luago/api"
+	"luago/state"
+	"luago/stdlib"
+	"os"
+)
+
+const progName = "luatest"
+
+var tFuncs = map[string]api.GoFunction{
+	"newuserdata":   tNewUserdata,
+	"lightuserdata": tLightUserdata,
+	"testudata":     tTestUdata,
+	"checkudata":    tCheckUdata,
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintf(os.Stderr, "usage: %s file.lua\n", progName)
+		os.Exit(1)
+	}
+
+	file := os.Args[1]
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fatal(fmt.Sprintf("cannot open %s", file))
+	}
+
+	ls := state.New()
+	stdlib.OpenLibs(ls)
+	stdlib.PreloadModule(ls, "T", openT)
+	ls.PushGoFunction(msgHandler)
+	status := ls.Load(data, "@"+file, "t")
+	if status == api.LUA_OK {
+		status = ls.PCall(0, 0, 1)
+	}
+	stdlib.CloseFiles(ls)
+	if status != api.LUA_OK {
+		fatal(ls.ToString(-1))
+	}
+}
+
+// adds a traceback to the error message, as the message handler of lua.c
+func msgHandler(ls api.LuaState) int {
+	msg, ok := ls.ToStringX(1)
+	if !ok {
+		if ls.CallMeta(1, "__tostring") && ls.Type(-1) == api.LUA_TSTRING {
+			return 1
+		}
+		msg = fmt.Sprintf("(error object is a %s value)", ls.TypeName2(1))
+	}
+	ls.Traceback(ls, msg, 1)
+	return 1
+}
+
+func openT(ls api.LuaState) int {
+	ls.NewLib(tFuncs)
+	return 1
+}
+
+/**
+ * T.newuserdata ([tname [, s]])
+ * Returns a full userdata holding the string s, or no data at all, with the
+ * metatable registered as tname.
+ */
+func tNewUserdata(ls api.LuaState) int {
+	tname := ls.OptString(1, "")
+	var data interface{}
+	if !ls.IsNoneOrNil(2) {
+		data = ls.CheckString(2)
+	}
+	ls.NewUserdata(data)
+	if tname != "" {
+		ls.NewMetatable(tname)
+		ls.Pop(1)
+		ls.SetMetatable2(tname)
+	}
+	return 1
+}
+
+// T.lightuserdata (s)
+func tLightUserdata(ls api.LuaState) int {
+	ls.PushLightUserdata(ls.CheckString(1))
+	return 1
+}
+
+/**
+ * T.testudata (u, tname)
+ * Returns whether u is a userdata with the metatable tname, and its data.
+ */
+func tTestUdata(ls api.LuaState) int {
+	data, ok := ls.TestUdata(1, ls.CheckString(2))
+	ls.PushBoolean(ok)
+	pushData(ls, data)
+	return 2
+}
+
+// T.checkudata (u, tname)
+func tCheckUdata(ls api.LuaState) int {
+	data := ls.CheckUdata(1, ls.CheckString(2))
+	pushData(ls, data)
+	return 1
+}
+
+func pushData(ls api.LuaState, data interface{}) {
+	if s, ok := data.(string); ok {
+		ls.PushString(s)
+	} else {
+		ls.PushNil()
+	}
+}
+
+func fatal(message string) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", progName, message)
+	os.Exit(1)
+}
@@ -18,21 +18,25 @@ type BreakStmt struct {
 
 // '::' <Name> '::'
 type LabelStmt struct {
+	Line int
 	Name string
 }
 
 // goto <Name>
 type GotoStmt struct {
+	Line int
 	Name string
 }
 
 // return [<Expr> {','} <>]
 type ReturnStmt struct {
+	Line  int
 	Exprs []Expr
 }
 
 // do <Block> end
 type DoStmt struct {
+	Line  int
 	Block *Block
 }
 
@@ -40,18 +44,21 @@ type FuncCallStmt = FuncCallExpr
 
 // while <Expr> do <Block> end
 type WhileStmt struct {
+	Line  int
 	Expr  Expr
 	Block *Block
 }
 
 // repeat <Block> until <Expr>
 type RepeatStmt struct {
+	Line  int
 	Block *Block
 	Expr  Expr
 }
 
 // if <Expr> then <Block> {elseif <Expr> then <Block>} [else <Block>] end
 type IfStmt struct {
+	Line   int
 	Exprs  []Expr
 	Blocks []*Block
 }
@@ -69,10 +76,11 @@ type ForStmt struct {
 
 // for <NameList> in <ExprList> do <Block> end
 type ForListStmt struct {
-	LineOfDo int
-	NameList []string
-	ExprList []Expr
-	Block    *Block
+	LineOfFor int
+	LineOfDo  int
+	NameList  []string
+	ExprList  []Expr
+	Block     *Block
 }
 
 // local <NameList> ['=' <ExprList>]
@@ -82,6 +90,12 @@ type LocalDeclStmt struct {
 	ExprList []Expr
 }
 
+// local function <Name> <FuncBody>
+type LocalFuncDefStmt struct {
+	Name string
+	Expr *FunctionExpr
+}
+
 // <Vars> '=' <ExprList>
 type AssignStmt struct {
 	LastLine int
@@ -105,7 +119,7 @@ type FalseExpr struct {
 
 type IntegerExpr struct {
 	Line int
-	Val  int
+	Val  int64
 }
 
 type FloatExpr struct {
@@ -156,10 +170,12 @@ type FunctionExpr struct {
 }
 
 type ParenExpr struct {
+	Line int
 	Expr Expr
 }
 
 type IndexExpr struct {
+	Line    int // line of '.', ':' or '['
 	Expr    Expr
 	KeyExpr Expr
 }
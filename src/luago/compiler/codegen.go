@@ -1,6 +1,8 @@
 package compiler
 
 import (
+	"fmt"
+	"luago/api"
 	"luago/binary"
 	"luago/vm"
 )
@@ -10,15 +12,23 @@ type funcInfo struct {
 	usedRegs    int
 	maxRegs     int
 	scopeLv     int
+	scopeRegs   []int // number of active local variables when entering each scope
 	locVars     []*locVarInfo
 	locVarNames map[string]*locVarInfo
 	breaks      [][]int
+	labels      []*labelInfo // visible labels
+	gotos       []*gotoInfo  // pending gotos
 	parent      *funcInfo
 	upvalues    map[string]upvalInfo
 	insts       []uint32
+	lineNums    []uint32 // line of each instruction
+	line        int      // line of the code being generated
 	children    []*funcInfo
 	numParams   int
 	isVararg    bool
+	lineBegin   int
+	lineEnd     int
+	chunkName   string
 }
 
 type locVarInfo struct {
@@ -26,28 +36,72 @@ type locVarInfo struct {
 	name     string
 	scopeLv  int
 	slot     int
+	startPC  int
+	endPC    int
 	captured bool
 }
 
+type labelInfo struct {
+	name     string
+	line     int
+	pc       int // position of the next instruction
+	scopeLv  int
+	nActVars int
+}
+
+type gotoInfo struct {
+	name     string
+	line     int
+	pc       int // position of the jump instruction
+	scopeLv  int
+	nActVars int
+}
+
 type upvalInfo struct {
 	locVarslot int
 	upvalIndex int
 	index      int
 }
 
-func newFuncInfo(parent *funcInfo, numParams int, isVararg bool) *funcInfo {
+func newFuncInfo(parent *funcInfo, expr *FunctionExpr) *funcInfo {
 	return &funcInfo{
 		constants:   map[interface{}]int{},
+		scopeRegs:   make([]int, 1),
 		locVarNames: map[string]*locVarInfo{},
 		breaks:      make([][]int, 1),
 		parent:      parent,
+		chunkName:   chunkNameOf(parent),
 		upvalues:    map[string]upvalInfo{},
 		children:    []*funcInfo{},
-		numParams:   numParams,
-		isVararg:    isVararg,
+		numParams:   len(expr.ParamList),
+		isVararg:    expr.IsVararg,
+		line:        expr.Line,
+		lineBegin:   expr.Line,
+		lineEnd:     expr.LastLine,
 	}
 }
 
+func chunkNameOf(f *funcInfo) string {
+	if f == nil {
+		return ""
+	}
+	return f.chunkName
+}
+
+// reports a semantic error at the current line
+func (f *funcInfo) error(format string, a ...interface{}) {
+	panic(api.NewError(f.chunkName, f.line, fmt.Sprintf(format, a...)))
+}
+
+// sets the line of the following instructions and returns the previous one
+func (f *funcInfo) setLine(line int) int {
+	oldLine := f.line
+	if line > 0 {
+		f.line = line
+	}
+	return oldLine
+}
+
 func (f *funcInfo) indexOfConstant(k interface{}) int {
 	if idx, found := f.constants[k]; found {
 		return idx
@@ -60,7 +114,7 @@ func (f *funcInfo) indexOfConstant(k interface{}) int {
 func (f *funcInfo) allocReg() int {
 	f.usedRegs++
 	if f.usedRegs >= 255 {
-		panic("function or expression needs too many registers")
+		f.error("function or expression needs too many registers")
 	}
 	if f.usedRegs > f.maxRegs {
 		f.maxRegs = f.usedRegs
@@ -87,6 +141,7 @@ func (f *funcInfo) freeRegs(n int) {
 
 func (f *funcInfo) enterScope(breakable bool) {
 	f.scopeLv++
+	f.scopeRegs = append(f.scopeRegs, f.usedRegs)
 	if breakable {
 		f.breaks = append(f.breaks, []int{})
 	} else {
@@ -95,9 +150,12 @@ func (f *funcInfo) enterScope(breakable bool) {
 }
 
 func (f *funcInfo) leaveScope() {
+	a := f.getJmpArgA()
+
 	for name, locVar := range f.locVarNames {
 		for locVar != nil && locVar.scopeLv >= f.scopeLv {
 			f.freeReg()
+			locVar.endPC = f.pc() + 1
 			locVar = locVar.prev
 		}
 		if locVar == nil {
@@ -107,8 +165,6 @@ func (f *funcInfo) leaveScope() {
 		}
 	}
 
-	a := f.getJmpArgA()
-
 	pendingBreaks := f.breaks[f.scopeLv]
 	f.breaks = f.breaks[:f.scopeLv]
 
@@ -117,21 +173,137 @@ func (f *funcInfo) leaveScope() {
 		f.insts[pc] = encodeAsBx(vm.OP_JMP, a, sbx)
 	}
 
+	f.leaveLabelScope(a)
+
+	f.scopeRegs = f.scopeRegs[:f.scopeLv]
 	f.scopeLv--
 }
 
+/**
+ * Removes the labels of the scope being left and moves its pending gotos to
+ * the enclosing scope, making them close the captured local variables of
+ * this scope (`a` is the A argument computed by getJmpArgA).
+ */
+func (f *funcInfo) leaveLabelScope(a int) {
+	n := len(f.labels)
+	for n > 0 && f.labels[n-1].scopeLv >= f.scopeLv {
+		n--
+	}
+	f.labels = f.labels[:n]
+
+	for _, gt := range f.gotos {
+		if gt.scopeLv >= f.scopeLv {
+			gt.scopeLv = f.scopeLv - 1
+			gt.nActVars = f.scopeRegs[f.scopeLv]
+			if a > 0 {
+				f.closeGoto(gt.pc, a)
+			}
+		}
+	}
+
+	if f.scopeLv == 0 && len(f.gotos) > 0 { // leaving the function
+		gt := f.gotos[0]
+		f.error("no visible label '%s' for <goto> at line %d", gt.name, gt.line)
+	}
+}
+
+/**
+ * Declares a label at the current position. A label at the end of a block is
+ * considered to be outside the scope of the local variables of the block.
+ */
+func (f *funcInfo) addLabel(name string, line int, atBlockEnd bool) {
+	for _, label := range f.labels {
+		if label.scopeLv == f.scopeLv && label.name == name {
+			f.error("label '%s' already defined on line %d", name, label.line)
+		}
+	}
+
+	label := &labelInfo{
+		name:     name,
+		line:     line,
+		pc:       f.pc() + 1,
+		scopeLv:  f.scopeLv,
+		nActVars: f.usedRegs,
+	}
+	if atBlockEnd {
+		label.nActVars = f.scopeRegs[f.scopeLv]
+	}
+	f.labels = append(f.labels, label)
+
+	// resolve pending gotos of the current scope
+	pendingGotos := f.gotos[:0]
+	for _, gt := range f.gotos {
+		if gt.scopeLv == f.scopeLv && gt.name == name {
+			f.resolveGoto(gt, label)
+		} else {
+			pendingGotos = append(pendingGotos, gt)
+		}
+	}
+	f.gotos = pendingGotos
+}
+
+func (f *funcInfo) addGoto(name string, line int) {
+	f.emitJMP(0, 0)
+	gt := &gotoInfo{
+		name:     name,
+		line:     line,
+		pc:       f.pc(),
+		scopeLv:  f.scopeLv,
+		nActVars: f.usedRegs,
+	}
+
+	for i := len(f.labels) - 1; i >= 0; i-- {
+		if label := f.labels[i]; label.name == name { // backward jump
+			if gt.nActVars > label.nActVars {
+				f.closeGoto(gt.pc, label.nActVars+1)
+			}
+			f.resolveGoto(gt, label)
+			return
+		}
+	}
+	f.gotos = append(f.gotos, gt)
+}
+
+func (f *funcInfo) resolveGoto(gt *gotoInfo, label *labelInfo) {
+	if gt.nActVars < label.nActVars {
+		name := f.nameOfLocVar(gt.nActVars)
+		f.error("<goto %s> at line %d jumps into the scope of local '%s'", gt.name, gt.line, name)
+	}
+	f.fix(gt.pc, label.pc-gt.pc-1)
+}
+
+// makes the jump at pc close upvalues >= R(a-1)
+func (f *funcInfo) closeGoto(pc, a int) {
+	inst := vm.Instruction(f.insts[pc])
+	if oldA, sbx := inst.AsBx(); oldA == 0 || oldA > a {
+		f.insts[pc] = encodeAsBx(vm.OP_JMP, a, sbx)
+	}
+}
+
 func (f *funcInfo) addLocVar(name string) int {
 	newVar := &locVarInfo{
 		prev:    f.locVarNames[name],
 		name:    name,
 		scopeLv: f.scopeLv,
 		slot:    f.allocReg(),
+		startPC: f.pc() + 1,
 	}
 	f.locVars = append(f.locVars, newVar)
 	f.locVarNames[name] = newVar
 	return newVar.slot
 }
 
+func (f *funcInfo) nameOfLocVar(slot int) string {
+	for _, locVar := range f.locVarNames {
+		for v := locVar; v != nil; v = v.prev {
+			if v.slot == slot {
+				return v.name
+			}
+		}
+	}
+	return "?"
+}
+
 func (f *funcInfo) slotOfLocVar(name string) int {
 	if locVar, found := f.locVarNames[name]; found {
 		return locVar.slot
@@ -146,7 +318,7 @@ func (f *funcInfo) addBreak(pc int) {
 			return
 		}
 	}
-	panic("<break> at line ? not inside a loop!")
+	f.error("<break> at line %d not inside a loop", f.line)
 }
 
 func (f *funcInfo) indexOfUpvalue(name string) int {
@@ -156,6 +328,7 @@ func (f *funcInfo) indexOfUpvalue(name string) int {
 	if f.parent != nil {
 		index := len(f.upvalues)
 		if locVar, found := f.parent.locVarNames[name]; found {
+			locVar.captured = true
 			f.upvalues[name] = upvalInfo{locVar.slot, -1, index}
 			return index
 		}
@@ -203,17 +376,32 @@ func (f *funcInfo) fix(pc, sbx int) {
 	f.insts[pc] = (f.insts[pc] << 18 >> 18) | (uint32(sbx+vm.MAXARG_sBx) << 14)
 }
 
-func (f *funcInfo) toProto() *binary.Prototype {
+// turns the CALL at pc into a TAILCALL, keeping its function and arguments
+func (f *funcInfo) fixTailCall(pc int) {
+	a, b, _ := vm.Instruction(f.insts[pc]).ABC()
+	f.insts[pc] = encodeABC(vm.OP_TAILCALL, a, b, 0)
+}
+
+func (f *funcInfo) toProto(source string) *binary.Prototype {
 	proto := &binary.Prototype{
+		Source: source,
+		LineBegin: uint32(f.lineBegin),
+		LineEnd: uint32(f.lineEnd),
 		NumParams: byte(f.numParams),
 		MaxStackSize: byte(f.maxRegs),
 		Code: f.insts,
 		Constants: make([]interface{}, len(f.constants)),
 		Upvalues: make([]binary.Upvalue, len(f.upvalues)),
 		Protos: make([]*binary.Prototype, len(f.children)),
+		LineInfo: f.lineNums,
+		LocVars: make([]binary.LocVar, len(f.locVars)),
 		UpvalueNames: make([]string, len(f.upvalues)),
 	}
 
+	if proto.MaxStackSize < 2 { // registers 0/1 are always valid
+		proto.MaxStackSize = 2
+	}
+
 	if f.isVararg {
 		proto.IsVararg = 1
 	}
@@ -231,8 +419,16 @@ func (f *funcInfo) toProto() *binary.Prototype {
 		proto.UpvalueNames[uv.index] = name
 	}
 
+	for i, locVar := range f.locVars {
+		proto.LocVars[i] = binary.LocVar{
+			VarName: locVar.name,
+			StartPC: uint32(locVar.startPC),
+			EndPC:   uint32(locVar.endPC),
+		}
+	}
+
 	for i, subF := range f.children {
-		proto.Protos[i] = subF.toProto()
+		proto.Protos[i] = subF.toProto(source)
 	}
 
 	return proto
@@ -256,6 +452,7 @@ func encodeAx(opcode, ax int) uint32 {
 
 func (f *funcInfo) emit(inst uint32) {
 	f.insts = append(f.insts, inst)
+	f.lineNums = append(f.lineNums, uint32(f.line))
 }
 
 func (f *funcInfo) emitMOVE(a, b int) {
@@ -272,7 +469,7 @@ func (f *funcInfo) emitLOADK(a int, k interface{}) {
 }
 
 func (f *funcInfo) emitLOADBOOL(a, b, c int) {
-	f.emit(encodeABC(vm.OP_MOVE, a, b, c))
+	f.emit(encodeABC(vm.OP_LOADBOOL, a, b, c))
 }
 
 func (f *funcInfo) emitLOADNIL(a, n int) {
@@ -432,7 +629,12 @@ func (f *funcInfo) emitTFORLOOP(a, sbx int) {
 }
 
 func (f *funcInfo) emitSETLIST(a, b, c int) {
-	f.emit(encodeABC(vm.OP_SETLIST, a, b, c))
+	if c <= vm.MAXARG_C {
+		f.emit(encodeABC(vm.OP_SETLIST, a, b, c))
+	} else {
+		f.emit(encodeABC(vm.OP_SETLIST, a, b, 0))
+		f.emit(encodeAx(vm.OP_EXTRAARG, c))
+	}
 }
 
 func (f *funcInfo) emitCLOSURE(a, bx int) {
@@ -440,24 +642,54 @@ func (f *funcInfo) emitCLOSURE(a, bx int) {
 }
 
 func (f *funcInfo) emitVARARG(a, n int) {
-	f.emit(encodeABC(vm.OP_CLOSURE, a, n+1, 0))
+	f.emit(encodeABC(vm.OP_VARARG, a, n+1, 0))
 }
 
 
 func cgenBlock(block *Block, f *funcInfo) {
-	for _, stmt := range block.Stmts {
-		cgenStmt(stmt, f)
+	cgenStmts(block.Stmts, f, true)
+}
+
+/**
+ * Generates the statements of a block. `closed` tells whether the scope ends
+ * right after them, which is not the case for the body of a repeat-until.
+ */
+func cgenStmts(stmts []Stmt, f *funcInfo, closed bool) {
+	for i, stmt := range stmts {
+		if label, ok := stmt.(*LabelStmt); ok {
+			oldLine := f.setLine(label.Line) // for the errors of addLabel
+			f.addLabel(label.Name, label.Line, closed && _isVoidStmts(stmts[i+1:]))
+			f.setLine(oldLine)
+		} else {
+			cgenStmt(stmt, f)
+		}
 	}
 }
 
+// labels and empty statements generate no code
+func _isVoidStmts(stmts []Stmt) bool {
+	for _, stmt := range stmts {
+		switch stmt.(type) {
+		case *LabelStmt, *EmptyStmt:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 func cgenStmt(stmt Stmt, f *funcInfo) {
+	defer f.setLine(f.setLine(_lineOfStmt(stmt)))
+
 	switch stmt := stmt.(type) {
 	case *EmptyStmt:
 	case *BreakStmt:
 		f.emitJMP(0, 0)
 		f.addBreak(f.pc())
 	case *LabelStmt:
+		f.addLabel(stmt.Name, stmt.Line, false)
 	case *GotoStmt:
+		f.addGoto(stmt.Name, stmt.Line)
 	case *ReturnStmt:
 		nExprs := len(stmt.Exprs)
 		lastIsVarargOrFuncCall := false
@@ -475,6 +707,9 @@ func cgenStmt(stmt Stmt, f *funcInfo) {
 		}
 
 		if lastIsVarargOrFuncCall {
+			if _, ok := stmt.Exprs[0].(*FuncCallExpr); ok && nExprs == 1 { // tail call?
+				f.fixTailCall(f.pc())
+			}
 			f.emitRETURN(a, -1)
 		} else {
 			f.emitRETURN(a, nExprs)
@@ -511,7 +746,7 @@ func cgenStmt(stmt Stmt, f *funcInfo) {
 		f.enterScope(true)
 
 		pc1 := f.pc()
-		cgenBlock(stmt.Block, f)
+		cgenStmts(stmt.Block.Stmts, f, false)
 
 		r := f.allocReg()
 		cgenExpr(stmt.Expr, f, r, 1)
@@ -568,10 +803,10 @@ func cgenStmt(stmt Stmt, f *funcInfo) {
 			ExprList: []Expr{stmt.Init, stmt.Limit, stmt.Step},
 		}, f)
 
-		f.addLocVar(stmt.VarName)
-
 		f.emitFORPREP(a, 0)
 		pc := f.pc()
+		f.addLocVar(stmt.VarName)
+
 		cgenBlock(stmt.Block, f)
 		f.closeOpenUpvalues()
 		f.fix(pc, f.pc() - pc)
@@ -588,13 +823,13 @@ func cgenStmt(stmt Stmt, f *funcInfo) {
 			ExprList: stmt.ExprList,
 		}, f);
 
+		f.emitJMP(0, 0)
+		pc := f.pc()
+
 		for _, name := range stmt.NameList {
 			f.addLocVar(name)
 		}
 
-		f.emitJMP(0, 0)
-		pc := f.pc()
-
 		cgenBlock(stmt.Block, f)
 		f.closeOpenUpvalues()
 		f.fix(pc, f.pc() - pc)
@@ -602,6 +837,10 @@ func cgenStmt(stmt Stmt, f *funcInfo) {
 		f.emitTFORLOOP(a + 2, pc - f.pc() - 1)
 
 		f.leaveScope()
+	case *LocalFuncDefStmt: // the function can refer to itself
+		r := f.addLocVar(stmt.Name)
+		cgenExpr(stmt.Expr, f, r, 1)
+		f.locVarNames[stmt.Name].startPC = f.pc() + 1
 	case *LocalDeclStmt:
 		nNames := len(stmt.NameList)
 		nExprs := len(stmt.ExprList)
@@ -715,6 +954,8 @@ func cgenStmt(stmt Stmt, f *funcInfo) {
 }
 
 func cgenExpr(expr Expr, f *funcInfo, a, n int) {
+	defer f.setLine(f.setLine(_lineOfExpr(expr)))
+
 	switch expr := expr.(type) {
 	case *NilExpr:
 		f.emitLOADNIL(a, n)
@@ -734,11 +975,11 @@ func cgenExpr(expr Expr, f *funcInfo, a, n int) {
 		} else if idx := f.indexOfUpvalue(expr.Name); idx >= 0 {
 			f.emitGETUPVAL(a, idx)
 		} else { // x => _ENV["x"]
-			cgenExpr(&IndexExpr{&NameExpr{0, "_ENV"}, &StringExpr{0, expr.Name}}, f, a, n) // TODO: Line
+			cgenExpr(&IndexExpr{expr.Line, &NameExpr{expr.Line, "_ENV"}, &StringExpr{expr.Line, expr.Name}}, f, a, n)
 		}
 	case *VarargExpr:
 		if !f.isVararg {
-			panic("cannot use '...' outside a vararg function")
+			f.error("cannot use '...' outside a vararg function near '...'")
 		}
 		f.emitVARARG(a, n)
 	case *UnopExpr:
@@ -807,9 +1048,9 @@ func cgenExpr(expr Expr, f *funcInfo, a, n int) {
 				case TOKEN_LE:
 					f.emitLE(1, a, c)
 				case '>':
-					f.emitLE(1, c, a)
-				case TOKEN_GE:
 					f.emitLT(1, c, a)
+				case TOKEN_GE:
+					f.emitLE(1, c, a)
 				}
 				f.emitJMP(0, 1)
 				f.emitLOADBOOL(a, 0, 1)
@@ -844,31 +1085,28 @@ func cgenExpr(expr Expr, f *funcInfo, a, n int) {
 				}
 
 				if arrIdx % vm.LFIELDS_PER_FLUSH == 0 || arrIdx == nArr {
-					b := (arrIdx - 1) % vm.LFIELDS_PER_FLUSH + 1
+					n := (arrIdx - 1) % vm.LFIELDS_PER_FLUSH + 1
+					b := n
 					if i == size - 1 && multRet {
 						b = 0
 					}
-					c := (arrIdx - 1) / vm.LFIELDS_PER_FLUSH
+					c := (arrIdx - 1) / vm.LFIELDS_PER_FLUSH + 1
 					f.emitSETLIST(a, b, c)
+					f.freeRegs(n)
 				}
 			} else {
 				b := f.allocReg()
-				cgenExpr(keyExpr, f, b, -1)
+				cgenExpr(keyExpr, f, b, 1)
 				c := f.allocReg()
-				cgenExpr(valExpr, f, c, -1)
+				cgenExpr(valExpr, f, c, 1)
 				f.emitSETTABLE(a, b, c)
 				f.freeRegs(2)
 			}
 		}
 	case *FunctionExpr:
 		bx := len(f.children)
-		subF := newFuncInfo(f, len(expr.ParamList), expr.IsVararg)
-		for _, paramName := range expr.ParamList {
-			subF.addLocVar(paramName)
-		}
-		cgenBlock(expr.Block, subF)
-		subF.leaveScope()
-		subF.emitRETURN(0, 0)
+		subF := newFuncInfo(f, expr)
+		cgenFuncBody(expr, subF)
 		f.children = append(f.children, subF)
 		f.emitCLOSURE(a, bx)
 	case *ParenExpr:
@@ -909,6 +1147,76 @@ func cgenExpr(expr Expr, f *funcInfo, a, n int) {
 	}
 }
 
+func _lineOfStmt(stmt Stmt) int {
+	switch stmt := stmt.(type) {
+	case *BreakStmt:
+		return stmt.Line
+	case *LabelStmt:
+		return stmt.Line
+	case *GotoStmt:
+		return stmt.Line
+	case *ReturnStmt:
+		return stmt.Line
+	case *DoStmt:
+		return stmt.Line
+	case *FuncCallStmt:
+		return stmt.Line
+	case *WhileStmt:
+		return stmt.Line
+	case *RepeatStmt:
+		return stmt.Line
+	case *IfStmt:
+		return stmt.Line
+	case *ForStmt:
+		return stmt.LineOfFor
+	case *ForListStmt:
+		return stmt.LineOfFor
+	case *LocalFuncDefStmt:
+		return stmt.Expr.Line
+	case *LocalDeclStmt:
+		return stmt.LastLine
+	case *AssignStmt:
+		return stmt.LastLine
+	}
+	return 0
+}
+
+func _lineOfExpr(expr Expr) int {
+	switch expr := expr.(type) {
+	case *NilExpr:
+		return expr.Line
+	case *TrueExpr:
+		return expr.Line
+	case *FalseExpr:
+		return expr.Line
+	case *IntegerExpr:
+		return expr.Line
+	case *FloatExpr:
+		return expr.Line
+	case *StringExpr:
+		return expr.Line
+	case *NameExpr:
+		return expr.Line
+	case *VarargExpr:
+		return expr.Line
+	case *UnopExpr:
+		return expr.Line
+	case *BinopExpr:
+		return expr.Line
+	case *TableExpr:
+		return expr.Line
+	case *FunctionExpr:
+		return expr.Line
+	case *ParenExpr:
+		return expr.Line
+	case *IndexExpr:
+		return expr.Line
+	case *FuncCallExpr:
+		return expr.Line
+	}
+	return 0
+}
+
 func _isVarargOrFuncCall(expr Expr) bool {
 	switch expr.(type) {
 	case *VarargExpr, *FuncCallExpr:
@@ -917,13 +1225,26 @@ func _isVarargOrFuncCall(expr Expr) bool {
 	return false
 }
 
-func GenProto(block *Block) *binary.Prototype {
+func cgenFuncBody(expr *FunctionExpr, f *funcInfo) {
+	for _, paramName := range expr.ParamList {
+		f.addLocVar(paramName)
+	}
+	cgenBlock(expr.Block, f)
+	f.setLine(expr.Block.LastLine)
+	f.emitRETURN(0, 0)
+	f.leaveScope()
+}
+
+func GenProto(block *Block, chunkName string) *binary.Prototype {
 	expr := &FunctionExpr{
 		IsVararg: true,
 		Block: block,
 	}
-	f := newFuncInfo(nil, 0, false)
-	f.addLocVar("_ENV")
-	cgenExpr(expr, f, 0, 1)
-	return f.children[0].toProto()
+	env := newFuncInfo(nil, &FunctionExpr{})
+	env.chunkName = chunkName
+	env.addLocVar("_ENV")
+	f := newFuncInfo(env, expr)
+	f.indexOfUpvalue("_ENV") // the main chunk always has `_ENV` as its first upvalue
+	cgenFuncBody(expr, f)
+	return f.toProto(chunkName)
 }
@@ -3,5 +3,5 @@ package compiler
 import "luago/binary"
 
 func Compile(chunk, chunkName string) *binary.Prototype {
-	return GenProto(Parse(chunk, chunkName))
+	return GenProto(Parse(chunk, chunkName), chunkName)
 }
@@ -3,7 +3,8 @@ package compiler
 import (
 	"bytes"
 	"fmt"
-	"regexp"
+	"luago/api"
+	"luago/number"
 	"strings"
 )
 
@@ -73,12 +74,11 @@ var keywords = map[string]int{
 	"while":    TOKEN_WHILE,
 }
 
-var reNumber = regexp.MustCompile(`^(0[Xx][:xdigit:]*(\.[:xdigit:]*)?([Pp][+\-]?\d+)?|\d*(\.\d*)?([Ee][+\-]?\d+)?)`)
-
 type Lexer struct {
 	chunk     string
 	chunkName string
 	line      int
+	lastLine  int // line of the last token consumed
 	LookAhead struct {
 		Line  int
 		Kind  int
@@ -95,6 +95,7 @@ func NewLexer(chunk, chunkName string) *Lexer {
 }
 
 func (lexer *Lexer) Next() {
+	lexer.lastLine = lexer.LookAhead.Line
 	lexer.LookAhead.Line, lexer.LookAhead.Kind, lexer.LookAhead.Value = lexer.lex()
 }
 
@@ -116,11 +117,12 @@ func (lexer *Lexer) lex() (line, kind int, token string) {
 					sep := lexer.scanSep()
 					if lexer.peek() == '[' {
 						lexer.readLongString(sep, "comment")
+						break
 					}
 				}
 
 				// short comment
-				for char := lexer.peek(); char == '\n' || char == '\r'; char = lexer.peek() {
+				for char := lexer.peek(); char != eoz && char != '\n' && char != '\r'; char = lexer.peek() {
 					lexer.skip(1)
 				}
 			} else {
@@ -131,7 +133,7 @@ func (lexer *Lexer) lex() (line, kind int, token string) {
 			if lexer.peek() == '[' {
 				return lexer.line, TOKEN_STRING, lexer.readLongString(sep, "string")
 			} else if sep > 0 {
-				lexer.error("invalid long string delimiter")
+				lexer.error("invalid long string delimiter near '[%s'", strings.Repeat("=", sep))
 			} else {
 				return lexer.line, '[', "["
 			}
@@ -144,12 +146,16 @@ func (lexer *Lexer) lex() (line, kind int, token string) {
 		case '<':
 			if lexer.test("<=") {
 				return lexer.take(2, TOKEN_LE)
+			} else if lexer.test("<<") {
+				return lexer.take(2, TOKEN_SHL)
 			} else {
 				return lexer.takeChar()
 			}
 		case '>':
 			if lexer.test(">=") {
 				return lexer.take(2, TOKEN_GE)
+			} else if lexer.test(">>") {
+				return lexer.take(2, TOKEN_SHR)
 			} else {
 				return lexer.takeChar()
 			}
@@ -178,7 +184,7 @@ func (lexer *Lexer) lex() (line, kind int, token string) {
 				return lexer.take(3, TOKEN_VARARG)
 			} else if lexer.test("..") {
 				return lexer.take(2, TOKEN_CONCAT)
-			} else if len(lexer.chunk) > 1 && isDigit(int(lexer.chunk[0])) {
+			} else if len(lexer.chunk) > 1 && isDigit(int(lexer.chunk[1])) {
 				return lexer.line, TOKEN_NUMBER, lexer.readNumeral()
 			} else {
 				return lexer.takeChar()
@@ -208,10 +214,53 @@ func (lexer *Lexer) lex() (line, kind int, token string) {
 	}
 }
 
+func tokenToString(kind int) string {
+	switch kind {
+	case TOKEN_EOF:
+		return "<eof>"
+	case TOKEN_NAME:
+		return "<name>"
+	case TOKEN_NUMBER:
+		return "<number>"
+	case TOKEN_STRING:
+		return "<string>"
+	case TOKEN_VARARG:
+		return "'...'"
+	case TOKEN_DBCOLON:
+		return "'::'"
+	case TOKEN_IDIV:
+		return "'//'"
+	case TOKEN_SHR:
+		return "'>>'"
+	case TOKEN_SHL:
+		return "'<<'"
+	case TOKEN_CONCAT:
+		return "'..'"
+	case TOKEN_LE:
+		return "'<='"
+	case TOKEN_GE:
+		return "'>='"
+	case TOKEN_EQ:
+		return "'=='"
+	case TOKEN_NE:
+		return "'~='"
+	}
+	for word, k := range keywords {
+		if k == kind {
+			return "'" + word + "'"
+		}
+	}
+	return fmt.Sprintf("'%c'", kind)
+}
+
 func (lexer *Lexer) Line() int {
 	return lexer.line
 }
 
+func (lexer *Lexer) LastLine() int {
+	return lexer.lastLine
+}
+
 func (lexer *Lexer) peek() int {
 	if len(lexer.chunk) == 0 {
 		return -1
@@ -256,12 +305,46 @@ func (lexer *Lexer) incLine(oldChar int) {
 }
 
 func (lexer *Lexer) error(f string, a ...interface{}) {
-	panic(fmt.Sprintf("%s:%d: %s", lexer.chunkName, lexer.line, fmt.Sprintf(f, a...)))
+	panic(api.NewError(lexer.chunkName, lexer.line, fmt.Sprintf(f, a...)))
+}
+
+// reports an error at the look-ahead token, as in "'=' expected near 'x'"
+func (lexer *Lexer) syntaxError(f string, a ...interface{}) {
+	near := "<eof>"
+	if lexer.LookAhead.Kind != TOKEN_EOF {
+		near = "'" + lexer.LookAhead.Value + "'"
+	}
+	msg := fmt.Sprintf(f, a...) + " near " + near
+	panic(api.NewError(lexer.chunkName, lexer.LookAhead.Line, msg))
 }
 
 func (lexer *Lexer) readNumeral() string {
-	token := reNumber.FindString(lexer.chunk)
-	lexer.skip(len(token))
+	expo := "Ee"
+	n := 1
+	if lexer.test("0x") || lexer.test("0X") { // hexadecimal?
+		expo = "Pp"
+		n = 2
+	}
+	for n < len(lexer.chunk) {
+		char := lexer.chunk[n]
+		if strings.IndexByte(expo, char) >= 0 { // exponent part?
+			n++
+			if n < len(lexer.chunk) && (lexer.chunk[n] == '+' || lexer.chunk[n] == '-') {
+				n++ // optional exponent sign
+			}
+		} else if _, ok := toHex(int(char)); ok || char == '.' {
+			n++
+		} else {
+			break
+		}
+	}
+	token := lexer.chunk[:n]
+	if _, ok := number.ParseInteger(token); !ok {
+		if _, ok := number.ParseFloat(token); !ok {
+			lexer.error("malformed number near '%s'", token)
+		}
+	}
+	lexer.skip(n)
 	return token
 }
 
@@ -279,10 +362,11 @@ func (lexer *Lexer) readShortString() string {
 
 		switch char {
 		case eoz:
-			lexer.error("unfinished string")
+			lexer.error("unfinished string near <eof>")
 		case '\n', '\r':
-			lexer.error("unfinished string")
+			lexer.stringError("unfinished string", del, &buf, "")
 		case '\\':
+			esc := lexer.chunk
 			lexer.skip(1) // skip '\\'
 			switch char := lexer.peek(); char {
 			case 'a':
@@ -306,7 +390,7 @@ func (lexer *Lexer) readShortString() string {
 			case 'u': // \u{hhh}
 				lexer.skip(1) // skip 'u'
 				if lexer.peek() != '{' {
-					lexer.error("missing '{'")
+					lexer.stringError("missing '{'", del, &buf, esc)
 				}
 				lexer.skip(1) // skip '{'
 				if r, ok := toHex(lexer.peek()); ok {
@@ -314,20 +398,21 @@ func (lexer *Lexer) readShortString() string {
 					for {
 						c := lexer.peek()
 						if d, ok := toHex(c); ok {
+							if r > 0x7fffffff>>4 {
+								lexer.stringError("UTF-8 value too large", del, &buf, esc)
+							}
 							lexer.skip(1)
 							r = r*16 + d
-							if r > 0x10ffff {
-								lexer.error("UTF-8 value too large")
-							}
 						} else if c != '}' {
-							lexer.error("missing '{'")
+							lexer.stringError("missing '}'", del, &buf, esc)
 						} else {
 							lexer.skip(1) // skip '}'
-							buf.WriteRune(rune(r))
+							buf.WriteString(number.UTF8Esc(uint32(r)))
+							break
 						}
 					}
 				} else {
-					lexer.error("hexadecimal digit expected")
+					lexer.stringError("hexadecimal digit expected", del, &buf, esc)
 				}
 			case 'v':
 				buf.WriteByte('\v')
@@ -340,7 +425,7 @@ func (lexer *Lexer) readShortString() string {
 						lexer.skip(1)
 						r = r*16 + d
 					} else {
-						lexer.error("hexadecimal digit expected")
+						lexer.stringError("hexadecimal digit expected", del, &buf, esc)
 					}
 				}
 				buf.WriteByte(byte(r))
@@ -375,26 +460,18 @@ func (lexer *Lexer) readShortString() string {
 				default:
 					break
 				}
-			case '\n':
-				lexer.skip(1)
-				if lexer.peek() == '\r' {
-					lexer.skip(1)
-				}
-				buf.WriteByte('\n')
-			case '\r':
-				lexer.skip(1)
-				if lexer.peek() == '\n' {
-					lexer.skip(1)
-				}
+			case '\n', '\r':
+				lexer.incLine(char)
 				buf.WriteByte('\n')
 			case '"', '\'', '\\':
 				buf.WriteByte(byte(char))
 				lexer.skip(1)
+			case eoz: // reported as an unfinished string
 			default:
 				if isDigit(char) { // '\ddd'
 					r := 0
 					for j := 0; j < 3; j++ {
-						if c := lexer.peek(); isDigit(char) {
+						if c := lexer.peek(); isDigit(c) {
 							r = 10*r + int(c-'0')
 							lexer.skip(1)
 						} else {
@@ -403,12 +480,12 @@ func (lexer *Lexer) readShortString() string {
 					}
 
 					if r > 0xff {
-						lexer.error("decimal escape too large")
+						lexer.stringError("decimal escape too large", del, &buf, esc)
 					}
 
 					buf.WriteByte(byte(r))
 				} else {
-					lexer.error("invalid escape sequence")
+					lexer.stringError("invalid escape sequence", del, &buf, esc)
 				}
 			}
 		default:
@@ -418,6 +495,23 @@ func (lexer *Lexer) readShortString() string {
 	}
 }
 
+/**
+ * Reports an error in a short string near the part of it read so far, as luac
+ * does: the delimiter, the string and, from esc, the escape sequence up to
+ * the current character.
+ */
+func (lexer *Lexer) stringError(msg string, del int, buf *bytes.Buffer, esc string) {
+	near := string(rune(del)) + buf.String()
+	if esc != "" {
+		n := len(esc) - len(lexer.chunk) // the escape sequence read so far
+		if len(lexer.chunk) > 0 {
+			n++ // and the current character
+		}
+		near += esc[:n]
+	}
+	lexer.error("%s near '%s'", msg, near)
+}
+
 func (lexer *Lexer) readLongString(headSep int, what string) string {
 	line := lexer.line
 
@@ -431,7 +525,7 @@ func (lexer *Lexer) readLongString(headSep int, what string) string {
 	for {
 		switch char := lexer.peek(); char {
 		case eoz:
-			lexer.error("unfinished long %s (starting at line %d)", what, line)
+			lexer.error("unfinished long %s (starting at line %d) near <eof>", what, line)
 		case ']':
 			if tailSep := lexer.scanSep(); tailSep == headSep && lexer.peek() == ']' {
 				lexer.skip(1) // skip 2nd ']'
@@ -445,6 +539,7 @@ func (lexer *Lexer) readLongString(headSep int, what string) string {
 			lexer.incLine(char)
 		default:
 			buf.WriteByte(byte(char))
+			lexer.skip(1)
 		}
 	}
 }
@@ -460,7 +555,7 @@ func (lexer *Lexer) scanSep() int {
 }
 
 func isAlpha(char int) bool {
-	return 'Z' <= char && char <= 'Z' || 'a' <= char && char <= 'z'
+	return 'A' <= char && char <= 'Z' || 'a' <= char && char <= 'z'
 }
 
 func isDigit(char int) bool {
@@ -484,9 +579,9 @@ func toHex(char int) (int, bool) {
 	if isDigit(char) {
 		return char - '0', true
 	} else if 'A' <= char && char <= 'F' {
-		return char - 'A', true
+		return char - 'A' + 10, true
 	} else if 'a' <= char && char <= 'f' {
-		return char - 'a', true
+		return char - 'a' + 10, true
 	} else {
 		return -1, false
 	}
@@ -1,5 +1,7 @@
 package compiler
 
+import "luago/number"
+
 func Parse(chunk, chunkName string) *Block {
 	lexer := NewLexer(chunk, chunkName)
 	lexer.Next()
@@ -20,13 +22,13 @@ func parseBlock(lexer *Lexer) *Block {
 		}
 	}
 	return &Block{
-		LastLine: lexer.line,
+		LastLine: lexer.LookAhead.Line,
 		Stmts:    stmts,
 	}
 }
 
 func parseStmt(lexer *Lexer) Stmt {
-	line := lexer.line
+	line := lexer.LookAhead.Line
 	switch lexer.LookAhead.Kind {
 	case ';':
 		lexer.Next() // skip ';'
@@ -42,7 +44,7 @@ func parseStmt(lexer *Lexer) Stmt {
 		varName := checkName(lexer)
 		switch lexer.LookAhead.Kind {
 		case ',', TOKEN_IN:
-			return parseForListStmt(lexer, varName)
+			return parseForListStmt(lexer, varName, line)
 		default: // '='
 			return parseForStmt(lexer, varName, line)
 		}
@@ -58,18 +60,22 @@ func parseStmt(lexer *Lexer) Stmt {
 			return parseLocalStmt(lexer)
 		}
 	case TOKEN_DBCOLON:
+		line := lexer.LookAhead.Line
 		lexer.Next() // skip '::'
 		name := checkName(lexer)
 		checkNext(lexer, TOKEN_DBCOLON)
-		return &LabelStmt{name}
+		return &LabelStmt{line, name}
 	case TOKEN_RETURN:
 		return parseReturnStmt(lexer)
 	case TOKEN_BREAK:
-		return &BreakStmt{lexer.line}
+		line := lexer.LookAhead.Line
+		lexer.Next() // skip BREAK
+		return &BreakStmt{line}
 	case TOKEN_GOTO:
+		line := lexer.LookAhead.Line
 		lexer.Next() // skip GOTO
 		name := checkName(lexer)
-		return &GotoStmt{name}
+		return &GotoStmt{line, name}
 	default:
 		return parseExprStmt(lexer)
 	}
@@ -80,6 +86,7 @@ func parseIfStmt(lexer *Lexer) *IfStmt {
 	var exprs []Expr
 	var blocks []*Block
 
+	line := lexer.LookAhead.Line
 	lexer.Next() // skip IF
 
 	exprs = append(exprs, parseExpr(lexer))
@@ -97,27 +104,29 @@ func parseIfStmt(lexer *Lexer) *IfStmt {
 		blocks = append(blocks, parseBlock(lexer))
 	}
 
-	checkNext(lexer, TOKEN_END)
+	checkMatch(lexer, TOKEN_END, TOKEN_IF, line)
 
-	return &IfStmt{exprs, blocks}
+	return &IfStmt{line, exprs, blocks}
 }
 
 // WHILE cond DO block END
 func parseWhileStmt(lexer *Lexer) *WhileStmt {
+	line := lexer.LookAhead.Line
 	lexer.Next() // skip WHILE
 	expr := parseExpr(lexer)
 	checkNext(lexer, TOKEN_DO)
 	block := parseBlock(lexer)
-	checkNext(lexer, TOKEN_END)
-	return &WhileStmt{expr, block}
+	checkMatch(lexer, TOKEN_END, TOKEN_WHILE, line)
+	return &WhileStmt{line, expr, block}
 }
 
 // DO block END
 func parseDoStmt(lexer *Lexer) *DoStmt {
+	line := lexer.LookAhead.Line
 	lexer.Next() // skip DO
 	block := parseBlock(lexer)
-	checkNext(lexer, TOKEN_END)
-	return &DoStmt{block}
+	checkMatch(lexer, TOKEN_END, TOKEN_DO, line)
+	return &DoStmt{line, block}
 }
 
 // FOR name '=' expr, expr [',' expr] DO block END
@@ -130,12 +139,12 @@ func parseForStmt(lexer *Lexer, varName string, lineOfFor int) *ForStmt {
 	if testNext(lexer, ',') {
 		step = parseExpr(lexer) // optional step
 	} else { // default step = 1
-		step = &IntegerExpr{lexer.line, 1}
+		step = &IntegerExpr{lexer.LookAhead.Line, 1}
 	}
-	lineOfDo := lexer.Line()
+	lineOfDo := lexer.LookAhead.Line
 	checkNext(lexer, TOKEN_DO)
 	block := parseBlock(lexer)
-	checkNext(lexer, TOKEN_END)
+	checkMatch(lexer, TOKEN_END, TOKEN_FOR, lineOfFor)
 	return &ForStmt{
 		LineOfFor: lineOfFor,
 		LineOfDo:  lineOfDo,
@@ -148,61 +157,67 @@ func parseForStmt(lexer *Lexer, varName string, lineOfFor int) *ForStmt {
 }
 
 // FOR name {',' name} IN expr, [',' expr] DO block END
-func parseForListStmt(lexer *Lexer, varName string) *ForListStmt {
+func parseForListStmt(lexer *Lexer, varName string, lineOfFor int) *ForListStmt {
 	names := []string{varName}
 	for testNext(lexer, ',') {
 		names = append(names, checkName(lexer))
 	}
 	checkNext(lexer, TOKEN_IN)
 	exprs := parseExprList(lexer)
-	lineOfDo := lexer.Line()
+	lineOfDo := lexer.LookAhead.Line
 	checkNext(lexer, TOKEN_DO)
 	block := parseBlock(lexer)
-	checkNext(lexer, TOKEN_END)
+	checkMatch(lexer, TOKEN_END, TOKEN_FOR, lineOfFor)
 	return &ForListStmt{
-		LineOfDo: lineOfDo,
-		NameList: names,
-		ExprList: exprs,
-		Block:    block,
+		LineOfFor: lineOfFor,
+		LineOfDo:  lineOfDo,
+		NameList:  names,
+		ExprList:  exprs,
+		Block:     block,
 	}
 }
 
 // REPEAT block UNTIL cond
 func parseRepeatStmt(lexer *Lexer) *RepeatStmt {
+	line := lexer.LookAhead.Line
 	lexer.Next() // skip REPEAT
 	block := parseBlock(lexer)
-	checkNext(lexer, TOKEN_UNTIL)
+	checkMatch(lexer, TOKEN_UNTIL, TOKEN_REPEAT, line)
 	expr := parseExpr(lexer)
-	return &RepeatStmt{block, expr}
+	return &RepeatStmt{line, block, expr}
 }
 
 // FUNCTION NAME {'.' NAME} [':' NAME] body
 func parseFunctionStmt(lexer *Lexer) *AssignStmt {
-	line := lexer.line
+	line := lexer.LookAhead.Line
 	lexer.Next() // skip FUNCTION
 
-	var fnExpr Expr = NameExpr{lexer.line, checkName(lexer)}
+	var fnExpr Expr = parseNameExpr(lexer)
 
-	for testNext(lexer, '.') {
+	for lexer.LookAhead.Kind == '.' {
+		dotLine := lexer.LookAhead.Line // before parseFieldName moves on
 		fnExpr = &IndexExpr{
+			Line:    dotLine,
 			Expr:    fnExpr,
-			KeyExpr: &StringExpr{lexer.line, checkName(lexer)},
+			KeyExpr: parseFieldName(lexer),
 		}
 	}
 
 	isMethod := false
-	if testNext(lexer, ':') {
+	if lexer.LookAhead.Kind == ':' {
 		isMethod = true
+		colonLine := lexer.LookAhead.Line
 		fnExpr = &IndexExpr{
+			Line:    colonLine,
 			Expr:    fnExpr,
-			KeyExpr: &StringExpr{lexer.line, checkName(lexer)},
+			KeyExpr: parseFieldName(lexer),
 		}
 	}
 
 	fdExpr := parseFunctionExpr(lexer, isMethod, line)
 
 	return &AssignStmt{
-		// TODO: line
+		LastLine: line, // the function is stored at the line of FUNCTION, as in luac
 		Vars:     []Expr{fnExpr},
 		ExprList: []Expr{fdExpr},
 	}
@@ -222,26 +237,22 @@ func parseLocalStmt(lexer *Lexer) *LocalDeclStmt {
 		exprList = parseExprList(lexer)
 	}
 	return &LocalDeclStmt{
-		LastLine: lexer.line,
+		LastLine: lexer.LastLine(),
 		NameList: nameList,
 		ExprList: exprList,
 	}
 }
 
 // LOCAL FUNCTION NAME body
-func parseLocalFunctionStmt(lexer *Lexer, line int) *LocalDeclStmt {
+func parseLocalFunctionStmt(lexer *Lexer, line int) *LocalFuncDefStmt {
 	name := checkName(lexer)
 	expr := parseFunctionExpr(lexer, false, line)
-
-	return &LocalDeclStmt{
-		// TODO: LastLine int
-		NameList: []string{name},
-		ExprList: []Expr{expr},
-	}
+	return &LocalFuncDefStmt{name, expr}
 }
 
 // RETURN [expr {',' expr}] [';']
 func parseReturnStmt(lexer *Lexer) *ReturnStmt {
+	line := lexer.LookAhead.Line
 	lexer.Next() // skip RETURN
 	var exprs []Expr
 	if !blockFollow(lexer) && lexer.LookAhead.Kind != ';' {
@@ -251,37 +262,41 @@ func parseReturnStmt(lexer *Lexer) *ReturnStmt {
 		}
 	}
 	testNext(lexer, ';') // skip optional ';'
-	return &ReturnStmt{exprs}
+	return &ReturnStmt{line, exprs}
 }
 
 func parseExprStmt(lexer *Lexer) Stmt {
 	expr := parseSuffixedExpr(lexer)
 
-	if stmt, ok := expr.(*FuncCallExpr); ok {
-		return stmt
+	if kind := lexer.LookAhead.Kind; kind != '=' && kind != ',' {
+		if stmt, ok := expr.(*FuncCallExpr); ok {
+			return stmt
+		}
+		lexer.syntaxError("syntax error") // neither a call nor an assignment
 	}
 
 	/* assignment */
 
-	var vars []Expr = []Expr{expr}
-
-	for lexer.LookAhead.Kind == ',' {
-		lexer.Next() // skip ','
-		expr := parseSuffixedExpr(lexer)
+	var vars []Expr
+	for {
 		switch expr.(type) { // check variable
 		case *NameExpr, *IndexExpr:
 			break
 		default:
-			lexer.error("syntax error")
+			lexer.syntaxError("syntax error")
 		}
 		vars = append(vars, expr)
+		if !testNext(lexer, ',') {
+			break
+		}
+		expr = parseSuffixedExpr(lexer)
 	}
 
 	checkNext(lexer, '=')
 	exprList := parseExprList(lexer)
 
 	return &AssignStmt{
-		LastLine: lexer.line,
+		LastLine: lexer.LastLine(),
 		Vars:     vars,
 		ExprList: exprList,
 	}
@@ -309,15 +324,15 @@ func parseFunctionExpr(lexer *Lexer, isMethod bool, line int) *FunctionExpr {
 				lexer.Next()
 				isVararg = true
 			} else {
-				lexer.error("<name> or '...' expected")
+				lexer.syntaxError("<name> or '...' expected")
 			}
 		}
 	}
 
 	checkNext(lexer, ')')
 	block := parseBlock(lexer)
-	lastLine := lexer.line
-	checkNext(lexer, TOKEN_END)
+	lastLine := lexer.LookAhead.Line
+	checkMatch(lexer, TOKEN_END, TOKEN_FUNCTION, line)
 
 	return &FunctionExpr{
 		Line:      line,
@@ -342,56 +357,61 @@ func parseSuffixedExpr(lexer *Lexer) Expr {
 	var expr Expr
 	switch lexer.LookAhead.Kind {
 	case TOKEN_NAME:
-		expr = &NameExpr{lexer.line, checkName(lexer)}
+		expr = parseNameExpr(lexer)
 	case '(':
+		line := lexer.LookAhead.Line
 		lexer.Next() // skip '('
 		expr = parseExpr(lexer)
 		switch expr.(type) {
 		case *VarargExpr, *FuncCallExpr, *NameExpr, *IndexExpr:
-			expr = &ParenExpr{expr}
+			expr = &ParenExpr{line, expr}
 		}
-		checkNext(lexer, ')')
+		checkMatch(lexer, ')', '(', line)
 	default:
-		lexer.error("unexpected symbol")
+		lexer.syntaxError("unexpected symbol")
 	}
 
 	for {
 		var name *StringExpr
 		switch lexer.LookAhead.Kind {
 		case '.':
-			lexer.Next() // skip '.'
+			line := lexer.LookAhead.Line // before parseFieldName moves on
 			expr = &IndexExpr{
+				Line:    line,
 				Expr:    expr,
-				KeyExpr: &StringExpr{lexer.line, checkName(lexer)},
+				KeyExpr: parseFieldName(lexer),
 			}
 		case '[':
+			line := lexer.LookAhead.Line
 			lexer.Next() // skip '['
 			expr = &IndexExpr{
+				Line:    line,
 				Expr:    expr,
 				KeyExpr: parseExpr(lexer),
 			}
 			checkNext(lexer, ']')
 		case ':':
-			lexer.Next() // skip ':'
-			name = &StringExpr{lexer.line, checkName(lexer)}
+			name = parseFieldName(lexer)
 			fallthrough
 		case '(', TOKEN_STRING, '{': // funcargs
-			line := lexer.line
+			line := lexer.LookAhead.Line
 			var args []Expr
 			if testNext(lexer, '(') {
 				if lexer.LookAhead.Kind != ')' { // arg list is empty?
 					args = parseExprList(lexer)
 				}
-				checkNext(lexer, ')')
-			} else if testNext(lexer, '{') {
+				checkMatch(lexer, ')', '(', line)
+			} else if lexer.LookAhead.Kind == '{' {
 				args = append(args, parseTableExpr(lexer))
-			} else {
+			} else if lexer.LookAhead.Kind == TOKEN_STRING {
 				args = append(args, &StringExpr{line, lexer.LookAhead.Value})
 				lexer.Next()
+			} else { // after ':' NAME
+				lexer.syntaxError("function arguments expected")
 			}
 			expr = &FuncCallExpr{
 				Line:     line,
-				LastLine: lexer.line, // TODO
+				LastLine: lexer.LastLine(),
 				Expr:     expr,
 				Name:     name,
 				Args:     args,
@@ -407,14 +427,13 @@ func parseExpr(lexer *Lexer) Expr {
 }
 
 func parseExpr0(lexer *Lexer) Expr {
-	line := 0
+	line := lexer.LookAhead.Line
 	switch tokenKind := lexer.LookAhead.Kind; tokenKind {
 	case TOKEN_NOT, '#', '-', '~':
 		lexer.Next()
 		return &UnopExpr{line, tokenKind, parseExpr1(lexer, unaryPriority)}
-	case TOKEN_NUMBER: // TODO: FLT, INT
-		lexer.Next()
-		return &IntegerExpr{0, 0}
+	case TOKEN_NUMBER:
+		return parseNumberExpr(lexer)
 	case TOKEN_STRING:
 		value := lexer.LookAhead.Value
 		lexer.Next()
@@ -434,12 +453,26 @@ func parseExpr0(lexer *Lexer) Expr {
 	case '{': // constructor
 		return parseTableExpr(lexer)
 	case TOKEN_FUNCTION:
+		lexer.Next() // skip FUNCTION
 		return parseFunctionExpr(lexer, false, line)
 	default:
 		return parseSuffixedExpr(lexer)
 	}
 }
 
+func parseNumberExpr(lexer *Lexer) Expr {
+	line, token := lexer.LookAhead.Line, lexer.LookAhead.Value
+	lexer.Next() // skip numeral
+	if i, ok := number.ParseInteger(token); ok {
+		return &IntegerExpr{line, i}
+	}
+	if f, ok := number.ParseFloat(token); ok {
+		return &FloatExpr{line, f}
+	}
+	lexer.error("malformed number near '%s'", token)
+	return nil
+}
+
 func parseExpr1(lexer *Lexer, prec int) Expr {
 	expr := parseExpr0(lexer)
 
@@ -449,7 +482,7 @@ func parseExpr1(lexer *Lexer, prec int) Expr {
 		if leftPrec <= prec {
 			break
 		}
-		line := 0    // TODO
+		line := lexer.LookAhead.Line
 		lexer.Next() // skip binop
 
 		expr = &BinopExpr{
@@ -465,7 +498,7 @@ func parseExpr1(lexer *Lexer, prec int) Expr {
 
 // '{' [ field { ( ',' | ';' ) field } [ ',' | ';' ] ] ';'
 func parseTableExpr(lexer *Lexer) *TableExpr {
-	line := lexer.line
+	line := lexer.LookAhead.Line
 	lexer.Next() // skip '{'
 
 	var keyList []Expr
@@ -482,7 +515,7 @@ func parseTableExpr(lexer *Lexer) *TableExpr {
 			valList = append(valList, parseExpr(lexer))
 		} else {
 			expr := parseExpr(lexer)
-			if nameExpr, ok := expr.(*NameExpr); ok {
+			if nameExpr, ok := expr.(*NameExpr); ok && lexer.LookAhead.Kind == '=' {
 				keyList = append(keyList, &StringExpr{nameExpr.Line, nameExpr.Name})
 				checkNext(lexer, '=')
 				valList = append(valList, parseExpr(lexer))
@@ -497,8 +530,8 @@ func parseTableExpr(lexer *Lexer) *TableExpr {
 		}
 	}
 
-	lastLine := lexer.line
-	checkNext(lexer, '}')
+	lastLine := lexer.LookAhead.Line
+	checkMatch(lexer, '}', '{', line)
 
 	return &TableExpr{
 		Line:     line,
@@ -508,6 +541,18 @@ func parseTableExpr(lexer *Lexer) *TableExpr {
 	}
 }
 
+func parseNameExpr(lexer *Lexer) *NameExpr {
+	line := lexer.LookAhead.Line
+	return &NameExpr{line, checkName(lexer)}
+}
+
+// ('.' | ':') NAME
+func parseFieldName(lexer *Lexer) *StringExpr {
+	lexer.Next() // skip '.' or ':'
+	line := lexer.LookAhead.Line
+	return &StringExpr{line, checkName(lexer)}
+}
+
 func testNext(lexer *Lexer, kind int) bool {
 	if lexer.LookAhead.Kind == kind {
 		lexer.Next()
@@ -518,8 +563,7 @@ func testNext(lexer *Lexer, kind int) bool {
 
 func check(lexer *Lexer, kind int) {
 	if lexer.LookAhead.Kind != kind {
-		// TODO <kind> expected near ''
-		lexer.error("syntax error")
+		lexer.syntaxError("%s expected", tokenToString(kind))
 	}
 }
 
@@ -528,6 +572,19 @@ func checkNext(lexer *Lexer, kind int) {
 	lexer.Next()
 }
 
+/**
+ * Checks that the token closing the construct `who` opened at line `where`
+ * comes next and skips it. The error mentions the opening line when it is
+ * not the current one, as in "'end' expected (to close 'if' at line 1)".
+ */
+func checkMatch(lexer *Lexer, what, who, where int) {
+	if lexer.LookAhead.Kind != what && lexer.LookAhead.Line != where {
+		lexer.syntaxError("%s expected (to close %s at line %d)",
+			tokenToString(what), tokenToString(who), where)
+	}
+	checkNext(lexer, what)
+}
+
 func checkName(lexer *Lexer) string {
 	check(lexer, TOKEN_NAME)
 	name := lexer.LookAhead.Value
@@ -0,0 +1,53 @@
+package number
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Formats a float like Lua's "%.14g", adding ".0" to floats that would look
+ * like integers so that 1.0 prints as "1.0" rather than "1".
+ */
+func FloatToString(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	s := strconv.FormatFloat(f, 'g', 14, 64)
+	if strings.Trim(s, "-0123456789") == "" { // looks like an int?
+		s += ".0"
+	}
+	return s
+}
+
+/**
+ * Encodes a code point up to 0x7FFFFFFF the way Lua does, using the original
+ * UTF-8 scheme of up to six bytes, so surrogates and values beyond 0x10FFFF
+ * are kept instead of being replaced by U+FFFD.
+ */
+func UTF8Esc(x uint32) string {
+	if x < 0x80 { // ascii?
+		return string([]byte{byte(x)})
+	}
+	var buf [6]byte
+	n := len(buf)
+	mfb := uint32(0x3f) // maximum that fits in first byte
+	for {               // add continuation bytes
+		n--
+		buf[n] = byte(0x80 | (x & 0x3f))
+		x >>= 6   // remove added bits
+		mfb >>= 1 // now there is one less bit available in first byte
+		if x <= mfb {
+			break
+		}
+	}
+	n--
+	buf[n] = byte(^mfb<<1 | x) // add first byte
+	return string(buf[n:])
+}
@@ -19,26 +19,39 @@ func IMod(a, b int64) int64 {
 }
 
 func FMod(a, b float64) float64 {
-	return a - FFloorDiv(a, b) * b
+	m := math.Mod(a, b)
+	if m > 0 && b < 0 || m < 0 && b > 0 { // math.Mod takes the sign of a
+		m += b
+	}
+	return m
 }
 
+// shifts by 64 bits or more give 0, and the range is checked before
+// negating n, since -n overflows for math.MinInt64
 func ShiftLeft(a, n int64) int64 {
-	if n >= 0 {
+	if n <= -64 || n >= 64 {
+		return 0
+	} else if n >= 0 {
 		return a << uint64(n)
 	} else {
-		return ShiftRight(a, -n)
+		return int64(uint64(a) >> uint64(-n))
 	}
 }
 
 func ShiftRight(a, n int64) int64 {
-	if n >= 0 {
+	if n <= -64 || n >= 64 {
+		return 0
+	} else if n >= 0 {
 		return int64(uint64(a) >> uint64(n))
 	} else {
-		return ShiftLeft(a, -n)
+		return a << uint64(-n)
 	}
 }
 
 func FloatToInteger(f float64) (int64, bool) {
-	i := int64(f)
-	return i, float64(i) == f
+	if f >= -(1<<63) && f < (1<<63) {
+		i := int64(f)
+		return i, float64(i) == f
+	}
+	return 0, false
 }
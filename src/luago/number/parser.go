@@ -1,13 +1,211 @@
 package number
 
-import "strconv"
+import (
+	"math"
+	"strconv"
+	"strings"
+)
 
+/**
+ * Converts a string to an integer following the Lua 5.3 numeral grammar.
+ * Decimal numerals that overflow are rejected (so that they can be read as
+ * floats instead), while hexadecimal numerals wrap around modulo 2^64.
+ */
 func ParseInteger(s string) (int64, bool) {
-	i, err := strconv.ParseInt(s, 10, 64)
-	return i, err == nil
+	s = strings.TrimSpace(s)
+	neg := false
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+
+	if isHexPrefix(s) {
+		s = s[2:]
+		if s == "" {
+			return 0, false
+		}
+		var a uint64
+		for i := 0; i < len(s); i++ {
+			d, ok := hexDigit(s[i])
+			if !ok {
+				return 0, false
+			}
+			a = a*16 + uint64(d)
+		}
+		if neg {
+			a = -a
+		}
+		return int64(a), true
+	}
+
+	if s == "" {
+		return 0, false
+	}
+	const maxBy10 = math.MaxInt64 / 10
+	const maxLastD = math.MaxInt64 % 10
+	var a uint64
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return 0, false
+		}
+		d := uint64(s[i] - '0')
+		lastD := uint64(maxLastD)
+		if neg {
+			lastD++
+		}
+		if a >= maxBy10 && (a > maxBy10 || d > lastD) { // overflow
+			return 0, false
+		}
+		a = a*10 + d
+	}
+	if neg {
+		a = -a
+	}
+	return int64(a), true
 }
 
+/**
+ * Converts a string to a float following the Lua 5.3 numeral grammar,
+ * including hexadecimal floats with optional binary exponents ('p').
+ * "inf" and "nan" are not numerals and are rejected.
+ */
 func ParseFloat(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if strings.ContainsAny(s, "nN") { // reject 'inf' and 'nan'
+		return 0, false
+	}
+
+	neg := false
+	body := s
+	if len(body) > 0 && (body[0] == '-' || body[0] == '+') {
+		neg = body[0] == '-'
+		body = body[1:]
+	}
+
+	if isHexPrefix(body) {
+		f, ok := parseHexFloat(body[2:])
+		if neg {
+			f = -f
+		}
+		return f, ok
+	}
+
+	if !isDecimalFloat(body) {
+		return 0, false
+	}
 	f, err := strconv.ParseFloat(s, 64)
-	return f, err == nil
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); !ok || ne.Err != strconv.ErrRange {
+			return 0, false
+		}
+	}
+	return f, true
+}
+
+// digits ['.' digits] [('e'|'E') ['+'|'-'] digits], at least one mantissa digit
+func isDecimalFloat(s string) bool {
+	i, nDigits := 0, 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+		nDigits++
+	}
+	if i < len(s) && s[i] == '.' {
+		i++
+		for i < len(s) && isDigit(s[i]) {
+			i++
+			nDigits++
+		}
+	}
+	if nDigits == 0 {
+		return false
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		if i == len(s) || !isDigit(s[i]) {
+			return false
+		}
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+	}
+	return i == len(s)
+}
+
+// hexdigits ['.' hexdigits] [('p'|'P') ['+'|'-'] digits], without the '0x'
+func parseHexFloat(s string) (float64, bool) {
+	mantissa := 0.0
+	exp := 0 // binary exponent
+	nDigits := 0
+	hasDot := false
+
+	i := 0
+	for ; i < len(s); i++ {
+		if s[i] == '.' {
+			if hasDot {
+				return 0, false
+			}
+			hasDot = true
+		} else if d, ok := hexDigit(s[i]); ok {
+			mantissa = mantissa*16 + float64(d)
+			nDigits++
+			if hasDot {
+				exp -= 4 // each fractional digit divides by 16
+			}
+		} else {
+			break
+		}
+	}
+	if nDigits == 0 {
+		return 0, false
+	}
+
+	if i < len(s) && (s[i] == 'p' || s[i] == 'P') {
+		i++
+		expNeg := false
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			expNeg = s[i] == '-'
+			i++
+		}
+		if i == len(s) || !isDigit(s[i]) {
+			return 0, false
+		}
+		e := 0
+		for ; i < len(s) && isDigit(s[i]); i++ {
+			if e < 1<<20 { // avoid overflow, the result saturates anyway
+				e = e*10 + int(s[i]-'0')
+			}
+		}
+		if expNeg {
+			e = -e
+		}
+		exp += e
+	}
+	if i != len(s) {
+		return 0, false
+	}
+
+	return math.Ldexp(mantissa, exp), true
+}
+
+func isHexPrefix(s string) bool {
+	return len(s) >= 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X')
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func hexDigit(c byte) (int, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0'), true
+	case 'a' <= c && c <= 'f':
+		return int(c-'a') + 10, true
+	case 'A' <= c && c <= 'F':
+		return int(c-'A') + 10, true
+	}
+	return 0, false
 }
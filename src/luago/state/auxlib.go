@@ -0,0 +1,470 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"luago/api"
+	"luago/number"
+	"luago/vfs"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const freelist = 0 // index of the free list of references
+
+const fileSystemKey = "_FS" // registry key of the file system, see SetFileSystem
+
+func (state *luaState) Error2(format string, a ...interface{}) int {
+	state.Where(1)
+	state.PushFString(format, a...)
+	state.Concat(2)
+	return state.Error()
+}
+
+func (state *luaState) ArgError(arg int, extraMsg string) int {
+	if state.stack.closure == nil { // no stack frame?
+		return state.Error2("bad argument #%d (%s)", arg, extraMsg)
+	}
+	kind, name := calledFuncName(state.stack)
+	if kind == "method" {
+		arg--         // do not count 'self'
+		if arg == 0 { // error is in the self argument itself?
+			return state.Error2("calling '%s' on bad self (%s)", name, extraMsg)
+		}
+	}
+	if kind == "" {
+		if name = state.globalFuncName(state.stack.closure); name == "" {
+			name = "?"
+		}
+	}
+	return state.Error2("bad argument #%d to '%s' (%s)", arg, name, extraMsg)
+}
+
+func (state *luaState) TypeError(arg int, tname string) int {
+	var typeArg string // name for the type of the actual argument
+	if state.GetMetafield(arg, "__name") == api.LUA_TSTRING {
+		typeArg = state.ToString(-1) // use the given type name
+	} else if state.Type(arg) == api.LUA_TLIGHTUSERDATA {
+		typeArg = "light userdata" // special name for messages
+	} else {
+		typeArg = state.TypeName2(arg) // standard name
+	}
+	return state.ArgError(arg, fmt.Sprintf("%s expected, got %s", tname, typeArg))
+}
+
+func (state *luaState) Where(level int) {
+	if stack := state.frameAt(level); stack != nil {
+		if proto := stack.proto(); proto != nil {
+			if line := stack.currentLine(); line > 0 {
+				state.PushString(where(proto, line))
+				return
+			}
+		}
+	}
+	state.PushString("") // else, no information available...
+}
+
+func (state *luaState) CheckStack2(sz int, msg string) {
+	if !state.CheckStack(sz) {
+		if msg != "" {
+			state.Error2("stack overflow (%s)", msg)
+		} else {
+			state.Error2("stack overflow")
+		}
+	}
+}
+
+func (state *luaState) ArgCheck(cond bool, arg int, extraMsg string) {
+	if !cond {
+		state.ArgError(arg, extraMsg)
+	}
+}
+
+func (state *luaState) CheckAny(arg int) {
+	if state.Type(arg) == api.LUA_TNONE {
+		state.ArgError(arg, "value expected")
+	}
+}
+
+func (state *luaState) CheckType(arg int, t api.LuaType) {
+	if state.Type(arg) != t {
+		state.tagError(arg, t)
+	}
+}
+
+func (state *luaState) CheckInteger(arg int) int64 {
+	i, ok := state.ToIntegerX(arg)
+	if !ok {
+		state.intError(arg)
+	}
+	return i
+}
+
+func (state *luaState) CheckNumber(arg int) float64 {
+	f, ok := state.ToNumberX(arg)
+	if !ok {
+		state.tagError(arg, api.LUA_TNUMBER)
+	}
+	return f
+}
+
+func (state *luaState) CheckString(arg int) string {
+	s, ok := state.ToStringX(arg)
+	if !ok {
+		state.tagError(arg, api.LUA_TSTRING)
+	}
+	return s
+}
+
+func (state *luaState) CheckOption(arg int, def string, lst []string) int {
+	name := def
+	if def == "" || !state.IsNoneOrNil(arg) {
+		name = state.CheckString(arg)
+	}
+	for i, opt := range lst {
+		if opt == name {
+			return i
+		}
+	}
+	return state.ArgError(arg, fmt.Sprintf("invalid option '%s'", name))
+}
+
+func (state *luaState) OptInteger(arg int, def int64) int64 {
+	if state.IsNoneOrNil(arg) {
+		return def
+	}
+	return state.CheckInteger(arg)
+}
+
+func (state *luaState) OptNumber(arg int, def float64) float64 {
+	if state.IsNoneOrNil(arg) {
+		return def
+	}
+	return state.CheckNumber(arg)
+}
+
+func (state *luaState) OptString(arg int, def string) string {
+	if state.IsNoneOrNil(arg) {
+		return def
+	}
+	return state.CheckString(arg)
+}
+
+func (state *luaState) NewMetatable(tname string) bool {
+	if state.GetMetatable2(tname) != api.LUA_TNIL { // name already in use?
+		return false // leave previous value on top, but return false
+	}
+	state.Pop(1)
+	state.CreateTable(0, 2) // create metatable
+	state.PushString(tname)
+	state.SetField(-2, "__name") // metatable.__name = tname
+	state.PushValue(-1)
+	state.SetField(api.LUA_REGISTRYINDEX, tname) // registry.name = metatable
+	return true
+}
+
+func (state *luaState) GetMetatable2(tname string) api.LuaType {
+	return state.GetField(api.LUA_REGISTRYINDEX, tname)
+}
+
+func (state *luaState) SetMetatable2(tname string) {
+	state.GetMetatable2(tname)
+	state.SetMetatable(-2)
+}
+
+// ok is false if the value is not a userdata with the right metatable
+func (state *luaState) TestUdata(arg int, tname string) (data interface{}, ok bool) {
+	if u := state.stack.get(arg).userdata(); u != nil {
+		if state.GetMetatable(arg) { // does it have a metatable?
+			state.GetMetatable2(tname) // get correct metatable
+			same := state.RawEqual(-1, -2)
+			state.Pop(2) // remove both metatables
+			if same {
+				return u.data, true // the data may be nil
+			}
+		}
+	}
+	return nil, false
+}
+
+func (state *luaState) CheckUdata(arg int, tname string) interface{} {
+	data, ok := state.TestUdata(arg, tname)
+	if !ok {
+		state.TypeError(arg, tname)
+	}
+	return data
+}
+
+func (state *luaState) DoFile(filename string) bool {
+	return state.LoadFile(filename) == api.LUA_OK &&
+		state.PCall(0, api.LUA_MULTRET, 0) == api.LUA_OK
+}
+
+func (state *luaState) DoString(str string) bool {
+	return state.LoadString(str) == api.LUA_OK &&
+		state.PCall(0, api.LUA_MULTRET, 0) == api.LUA_OK
+}
+
+func (state *luaState) LoadFile(filename string) int {
+	return state.LoadFileX(filename, "bt")
+}
+
+func (state *luaState) LoadFileX(filename, mode string) int {
+	var data []byte
+	var err error
+	chunkName := "@" + filename
+	if filename == "" {
+		data, err = io.ReadAll(os.Stdin)
+		chunkName = "=stdin"
+	} else {
+		data, err = readFile(state.FileSystem(), filename)
+	}
+	if err != nil {
+		msg, _ := errorInfo(err)
+		state.PushString(fmt.Sprintf("cannot open %s: %s", chunkName[1:], msg))
+		return api.LUA_ERRFILE
+	}
+	return state.Load(skipComment(data), chunkName, mode)
+}
+
+func (state *luaState) LoadString(s string) int {
+	return state.Load([]byte(s), s, "bt")
+}
+
+// returns the file system set by SetFileSystem, the host's one by default
+func (state *luaState) FileSystem() api.FileSystem {
+	if u := state.registry.get(stringValue(fileSystemKey)).userdata(); u != nil {
+		return u.data.(api.FileSystem)
+	}
+	return vfs.OS
+}
+
+// sets the file system of the state and of all its threads
+func (state *luaState) SetFileSystem(fsys api.FileSystem) {
+	state.registry.put(stringValue(fileSystemKey), userdataValue(&userdata{data: fsys}))
+}
+
+/**
+ * Pushes the results of a library function operating on files: true when
+ * err is nil, otherwise nil, an error message prefixed with fname when it is
+ * not empty, and the error code.
+ */
+func (state *luaState) FileResult(err error, fname string) int {
+	if err == nil {
+		state.PushBoolean(true)
+		return 1
+	}
+	msg, errno := errorInfo(err)
+	state.PushNil()
+	if fname != "" {
+		state.PushString(fname + ": " + msg)
+	} else {
+		state.PushString(msg)
+	}
+	state.PushInteger(int64(errno))
+	return 3
+}
+
+func (state *luaState) Ref(t int) int {
+	if state.IsNil(-1) {
+		state.Pop(1)          // remove it from stack
+		return api.LUA_REFNIL // 'nil' has a unique fixed reference
+	}
+	t = state.AbsIndex(t)
+	state.RawGetI(t, freelist)      // get first free element
+	ref := int(state.ToInteger(-1)) // ref = t[freelist]
+	state.Pop(1)                    // remove it from stack
+	if ref != 0 {                   // any free element?
+		state.RawGetI(t, int64(ref)) // remove it from list
+		state.RawSetI(t, freelist)   // (t[freelist] = t[ref])
+	} else { // no free elements
+		ref = int(state.RawLen(t)) + 1 // get a new reference
+	}
+	state.RawSetI(t, int64(ref))
+	return ref
+}
+
+func (state *luaState) Unref(t, ref int) {
+	if ref >= 0 {
+		t = state.AbsIndex(t)
+		state.RawGetI(t, freelist)
+		state.RawSetI(t, int64(ref)) // t[ref] = t[freelist]
+		state.PushInteger(int64(ref))
+		state.RawSetI(t, freelist) // t[freelist] = ref
+	}
+}
+
+func (state *luaState) TypeName2(idx int) string {
+	return state.TypeName(state.Type(idx))
+}
+
+func (state *luaState) ToStringMeta(idx int) string {
+	idx = state.AbsIndex(idx)
+	if state.CallMeta(idx, "__tostring") { // metafield?
+		if !state.IsString(-1) {
+			state.Error2("'__tostring' must return a string")
+		}
+	} else {
+		switch state.Type(idx) {
+		case api.LUA_TNUMBER:
+			if state.IsInteger(idx) {
+				state.PushString(strconv.FormatInt(state.ToInteger(idx), 10))
+			} else {
+				state.PushString(number.FloatToString(state.ToNumber(idx)))
+			}
+		case api.LUA_TSTRING:
+			state.PushValue(idx)
+		case api.LUA_TBOOLEAN:
+			state.PushString(strconv.FormatBool(state.ToBoolean(idx)))
+		case api.LUA_TNIL:
+			state.PushString("nil")
+		default:
+			kind := state.TypeName2(idx)
+			if tt := state.GetMetafield(idx, "__name"); tt != api.LUA_TNIL { // try name
+				if tt == api.LUA_TSTRING {
+					kind = state.ToString(-1)
+				}
+				state.Pop(1) // remove '__name'
+			}
+			state.PushString(fmt.Sprintf("%s: %s", kind, toPointer(state.stack.get(idx))))
+		}
+	}
+	return state.ToString(-1)
+}
+
+func (state *luaState) Len2(idx int) int64 {
+	state.Len(idx)
+	i, isNum := state.ToIntegerX(-1)
+	if !isNum {
+		state.Error2("object length is not an integer")
+	}
+	state.Pop(1)
+	return i
+}
+
+func (state *luaState) GetSubTable(idx int, fname string) bool {
+	if state.GetField(idx, fname) == api.LUA_TTABLE {
+		return true // table already there
+	}
+	state.Pop(1) // remove previous result
+	idx = state.stack.absIndex(idx)
+	state.NewTable()
+	state.PushValue(-1)        // copy to be left at top
+	state.SetField(idx, fname) // assign new table to field
+	return false               // false, because did not find table there
+}
+
+func (state *luaState) GetMetafield(obj int, event string) api.LuaType {
+	if !state.GetMetatable(obj) { // no metatable?
+		return api.LUA_TNIL
+	}
+	state.PushString(event)
+	tt := state.RawGet(-2)
+	if tt == api.LUA_TNIL { // is metafield nil?
+		state.Pop(2) // remove metatable and metafield
+	} else {
+		state.Remove(-2) // remove only metatable
+	}
+	return tt // return metafield type
+}
+
+func (state *luaState) CallMeta(obj int, event string) bool {
+	obj = state.AbsIndex(obj)
+	if state.GetMetafield(obj, event) == api.LUA_TNIL { // no metafield?
+		return false
+	}
+	state.PushValue(obj)
+	state.Call(1, 1)
+	return true
+}
+
+func (state *luaState) Traceback(l1 api.LuaState, msg string, level int) {
+	tb := toLuaState(l1).traceback(level)
+	if msg != "" {
+		tb = msg + "\n" + tb
+	}
+	state.PushString(tb)
+}
+
+func (state *luaState) NewLib(l api.FuncReg) {
+	state.NewLibTable(l)
+	state.SetFuncs(l, 0)
+}
+
+func (state *luaState) NewLibTable(l api.FuncReg) {
+	state.CreateTable(0, len(l))
+}
+
+func (state *luaState) SetFuncs(l api.FuncReg, nup int) {
+	state.CheckStack2(nup, "too many upvalues")
+	for name, fun := range l { // fill the table with given functions
+		for i := 0; i < nup; i++ { // copy upvalues to the top
+			state.PushValue(-nup)
+		}
+		// r[-(nup+2)][name]=fun
+		state.PushGoClosure(fun, nup) // closure with those upvalues
+		state.SetField(-(nup + 2), name)
+	}
+	state.Pop(nup) // remove upvalues
+}
+
+func (state *luaState) intError(arg int) {
+	if state.IsNumber(arg) {
+		state.ArgError(arg, "number has no integer representation")
+	} else {
+		state.tagError(arg, api.LUA_TNUMBER)
+	}
+}
+
+func (state *luaState) tagError(arg int, tag api.LuaType) {
+	state.TypeError(arg, state.TypeName(tag))
+}
+
+// skips an optional first line starting with '#', as in "#!/usr/bin/lua",
+// keeping its newline so that line numbers stay right
+func skipComment(data []byte) []byte {
+	if len(data) > 0 && data[0] == '#' {
+		if i := strings.IndexByte(string(data), '\n'); i >= 0 {
+			return data[i:]
+		}
+		return nil
+	}
+	return data
+}
+
+// formats the address of a reference value, as in "0xc000010000"
+func toPointer(val luaValue) string {
+	if x, ok := val.ref.(lightUserdata); ok {
+		return fmt.Sprintf("%p", x.data)
+	}
+	return fmt.Sprintf("%p", val.ref)
+}
+
+func readFile(fsys api.FileSystem, name string) ([]byte, error) {
+	f, err := fsys.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// returns the message and the error code of an error from a file system
+func errorInfo(err error) (string, int) {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno.Error(), int(errno)
+	}
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err // drop the operation and the path
+	} else if errors.As(err, &linkErr) {
+		err = linkErr.Err
+	}
+	return err.Error(), 0
+}
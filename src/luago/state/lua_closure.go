@@ -9,13 +9,41 @@ type upvalue struct {
 	val *luaValue
 }
 
+/**
+ * A function prototype as the VM runs it: its constants are converted to
+ * luaValues, and those of its nested functions likewise, once when the
+ * chunk is loaded rather than each time they are read.
+ */
+type funcProto struct {
+	*binary.Prototype
+	code   []uint32 // Code, one indirection closer to the VM
+	consts []luaValue
+	protos []*funcProto
+}
+
+func newFuncProto(proto *binary.Prototype) *funcProto {
+	p := &funcProto{
+		Prototype: proto,
+		code:      proto.Code,
+		consts:    make([]luaValue, len(proto.Constants)),
+		protos:    make([]*funcProto, len(proto.Protos)),
+	}
+	for i, k := range proto.Constants {
+		p.consts[i] = valueOf(k)
+	}
+	for i, sub := range proto.Protos {
+		p.protos[i] = newFuncProto(sub)
+	}
+	return p
+}
+
 type luaClosure struct {
-	proto  *binary.Prototype
+	proto  *funcProto // nil for Go functions
 	goFun  api.GoFunction
 	upvals []*upvalue
 }
 
-func newLuaClosure(proto *binary.Prototype) *luaClosure {
+func newLuaClosure(proto *funcProto) *luaClosure {
 	closure := &luaClosure{proto: proto}
 	if nUpvals := len(proto.Upvalues); nUpvals > 0 {
 		closure.upvals = make([]*upvalue, nUpvals)
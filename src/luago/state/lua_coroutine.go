@@ -0,0 +1,154 @@
+package state
+
+import (
+	"luago/api"
+	"runtime"
+)
+
+/**
+ * Coroutines run on their own goroutine. A thread and the one resuming it
+ * take turns through unbuffered channels, so only one of them is running at
+ * any time and a coroutine may yield from any depth, Go functions included.
+ */
+
+/**
+ * A thread as Lua values and Go code see it, the api.LuaState of the
+ * thread. The goroutine of a suspended coroutine refers to the luaState
+ * only, so the handle can become unreachable while the goroutine waits; a
+ * finalizer then ends the goroutine, which could never be resumed. A
+ * coroutine referring to itself from its own stack keeps itself alive.
+ */
+type luaThread struct {
+	*luaState
+}
+
+// the value a suspended coroutine is woken up with when it is unreachable
+type threadReleased struct{}
+
+func toLuaState(ls api.LuaState) *luaState {
+	return ls.(*luaThread).luaState
+}
+
+func (state *luaState) NewThread() api.LuaState {
+	t := &luaState{registry: state.registry}
+	t.self = &luaThread{t}
+	t.SetCallLimits(state.maxCalls, state.maxCCalls)
+	t.stack = newLuaStack(api.LUA_MINSTACK, t)
+	state.stack.push(threadValue(t.self))
+	return t.self
+}
+
+/**
+ * Starts or resumes the thread. To start it, the function and its nArgs
+ * arguments must be on the stack of the thread, to resume it the values
+ * returned by `yield` must be. Returns LUA_YIELD if the thread yields,
+ * LUA_OK if it finishes and an error status if it fails, with the yielded
+ * values, the results or the error object on its stack.
+ */
+func (state *luaState) Resume(from api.LuaState, nArgs int) int {
+	caller := toLuaState(from)
+	if caller.coChan == nil {
+		caller.coChan = make(chan int)
+	}
+
+	switch state.coStatus {
+	case api.LUA_OK:
+		if state.stack.prev != nil { // already running?
+			return state.resumeError("cannot resume non-suspended coroutine", nArgs)
+		}
+		// start the coroutine
+		state.coChan = make(chan int)
+		state.coCaller = caller
+		state.nCCalls = caller.nCCalls // nested resumes count as nested calls
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					if _, ok := r.(threadReleased); !ok {
+						panic(r)
+					}
+				}
+			}()
+			state.coStatus = state.PCall(nArgs, -1, 0)
+			if state.hasFinalizer { // it cannot be suspended anymore
+				runtime.SetFinalizer(state.self, nil)
+				state.hasFinalizer = false
+			}
+			state.coCaller.coChan <- 1
+		}()
+	case api.LUA_YIELD:
+		state.coStatus = api.LUA_OK
+		state.coCaller = caller
+		state.coChan <- 1
+	default:
+		return state.resumeError("cannot resume dead coroutine", nArgs)
+	}
+
+	<-caller.coChan // wait for the coroutine to yield or finish
+	return state.coStatus
+}
+
+// a suspended thread is only resumed through its handle
+func (t *luaThread) Resume(from api.LuaState, nArgs int) int {
+	t.self = t
+	return t.luaState.Resume(from, nArgs)
+}
+
+// replaces the arguments of a failed resume with an error message
+func (state *luaState) resumeError(msg string, nArgs int) int {
+	state.stack.popN(nArgs)
+	state.stack.push(stringValue(msg))
+	return api.LUA_ERRRUN
+}
+
+/**
+ * Suspends the running coroutine, the top nResults values are passed to
+ * `resume`. Returns the number of values the coroutine is resumed with,
+ * which replace the stack of the current function.
+ */
+func (state *luaState) Yield(nResults int) int {
+	if state.coCaller == nil {
+		state.runError("attempt to yield from outside a coroutine")
+	}
+	vals := state.stack.popN(nResults)
+	state.SetTop(0)
+	state.stack.pushN(vals, nResults)
+
+	state.coStatus = api.LUA_YIELD
+	if !state.hasFinalizer {
+		runtime.SetFinalizer(state.self, (*luaThread).release)
+		state.hasFinalizer = true
+	}
+	state.self = nil // Resume sets it back
+	state.coCaller.coChan <- 1
+	if _, ok := <-state.coChan; !ok { // wait to be resumed
+		panic(threadReleased{}) // unwind the goroutine
+	}
+	return state.GetTop()
+}
+
+// ends the goroutine of a suspended coroutine that nothing refers to anymore
+func (t *luaThread) release() {
+	close(t.coChan)
+}
+
+func (state *luaState) Status() int {
+	return state.coStatus
+}
+
+// the main thread cannot yield
+func (state *luaState) IsYieldable() bool {
+	return state.coCaller != nil
+}
+
+// returns true if the thread has a function running
+func (state *luaState) GetStack() bool {
+	return state.stack.prev != nil
+}
+
+// pops n values from the stack and pushes them onto the stack of `to`
+func (state *luaState) XMove(to api.LuaState, n int) {
+	vals := state.stack.popN(n)
+	t := toLuaState(to)
+	t.stack.check(n)
+	t.stack.pushN(vals, n)
+}
@@ -0,0 +1,474 @@
+package state
+
+import (
+	"fmt"
+	"luago/api"
+	"luago/binary"
+	"luago/vm"
+	"strings"
+)
+
+const (
+	LEVELS1 = 10 // size of the first part of a traceback
+	LEVELS2 = 11 // size of the second part of a traceback
+)
+
+/**
+ * Raises a runtime error. Errors raised while a Lua function is running are
+ * prefixed with its chunk id and current line, as in "x.lua:3: message".
+ */
+func (state *luaState) runError(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	err := &api.LuaError{Value: msg, Message: msg}
+	if proto := state.stack.proto(); proto != nil {
+		err.ChunkName = proto.Source
+		err.Line = state.stack.currentLine()
+		err.Value = where(proto, err.Line) + msg
+	}
+	panic(err)
+}
+
+// raises an error about an operation on a value of the wrong type
+func (state *luaState) typeError(val luaValue, op string) {
+	state.runError("attempt to %s a %s value%s",
+		op, state.TypeName(typeOf(val)), state.varInfo(val))
+}
+
+// raises an error about an arithmetic or bitwise operation on a and b
+func (state *luaState) arithError(a, b luaValue, bitwise bool) {
+	_, aIsNum := convertToFloat(a)
+	_, bIsNum := convertToFloat(b)
+	if bitwise && aIsNum && bIsNum {
+		bad := b
+		if _, ok := convertToInteger(a); !ok {
+			bad = a
+		}
+		state.runError("number%s has no integer representation", state.varInfo(bad))
+	}
+
+	bad := b
+	if !aIsNum {
+		bad = a
+	}
+	if bitwise {
+		state.typeError(bad, "perform bitwise operation on")
+	}
+	state.typeError(bad, "perform arithmetic on")
+}
+
+// raises an error about comparing a and b
+func (state *luaState) orderError(a, b luaValue) {
+	t1 := state.TypeName(typeOf(a))
+	t2 := state.TypeName(typeOf(b))
+	if t1 == t2 {
+		state.runError("attempt to compare two %s values", t1)
+	}
+	state.runError("attempt to compare %s with %s", t1, t2)
+}
+
+// raises an error about concatenating a and b
+func (state *luaState) concatError(a, b luaValue) {
+	switch a.tag {
+	case tagString, tagInteger, tagFloat:
+		a = b
+	}
+	state.typeError(a, "concatenate")
+}
+
+/**
+ * Describes where a value involved in the current instruction comes from,
+ * as in " (global 'x')". Candidates are the operands of the instruction that
+ * is being executed, the first one holding val is named.
+ */
+func (state *luaState) varInfo(val luaValue) string {
+	proto := state.stack.proto()
+	if proto == nil || state.stack.pc < 1 {
+		return ""
+	}
+	pc := state.stack.pc - 1
+	inst := vm.Instruction(proto.Code[pc])
+
+	var regs []int
+	switch inst.Opcode() {
+	case vm.OP_GETTABUP:
+		_, b, _ := inst.ABC()
+		if state.isUpvalue(b, val) {
+			return fmt.Sprintf(" (upvalue '%s')", upvalName(proto, b))
+		}
+	case vm.OP_SETTABUP:
+		a, _, _ := inst.ABC()
+		if state.isUpvalue(a, val) {
+			return fmt.Sprintf(" (upvalue '%s')", upvalName(proto, a))
+		}
+	case vm.OP_GETTABLE, vm.OP_SELF, vm.OP_UNM, vm.OP_BNOT, vm.OP_LEN:
+		_, b, _ := inst.ABC()
+		regs = []int{b}
+	case vm.OP_SETTABLE, vm.OP_CALL, vm.OP_TAILCALL:
+		a, _, _ := inst.ABC()
+		regs = []int{a}
+	case vm.OP_ADD, vm.OP_SUB, vm.OP_MUL, vm.OP_MOD, vm.OP_POW, vm.OP_DIV,
+		vm.OP_IDIV, vm.OP_BAND, vm.OP_BOR, vm.OP_BXOR, vm.OP_SHL, vm.OP_SHR:
+		_, b, c := inst.ABC()
+		regs = []int{b, c}
+	case vm.OP_CONCAT:
+		_, b, c := inst.ABC() // concatenated from right to left
+		regs = []int{c - 1, c}
+		for r := c - 2; r >= b; r-- {
+			regs = append(regs, r)
+		}
+	}
+
+	for _, r := range regs {
+		if r <= 0xff && state.stack.isValid(r+1) && rawSame(state.stack.get(r+1), val) {
+			if kind, name := getObjName(proto, pc, r); kind != "" {
+				return fmt.Sprintf(" (%s '%s')", kind, name)
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+func (state *luaState) isUpvalue(idx int, val luaValue) bool {
+	upvals := state.stack.closure.upvals
+	return idx < len(upvals) && rawSame(*upvals[idx].val, val)
+}
+
+// values that are the same Lua value, without numeric conversions
+func rawSame(a, b luaValue) bool {
+	if a.tag == tagFloat && b.tag == tagFloat {
+		return a.float() == b.float()
+	}
+	return a == b
+}
+
+/**
+ * Builds a traceback of the running functions, innermost first, starting
+ * at the given level:
+ *
+ *	stack traceback:
+ *		[C]: in function 'error'
+ *		x.lua:3: in local 'f'
+ *		x.lua:5: in main chunk
+ */
+func (state *luaState) traceback(level int) string {
+	var frames []*luaStack
+	for stack := state.frameAt(level); stack != nil && stack.closure != nil; stack = stack.prev {
+		frames = append(frames, stack)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("stack traceback:")
+	for i, stack := range frames {
+		if len(frames) > LEVELS1+LEVELS2 && i == LEVELS1 {
+			sb.WriteString("\n\t...") // skip the middle of deep tracebacks
+		}
+		if len(frames) > LEVELS1+LEVELS2 && i >= LEVELS1 && i < len(frames)-LEVELS2 {
+			continue
+		}
+
+		if proto := stack.proto(); proto != nil {
+			line := "?"
+			if n := stack.currentLine(); n > 0 {
+				line = fmt.Sprint(n)
+			}
+			fmt.Fprintf(&sb, "\n\t%s:%s: in ", api.ChunkID(sourceOf(proto)), line)
+		} else {
+			sb.WriteString("\n\t[C]: in ")
+		}
+		sb.WriteString(state.funcDescription(stack))
+		if stack.tailcall {
+			sb.WriteString("\n\t(...tail calls...)")
+		}
+	}
+	return sb.String()
+}
+
+// returns the frame of the function running at the given level, 0 being
+// the current running function, or nil if there is no such level
+func (state *luaState) frameAt(level int) *luaStack {
+	for stack := state.stack; stack != nil && stack.closure != nil; stack = stack.prev {
+		if level == 0 {
+			return stack
+		}
+		level--
+	}
+	return nil
+}
+
+// describes the function running in the given frame, as in "local 'f'"
+func (state *luaState) funcDescription(stack *luaStack) string {
+	if name := state.globalFuncName(stack.closure); name != "" {
+		return fmt.Sprintf("function '%s'", name)
+	}
+	if kind, name := calledFuncName(stack); kind != "" {
+		if kind == "global" {
+			return fmt.Sprintf("function '%s'", name)
+		}
+		return fmt.Sprintf("%s '%s'", kind, name)
+	}
+	if proto := stack.proto(); proto != nil {
+		if proto.LineBegin == 0 {
+			return "main chunk"
+		}
+		return fmt.Sprintf("function <%s:%d>", api.ChunkID(sourceOf(proto)), proto.LineBegin)
+	}
+	return "?"
+}
+
+/**
+ * Finds a name for the function c in the global table, looking one level
+ * deep into tables, as in "print" or "string.format".
+ */
+func (state *luaState) globalFuncName(c *luaClosure) string {
+	g := state.registry.get(intValue(api.LUA_RIDX_GLOBALS)).table()
+	if g == nil {
+		return ""
+	}
+	name := findField(g, closureValue(c), 2)
+	return strings.TrimPrefix(name, "_G.") // name of a global function
+}
+
+func findField(t *luaTable, val luaValue, level int) string {
+	for _, n := range t.nodes {
+		k, v := n.key, n.val
+		name, ok := k.str()
+		if !ok {
+			continue
+		}
+		if v == val {
+			return name
+		}
+		if sub := v.table(); sub != nil && level > 1 {
+			if field := findField(sub, val, level-1); field != "" {
+				return name + "." + field
+			}
+		}
+	}
+	return ""
+}
+
+// names the function running in the given frame from the call that made it
+func calledFuncName(stack *luaStack) (kind, name string) {
+	if stack.tailcall { // the instruction that called it is gone
+		return "", ""
+	}
+	return funcNameFromCall(stack.prev)
+}
+
+/**
+ * Names the function being called by the frame `caller`, using the
+ * instruction that made the call. Calls made by Go functions are not named.
+ */
+func funcNameFromCall(caller *luaStack) (kind, name string) {
+	if caller == nil {
+		return "", ""
+	}
+	proto := caller.proto()
+	if proto == nil || caller.pc < 1 {
+		return "", ""
+	}
+	pc := caller.pc - 1
+	inst := vm.Instruction(proto.Code[pc])
+	switch inst.Opcode() {
+	case vm.OP_CALL, vm.OP_TAILCALL:
+		a, _, _ := inst.ABC()
+		return getObjName(proto, pc, a)
+	case vm.OP_TFORCALL:
+		return "for iterator", "for iterator"
+	case vm.OP_SELF, vm.OP_GETTABUP, vm.OP_GETTABLE:
+		return "metamethod", "index"
+	case vm.OP_SETTABUP, vm.OP_SETTABLE:
+		return "metamethod", "newindex"
+	case vm.OP_ADD:
+		return "metamethod", "add"
+	case vm.OP_SUB:
+		return "metamethod", "sub"
+	case vm.OP_MUL:
+		return "metamethod", "mul"
+	case vm.OP_MOD:
+		return "metamethod", "mod"
+	case vm.OP_POW:
+		return "metamethod", "pow"
+	case vm.OP_DIV:
+		return "metamethod", "div"
+	case vm.OP_IDIV:
+		return "metamethod", "idiv"
+	case vm.OP_BAND:
+		return "metamethod", "band"
+	case vm.OP_BOR:
+		return "metamethod", "bor"
+	case vm.OP_BXOR:
+		return "metamethod", "bxor"
+	case vm.OP_SHL:
+		return "metamethod", "shl"
+	case vm.OP_SHR:
+		return "metamethod", "shr"
+	case vm.OP_UNM:
+		return "metamethod", "unm"
+	case vm.OP_BNOT:
+		return "metamethod", "bnot"
+	case vm.OP_LEN:
+		return "metamethod", "len"
+	case vm.OP_CONCAT:
+		return "metamethod", "concat"
+	case vm.OP_EQ:
+		return "metamethod", "eq"
+	case vm.OP_LT:
+		return "metamethod", "lt"
+	case vm.OP_LE:
+		return "metamethod", "le"
+	}
+	return "", ""
+}
+
+/**
+ * Names the value of register reg just before the instruction at lastPC by
+ * symbolic execution: a local variable, a global, a field, an upvalue, a
+ * string constant or a method.
+ */
+func getObjName(proto *binary.Prototype, lastPC, reg int) (kind, name string) {
+	if name := localName(proto, reg+1, lastPC); name != "" {
+		return "local", name
+	}
+
+	pc := findSetReg(proto, lastPC, reg)
+	if pc < 0 {
+		return "", ""
+	}
+	inst := vm.Instruction(proto.Code[pc])
+	switch inst.Opcode() {
+	case vm.OP_MOVE:
+		a, b, _ := inst.ABC()
+		if b < a {
+			return getObjName(proto, pc, b)
+		}
+	case vm.OP_GETTABUP:
+		_, b, c := inst.ABC()
+		return gxf(upvalName(proto, b)), kName(proto, pc, c)
+	case vm.OP_GETTABLE:
+		_, b, c := inst.ABC()
+		_, tName := getObjName(proto, pc, b)
+		return gxf(tName), kName(proto, pc, c)
+	case vm.OP_GETUPVAL:
+		_, b, _ := inst.ABC()
+		return "upvalue", upvalName(proto, b)
+	case vm.OP_LOADK, vm.OP_LOADKX:
+		_, bx := inst.ABx()
+		if inst.Opcode() == vm.OP_LOADKX {
+			bx = vm.Instruction(proto.Code[pc+1]).Ax()
+		}
+		if s, ok := proto.Constants[bx].(string); ok {
+			return "constant", s
+		}
+	case vm.OP_SELF:
+		_, _, c := inst.ABC()
+		return "method", kName(proto, pc, c)
+	}
+	return "", ""
+}
+
+// indexing `_ENV` reads a global
+func gxf(tableName string) string {
+	if tableName == "_ENV" {
+		return "global"
+	}
+	return "field"
+}
+
+// names the key RK(c) of an indexing operation
+func kName(proto *binary.Prototype, pc, c int) string {
+	if c > 0xff { // constant
+		if s, ok := proto.Constants[c&0xff].(string); ok {
+			return s
+		}
+	} else if kind, name := getObjName(proto, pc, c); kind == "constant" {
+		return name
+	}
+	return "?"
+}
+
+/**
+ * Finds the last instruction before lastPC that sets register reg, or -1.
+ * Instructions in the range of a forward jump are ignored, since they may
+ * not have been executed.
+ */
+func findSetReg(proto *binary.Prototype, lastPC, reg int) int {
+	setReg := -1
+	jmpTarget := 0
+	filterPC := func(pc int) int {
+		if pc < jmpTarget { // conditional code
+			return -1
+		}
+		return pc
+	}
+
+	for pc := 0; pc < lastPC; pc++ {
+		inst := vm.Instruction(proto.Code[pc])
+		a, b, _ := inst.ABC()
+		switch inst.Opcode() {
+		case vm.OP_LOADNIL:
+			if a <= reg && reg <= a+b {
+				setReg = filterPC(pc)
+			}
+		case vm.OP_TFORCALL:
+			if reg >= a+2 {
+				setReg = filterPC(pc)
+			}
+		case vm.OP_CALL, vm.OP_TAILCALL:
+			if reg >= a {
+				setReg = filterPC(pc)
+			}
+		case vm.OP_JMP:
+			_, sbx := inst.AsBx()
+			dest := pc + 1 + sbx
+			if pc < dest && dest <= lastPC && dest > jmpTarget {
+				jmpTarget = dest
+			}
+		default:
+			if inst.SetsA() && reg == a {
+				setReg = filterPC(pc)
+			}
+		}
+	}
+	return setReg
+}
+
+// returns the name of the n-th local variable active at pc
+func localName(proto *binary.Prototype, n, pc int) string {
+	for _, locVar := range proto.LocVars {
+		if int(locVar.StartPC) > pc {
+			break
+		}
+		if pc < int(locVar.EndPC) {
+			n--
+			if n == 0 {
+				return locVar.VarName
+			}
+		}
+	}
+	return ""
+}
+
+func upvalName(proto *binary.Prototype, idx int) string {
+	if idx < len(proto.UpvalueNames) && proto.UpvalueNames[idx] != "" {
+		return proto.UpvalueNames[idx]
+	}
+	return "?"
+}
+
+// prefix of error messages raised at the given line
+func where(proto *binary.Prototype, line int) string {
+	if line > 0 {
+		return fmt.Sprintf("%s:%d: ", api.ChunkID(sourceOf(proto)), line)
+	}
+	return fmt.Sprintf("%s:?: ", api.ChunkID(sourceOf(proto)))
+}
+
+// stripped chunks have no source
+func sourceOf(proto *binary.Prototype) string {
+	if proto.Source == "" {
+		return "=?"
+	}
+	return proto.Source
+}
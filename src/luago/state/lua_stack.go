@@ -1,16 +1,22 @@
 package state
 
-import "luago/api"
+import (
+	"luago/api"
+	"luago/binary"
+)
 
 type luaStack struct {
-	slots   []luaValue
-	top     int
-	state   *luaState
-	openuvs map[int]*upvalue
-	prev    *luaStack
-	closure *luaClosure
-	varargs []luaValue
-	pc      int
+	slots    []luaValue
+	top      int
+	state    *luaState
+	openuvs  map[int]*upvalue
+	prev     *luaStack
+	closure  *luaClosure
+	varargs  []luaValue
+	pc       int
+	tailcall bool // the function took the frame of one that tail called it
+	fresh    bool // called from Go, so runLuaClosure returns with it
+	nResults int  // number of results wanted by a Lua caller
 }
 
 func newLuaStack(size int, state *luaState) *luaStack {
@@ -23,11 +29,35 @@ func newLuaStack(size int, state *luaState) *luaStack {
 
 func (stack *luaStack) check(n int) {
 	free := len(stack.slots) - stack.top
+	if n <= free {
+		return
+	}
+	old := stack.slots
 	for i := free; i < n; i++ {
-		stack.slots = append(stack.slots, nil)
+		stack.slots = append(stack.slots, nilValue)
+	}
+	stack.state.nSlots += n - free
+	if len(old) > 0 && &stack.slots[0] != &old[0] { // moved, so open upvalues point into old
+		for idx, upval := range stack.openuvs {
+			upval.val = &stack.slots[idx]
+		}
 	}
 }
 
+/**
+ * The slots the frame counts for against LUAI_MAXSTACK: the registers and
+ * the varargs of a Lua function, or the whole stack of a Go function, as
+ * luaD_precall reserves them. The room a Lua frame keeps above its
+ * registers for the VM's own pushes is not counted.
+ */
+func (stack *luaStack) size() int {
+	n := len(stack.slots) + len(stack.varargs)
+	if stack.closure != nil && stack.closure.proto != nil {
+		n -= api.LUA_MINSTACK
+	}
+	return n
+}
+
 func (stack *luaStack) push(val luaValue) {
 	if stack.top == len(stack.slots) {
 		panic("stack overflow")
@@ -42,7 +72,7 @@ func (stack *luaStack) pop() luaValue {
 	}
 	stack.top--
 	val := stack.slots[stack.top]
-	stack.slots[stack.top] = nil
+	stack.slots[stack.top] = nilValue
 	return val
 }
 
@@ -72,14 +102,14 @@ func (stack *luaStack) isValid(idx int) bool {
 
 func (stack *luaStack) get(idx int) luaValue {
 	if idx == api.LUA_REGISTRYINDEX {
-		return stack.state.registry
+		return tableValue(stack.state.registry)
 	}
 
 	if idx < api.LUA_REGISTRYINDEX { // upvalue
 		uvIdx := api.LUA_REGISTRYINDEX - idx - 1
 		closure := stack.closure
 		if closure == nil || uvIdx >= len(closure.upvals) {
-			return nil
+			return nilValue
 		}
 		return *(closure.upvals[uvIdx].val)
 	}
@@ -89,12 +119,12 @@ func (stack *luaStack) get(idx int) luaValue {
 		return stack.slots[absIdx-1]
 	}
 
-	return nil
+	return nilValue
 }
 
 func (stack *luaStack) set(idx int, val luaValue) {
 	if idx == api.LUA_REGISTRYINDEX {
-		stack.state.registry = val.(*luaTable)
+		stack.state.registry = val.table()
 		return
 	}
 
@@ -138,7 +168,29 @@ func (stack *luaStack) pushN(vals []luaValue, n int) {
 		if i < nVals {
 			stack.push(vals[i])
 		} else {
-			stack.push(nil)
+			stack.push(nilValue)
+		}
+	}
+}
+
+// returns the prototype of the running Lua function, nil for Go functions
+func (stack *luaStack) proto() *binary.Prototype {
+	if stack.closure != nil && stack.closure.proto != nil {
+		return stack.closure.proto.Prototype
+	}
+	return nil
+}
+
+// returns the line of the instruction being executed, -1 if unknown
+func (stack *luaStack) currentLine() int {
+	if proto := stack.proto(); proto != nil {
+		pc := stack.pc - 1
+		if pc < 0 {
+			pc = 0
+		}
+		if pc < len(proto.LineInfo) {
+			return int(proto.LineInfo[pc])
 		}
 	}
+	return -1
 }
@@ -2,25 +2,51 @@ package state
 
 import (
 	"fmt"
+	"io"
 	"luago/api"
 	"luago/binary"
 	"luago/compiler"
 	"luago/number"
 	"luago/vm"
 	"math"
+	"strconv"
+	"strings"
+)
+
+// frames and slots a message handler may use past the limits, as ERRORSTACKSIZE
+const (
+	extraErrorCalls = 200
+	extraErrorSlots = extraErrorCalls * api.LUA_MINSTACK
 )
 
 type luaState struct {
 	registry *luaTable
 	stack    *luaStack
-}
-
-func New() *luaState {
+	/* call limits */
+	nCalls    int // number of frames on the stack
+	nCCalls   int // number of nested calls made from Go
+	maxCalls  int // limit of nCalls, see SetCallLimits
+	maxCCalls int // limit of nCCalls, see SetCallLimits
+	callLimit int // maxCalls, raised while a stack overflow is handled
+	nSlots    int // slots taken by the frames on the stack, see luaStack.size
+	slotLimit int // LUAI_MAXSTACK, raised while a stack overflow is handled
+	/* coroutine */
+	coStatus     int
+	coCaller     *luaState // thread that resumed this one
+	coChan       chan int
+	self         *luaThread // handle of this thread, nil while it is suspended
+	hasFinalizer bool       // self has the finalizer releasing a suspended thread
+}
+
+func New() *luaThread {
 	registry := newLuaTable(0, 0)
-	registry.put(api.LUA_RIDX_GLOBALS, newLuaTable(0, 0)) // `_G`
+	registry.put(intValue(api.LUA_RIDX_GLOBALS), tableValue(newLuaTable(0, 0))) // `_G`
 	state := &luaState{registry: registry}
+	state.self = &luaThread{state}
+	state.SetCallLimits(api.LUAI_MAXCALLS, api.LUAI_MAXCCALLS)
+	registry.put(intValue(api.LUA_RIDX_MAINTHREAD), threadValue(state.self))
 	state.stack = newLuaStack(api.LUA_MINSTACK, state)
-	return state
+	return state.self
 }
 
 func (state *luaState) GetTop() int {
@@ -32,8 +58,12 @@ func (state *luaState) AbsIndex(idx int) int {
 }
 
 func (state *luaState) CheckStack(n int) bool {
+	grow := n - (len(state.stack.slots) - state.stack.top)
+	if n < 0 || state.stack.top+n > api.LUAI_MAXSTACK || grow > 0 && state.nSlots+grow > state.slotLimit {
+		return false // would exceed the stack limit
+	}
 	state.stack.check(n)
-	return true // never fails
+	return true
 }
 
 func (state *luaState) Pop(n int) {
@@ -87,7 +117,7 @@ func (state *luaState) SetTop(idx int) {
 		}
 	} else if n < 0 {
 		for i := 0; i > n; i-- {
-			state.stack.push(nil)
+			state.stack.push(nilValue)
 		}
 	}
 }
@@ -139,9 +169,7 @@ func (state *luaState) IsBoolean(idx int) bool {
 }
 
 func (state *luaState) IsInteger(idx int) bool {
-	val := state.stack.get(idx)
-	_, ok := val.(int64)
-	return ok
+	return state.stack.get(idx).tag == tagInteger
 }
 
 func (state *luaState) IsNumber(idx int) bool {
@@ -159,13 +187,25 @@ func (state *luaState) IsFunction(idx int) bool {
 }
 
 func (state *luaState) IsGoFunction(idx int) bool {
-	val := state.stack.get(idx)
-	if c, ok := val.(*luaClosure); ok {
+	if c := state.stack.get(idx).closure(); c != nil {
 		return c.goFun != nil
 	}
 	return false
 }
 
+func (state *luaState) IsThread(idx int) bool {
+	return state.Type(idx) == api.LUA_TTHREAD
+}
+
+func (state *luaState) IsUserdata(idx int) bool {
+	t := state.Type(idx)
+	return t == api.LUA_TUSERDATA || t == api.LUA_TLIGHTUSERDATA
+}
+
+func (state *luaState) IsLightUserdata(idx int) bool {
+	return state.Type(idx) == api.LUA_TLIGHTUSERDATA
+}
+
 func (state *luaState) ToBoolean(idx int) bool {
 	return convertToBoolean(state.stack.get(idx))
 }
@@ -195,12 +235,16 @@ func (state *luaState) ToString(idx int) string {
 
 func (state *luaState) ToStringX(idx int) (string, bool) {
 	val := state.stack.get(idx)
-	switch x := val.(type) {
-	case string:
-		return x, true
-	case int64, float64:
-		s := fmt.Sprintf("%v", x)
-		state.stack.set(idx, s)
+	switch val.tag {
+	case tagString:
+		return val.str()
+	case tagInteger:
+		s := strconv.FormatInt(val.integer(), 10)
+		state.stack.set(idx, stringValue(s))
+		return s, true
+	case tagFloat:
+		s := number.FloatToString(val.float())
+		state.stack.set(idx, stringValue(s))
 		return s, true
 	default:
 		return "", false
@@ -208,18 +252,35 @@ func (state *luaState) ToStringX(idx int) (string, bool) {
 }
 
 func (state *luaState) ToGoFunction(idx int) api.GoFunction {
-	val := state.stack.get(idx)
-	if c, ok := val.(*luaClosure); ok {
+	if c := state.stack.get(idx).closure(); c != nil {
 		return c.goFun
 	}
 	return nil
 }
 
+func (state *luaState) ToThread(idx int) api.LuaState {
+	if t := state.stack.get(idx).thread(); t != nil {
+		return t
+	}
+	return nil
+}
+
+// returns the Go value of a full or light userdata, nil for other values
+func (state *luaState) ToUserdata(idx int) interface{} {
+	switch val := state.stack.get(idx); val.tag {
+	case tagUserdata:
+		return val.userdata().data
+	case tagLightUserdata:
+		return val.ref.(lightUserdata).data
+	}
+	return nil
+}
+
 func (state *luaState) RawLen(idx int) uint {
 	val := state.stack.get(idx)
-	if s, ok := val.(string); ok {
+	if s, ok := val.str(); ok {
 		return uint(len(s))
-	} else if t, ok := val.(*luaTable); ok {
+	} else if t := val.table(); t != nil {
 		return uint(t.len())
 	} else {
 		return 0
@@ -227,23 +288,27 @@ func (state *luaState) RawLen(idx int) uint {
 }
 
 func (state *luaState) PushNil() {
-	state.stack.push(nil)
+	state.stack.push(nilValue)
 }
 
 func (state *luaState) PushBoolean(b bool) {
-	state.stack.push(b)
+	state.stack.push(boolValue(b))
 }
 
 func (state *luaState) PushInteger(n int64) {
-	state.stack.push(n)
+	state.stack.push(intValue(n))
 }
 
 func (state *luaState) PushNumber(n float64) {
-	state.stack.push(n)
+	state.stack.push(floatValue(n))
 }
 
 func (state *luaState) PushString(s string) {
-	state.stack.push(s)
+	state.stack.push(stringValue(s))
+}
+
+func (state *luaState) PushFString(format string, a ...interface{}) {
+	state.stack.push(stringValue(fmt.Sprintf(format, a...)))
 }
 
 func (state *luaState) PushGoFunction(f api.GoFunction) {
@@ -256,22 +321,36 @@ func (state *luaState) PushGoClosure(f api.GoFunction, n int) {
 		val := state.stack.pop()
 		c.upvals[i] = &upvalue{&val}
 	}
-	state.stack.push(c)
+	state.stack.push(closureValue(c))
 }
 
 func (state *luaState) PushGlobalTable() {
-	state.stack.push(state.registry.get(api.LUA_RIDX_GLOBALS))
+	state.stack.push(state.registry.get(intValue(api.LUA_RIDX_GLOBALS)))
+}
+
+// pushes the thread itself, returns true if it is the main thread
+func (state *luaState) PushThread() bool {
+	state.stack.push(threadValue(state.self))
+	return state.registry.get(intValue(api.LUA_RIDX_MAINTHREAD)).thread() == state.self
+}
+
+// data must be comparable since light userdata are compared by value
+func (state *luaState) PushLightUserdata(data interface{}) {
+	state.stack.push(lightUserdataValue(data))
 }
 
 func (state *luaState) Arith(op api.ArithOp) {
-	var a, b, r luaValue
-	b = state.stack.pop()
+	b := state.stack.pop()
+	a := b
 	if op != api.LUA_OPUNM && op != api.LUA_OPBNOT {
 		a = state.stack.pop()
-	} else {
-		a = b
 	}
+	state.stack.push(state.arith(a, b, op))
+}
 
+// a op b, with coercions and metamethods; unary operators ignore b
+func (state *luaState) arith(a, b luaValue, op api.ArithOp) luaValue {
+	var r luaValue
 	var mName string
 	var iFunc func(int64, int64) int64
 	var fFunc func(float64, float64) float64
@@ -330,34 +409,37 @@ func (state *luaState) Arith(op api.ArithOp) {
 	if fFunc == nil { // bitwise operation
 		if a, ok := convertToInteger(a); ok {
 			if b, ok := convertToInteger(b); ok {
-				r = iFunc(a, b)
+				r = intValue(iFunc(a, b))
 			}
 		}
 	} else {
 		if iFunc != nil {
-			if a, ok := a.(int64); ok {
-				if b, ok := b.(int64); ok {
-					r = iFunc(a, b)
+			if x, y := stringToNumber(a), stringToNumber(b); x.tag == tagInteger && y.tag == tagInteger {
+				if y.n == 0 && op == api.LUA_OPMOD {
+					state.runError("attempt to perform 'n%%0'")
+				} else if y.n == 0 && op == api.LUA_OPIDIV {
+					state.runError("attempt to perform 'n//0'")
 				}
+				r = intValue(iFunc(x.integer(), y.integer()))
 			}
 		}
 
-		if r == nil {
+		if r.isNil() {
 			if a, ok := convertToFloat(a); ok {
 				if b, ok := convertToFloat(b); ok {
-					r = fFunc(a, b)
+					r = floatValue(fFunc(a, b))
 				}
 			}
 		}
 	}
 
-	if r != nil {
-		state.stack.push(r)
+	if !r.isNil() {
+		return r
 	} else if r, ok := callMetamethod(a, b, mName, state); ok {
-		state.stack.push(r)
-	} else {
-		panic("arithmetic error")
+		return r
 	}
+	state.arithError(a, b, fFunc == nil)
+	return nilValue
 }
 
 func (state *luaState) Compare(idx1, idx2 int, op api.CompareOp) bool {
@@ -365,55 +447,59 @@ func (state *luaState) Compare(idx1, idx2 int, op api.CompareOp) bool {
 		return false
 	}
 
-	a := state.stack.get(idx1)
-	b := state.stack.get(idx2)
+	return state.compare(state.stack.get(idx1), state.stack.get(idx2), op)
+}
 
+// a op b, with metamethods
+func (state *luaState) compare(a, b luaValue, op api.CompareOp) bool {
 	switch op {
 	case api.LUA_OPEQ:
 		return equal(a, b, state)
 	case api.LUA_OPLT:
-		switch a := a.(type) {
-		case string:
-			if b, ok := b.(string); ok {
-				return a < b
+		switch a.tag {
+		case tagString:
+			if y, ok := b.str(); ok {
+				x, _ := a.str()
+				return x < y
 			}
-		case int64:
-			switch b := b.(type) {
-			case int64:
-				return a < b
-			case float64:
-				return float64(a) < b
+		case tagInteger:
+			switch b.tag {
+			case tagInteger:
+				return a.integer() < b.integer()
+			case tagFloat:
+				return ltIntFloat(a.integer(), b.float())
 			}
-		case float64:
-			switch b := b.(type) {
-			case float64:
-				return a < b
-			case int64:
-				return a < float64(b)
+		case tagFloat:
+			switch b.tag {
+			case tagFloat:
+				return a.float() < b.float()
+			case tagInteger:
+				return ltFloatInt(a.float(), b.integer())
 			}
 		}
 		if r, ok := callMetamethod(a, b, "__lt", state); ok {
 			return convertToBoolean(r)
 		}
 	case api.LUA_OPLE:
-		switch a := a.(type) {
-		case string:
-			if b, ok := b.(string); ok {
-				return a <= b
+		switch a.tag {
+		case tagString:
+			if y, ok := b.str(); ok {
+				x, _ := a.str()
+				return x <= y
 			}
-		case int64:
-			switch b := b.(type) {
-			case int64:
-				return a <= b
-			case float64:
-				return float64(a) <= b
+		case tagInteger:
+			switch b.tag {
+			case tagInteger:
+				return a.integer() <= b.integer()
+			case tagFloat:
+				return leIntFloat(a.integer(), b.float())
 			}
-		case float64:
-			switch b := b.(type) {
-			case float64:
-				return a <= b
-			case int64:
-				return a <= float64(b)
+		case tagFloat:
+			switch b.tag {
+			case tagFloat:
+				return a.float() <= b.float()
+			case tagInteger:
+				return leFloatInt(a.float(), b.integer())
 			}
 		}
 		if r, ok := callMetamethod(a, b, "__le", state); ok {
@@ -424,7 +510,8 @@ func (state *luaState) Compare(idx1, idx2 int, op api.CompareOp) bool {
 	default:
 		panic("invalid compare op")
 	}
-	panic("comparison error")
+	state.orderError(a, b)
+	return false
 }
 
 func (state *luaState) RawEqual(idx1, idx2 int) bool {
@@ -443,7 +530,7 @@ func (state *luaState) NewTable() {
 }
 
 func (state *luaState) CreateTable(nArr, nRec int) {
-	state.stack.push(newLuaTable(nArr, nRec))
+	state.stack.push(tableValue(newLuaTable(nArr, nRec)))
 }
 
 func (state *luaState) GetTable(idx int) api.LuaType {
@@ -453,11 +540,11 @@ func (state *luaState) GetTable(idx int) api.LuaType {
 }
 
 func (state *luaState) GetField(idx int, k string) api.LuaType {
-	return state.getTable(state.stack.get(idx), k, false)
+	return state.getTable(state.stack.get(idx), stringValue(k), false)
 }
 
 func (state *luaState) GetI(idx int, i int64) api.LuaType {
-	return state.getTable(state.stack.get(idx), i, false)
+	return state.getTable(state.stack.get(idx), intValue(i), false)
 }
 
 func (state *luaState) RawGet(idx int) api.LuaType {
@@ -467,19 +554,34 @@ func (state *luaState) RawGet(idx int) api.LuaType {
 }
 
 func (state *luaState) RawGetI(idx int, i int64) api.LuaType {
-	return state.getTable(state.stack.get(idx), i, true)
+	return state.getTable(state.stack.get(idx), intValue(i), true)
 }
 
 func (state *luaState) GetMetatable(idx int) bool {
 	if mt := getMetatable(state.stack.get(idx), state); mt != nil {
-		state.stack.push(mt)
+		state.stack.push(tableValue(mt))
 		return true
 	}
 	return false
 }
 
 func (state *luaState) GetGlobal(name string) api.LuaType {
-	return state.getTable(state.registry.get(api.LUA_RIDX_GLOBALS), name, true)
+	return state.getTable(state.registry.get(intValue(api.LUA_RIDX_GLOBALS)), stringValue(name), true)
+}
+
+// pushes a new full userdata wrapping data
+func (state *luaState) NewUserdata(data interface{}) {
+	state.stack.push(userdataValue(&userdata{data: data}))
+}
+
+// pushes the user value of the userdata at idx
+func (state *luaState) GetUserValue(idx int) api.LuaType {
+	u := state.stack.get(idx).userdata()
+	if u == nil {
+		state.runError("full userdata expected")
+	}
+	state.stack.push(u.uservalue)
+	return typeOf(u.uservalue)
 }
 
 func (state *luaState) SetTable(idx int) {
@@ -492,13 +594,13 @@ func (state *luaState) SetTable(idx int) {
 func (state *luaState) SetField(idx int, k string) {
 	t := state.stack.get(idx)
 	v := state.stack.pop()
-	state.setTable(t, k, v, false)
+	state.setTable(t, stringValue(k), v, false)
 }
 
 func (state *luaState) SetI(idx int, i int64) {
 	t := state.stack.get(idx)
 	v := state.stack.pop()
-	state.setTable(t, i, v, false)
+	state.setTable(t, intValue(i), v, false)
 }
 
 func (state *luaState) RawSet(idx int) {
@@ -511,25 +613,34 @@ func (state *luaState) RawSet(idx int) {
 func (state *luaState) RawSetI(idx int, i int64) {
 	t := state.stack.get(idx)
 	v := state.stack.pop()
-	state.setTable(t, i, v, true)
+	state.setTable(t, intValue(i), v, true)
 }
 
 func (state *luaState) SetMetatable(idx int) {
 	val := state.stack.get(idx)
 	mtVal := state.stack.pop()
-	if mtVal == nil {
+	if mtVal.isNil() {
 		setMetatable(val, nil, state)
-	} else if mt, ok := mtVal.(*luaTable); ok {
+	} else if mt := mtVal.table(); mt != nil {
 		setMetatable(val, mt, state)
 	} else {
-		panic("table expected") // TODO
+		state.runError("table expected")
 	}
 }
 
 func (state *luaState) SetGlobal(name string) {
-	t := state.registry.get(api.LUA_RIDX_GLOBALS)
+	t := state.registry.get(intValue(api.LUA_RIDX_GLOBALS))
 	v := state.stack.pop()
-	state.setTable(t, name, v, true)
+	state.setTable(t, stringValue(name), v, true)
+}
+
+// pops a value and sets it as the user value of the userdata at idx
+func (state *luaState) SetUserValue(idx int) {
+	u := state.stack.get(idx).userdata()
+	if u == nil {
+		state.runError("full userdata expected")
+	}
+	u.uservalue = state.stack.pop()
 }
 
 func (state *luaState) Register(name string, f api.GoFunction) {
@@ -537,58 +648,182 @@ func (state *luaState) Register(name string, f api.GoFunction) {
 	state.SetGlobal(name)
 }
 
-func (state *luaState) Load(chunk []byte, chunkName, mode string) int {
-	var proto *binary.Prototype
+/**
+ * Loads a chunk without running it and pushes it as a function whose
+ * `_ENV` is the global table. mode tells which kinds of chunk are accepted:
+ * "t" for text, "b" for binary and "bt" for both. Syntax errors, malformed
+ * binary chunks and chunks of a refused kind push an error message and
+ * return LUA_ERRSYNTAX.
+ */
+func (state *luaState) Load(chunk []byte, chunkName, mode string) (status int) {
+	kind := "text"
 	if binary.IsBinaryChunk(chunk) {
-		proto = binary.Parse(chunk)
+		kind = "binary"
+	}
+	if !strings.Contains(mode, kind[:1]) {
+		state.stack.push(stringValue(fmt.Sprintf("attempt to load a %s chunk (mode is '%s')", kind, mode)))
+		return api.LUA_ERRSYNTAX
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			luaErr, ok := r.(*api.LuaError) // raised by the compiler or the chunk reader
+			if !ok {
+				panic(r)
+			}
+			state.stack.push(valueOf(luaErr.Value))
+			status = api.LUA_ERRSYNTAX
+		}
+	}()
+	var proto *binary.Prototype
+	if kind == "binary" {
+		proto = binary.Parse(chunk, chunkName)
 	} else {
 		proto = compiler.Compile(string(chunk), chunkName)
 	}
-	c := newLuaClosure(proto)
+	c := newLuaClosure(newFuncProto(proto))
+	for i := range c.upvals { // a dumped function may have any number of them
+		c.upvals[i] = &upvalue{new(luaValue)}
+	}
 	if len(proto.Upvalues) > 0 {
-		val := state.registry.get(api.LUA_RIDX_GLOBALS) // `_ENV`
-		c.upvals[0] = &upvalue{&val}
+		*c.upvals[0].val = state.registry.get(intValue(api.LUA_RIDX_GLOBALS)) // `_ENV`
 	}
-	state.stack.push(c)
+	state.stack.push(closureValue(c))
 	return api.LUA_OK
 }
 
-func (state *luaState) Call(nArgs, nResults int) {
-	val := state.stack.get(-(nArgs + 1))
-
-	c, ok := val.(*luaClosure)
-	if !ok {
-		if mf := getMetafield(val, "__call", state); mf != nil {
-			if c, ok = mf.(*luaClosure); ok {
-				state.stack.check(1)
-				state.stack.push(val)
-				state.Insert(-(nArgs + 2))
-				nArgs += 1
-			}
+/**
+ * Like Load, but reads the chunk from r. When env is not 0, the value at
+ * that index becomes the `_ENV` of the loaded function instead of the
+ * global table. Failures of r push a message and return LUA_ERRFILE.
+ */
+func (state *luaState) LoadReader(r io.Reader, chunkName, mode string, env int) int {
+	if env != 0 {
+		env = state.AbsIndex(env)
+	}
+	chunk, err := io.ReadAll(r)
+	if err != nil {
+		state.stack.push(stringValue(fmt.Sprintf("cannot read %s: %v", api.ChunkID(chunkName), err)))
+		return api.LUA_ERRFILE
+	}
+	status := state.Load(chunk, chunkName, mode)
+	if status == api.LUA_OK && env != 0 {
+		state.PushValue(env)                       // environment for loaded function
+		if _, ok := state.SetUpvalue(-2, 1); !ok { // set it as 1st upvalue
+			state.Pop(1) // remove 'env' if the function has no upvalue
 		}
 	}
+	return status
+}
 
-	if ok {
-		if c.proto != nil {
-			state.callLuaClosure(nArgs, nResults, c)
-		} else {
-			state.callGoClosure(nArgs, nResults, c)
+// dumps the Lua function on the top of the stack as a binary chunk, returns nil for other values
+func (state *luaState) Dump(strip bool) []byte {
+	if c := state.stack.get(-1).closure(); c != nil && c.proto != nil {
+		return binary.Dump(c.proto.Prototype, strip)
+	}
+	return nil
+}
+
+func (state *luaState) Call(nArgs, nResults int) {
+	if state.nCCalls++; state.nCCalls >= state.maxCCalls {
+		if state.nCCalls == state.maxCCalls {
+			state.runError("C stack overflow")
+		} else if state.nCCalls >= state.maxCCalls+state.maxCCalls>>3 {
+			state.runError("error while handling stack overflow") // error while handling the error
 		}
+	}
+	c, nArgs := state.getCallee(nArgs)
+	if c.proto != nil {
+		state.callLuaClosure(nArgs, nResults, c)
 	} else {
-		panic("not a function")
+		state.callGoClosure(nArgs, nResults, c)
+	}
+	state.nCCalls--
+}
+
+/**
+ * Calls the function below the nArgs arguments on the top of the stack from
+ * the running Lua function, as luaD_precall. A Go function is run at once
+ * and true is returned. A Lua function only gets its frame pushed, so that
+ * runLuaClosure goes on with its code instead of calling itself, and false
+ * is returned; the caller finishes the call once the callee has returned.
+ */
+func (state *luaState) PreCall(nArgs, nResults int) bool {
+	c, nArgs := state.getCallee(nArgs)
+	if c.proto == nil {
+		state.callGoClosure(nArgs, nResults, c)
+		return true
+	}
+	frame := state.newLuaFrame(nArgs, c)
+	frame.nResults = nResults
+	state.pushLuaStack(frame)
+	return false
+}
+
+/**
+ * Sets the depth of nested calls past which "stack overflow" is raised, and
+ * the depth of nested calls made from Go, such as metamethods and functions
+ * called by Go functions, past which "C stack overflow" is raised. Threads
+ * created afterwards get the same limits.
+ */
+func (state *luaState) SetCallLimits(maxCalls, maxCCalls int) {
+	state.maxCalls = maxCalls
+	state.maxCCalls = maxCCalls
+	state.callLimit = maxCalls
+	state.slotLimit = api.LUAI_MAXSTACK
+}
+
+/**
+ * Returns the closure to call for the value below the nArgs arguments on the
+ * top of the stack. A value called through its __call metamethod becomes the
+ * first argument, so the new number of arguments is returned too.
+ */
+func (state *luaState) getCallee(nArgs int) (*luaClosure, int) {
+	val := state.stack.get(-(nArgs + 1))
+	if c := val.closure(); c != nil {
+		return c, nArgs
+	}
+	if mf := getMetafield(val, "__call", state); !mf.isNil() {
+		if c := mf.closure(); c != nil {
+			state.stack.check(1)
+			state.stack.push(val)
+			state.Insert(-(nArgs + 2))
+			return c, nArgs + 1
+		}
 	}
+	state.typeError(val, "call")
+	return nil, 0
 }
 
+/**
+ * Calls a function in protected mode. If msgh is not 0, the function at that
+ * index is called with the error object at the point of the error, before
+ * the stack is unwound, and its result becomes the error object. Panics that
+ * are not Lua errors, such as Go runtime faults, are not caught.
+ */
 func (state *luaState) PCall(nArgs, nResults, msgh int) (status int) {
 	caller := state.stack
+	nCCalls := state.nCCalls
+	var handler luaValue
+	if msgh != 0 {
+		handler = state.stack.get(msgh)
+	}
 	status = api.LUA_ERRRUN
 
 	defer func() {
 		if err := recover(); err != nil {
+			luaErr, ok := err.(*api.LuaError)
+			if !ok {
+				panic(err) // a bug, not a Lua error
+			}
+			errVal := valueOf(luaErr.Value)
+			if !handler.isNil() {
+				errVal, status = state.callErrorHandler(handler, errVal)
+			}
 			for state.stack != caller {
 				state.popLuaStack()
 			}
-			state.stack.push(err)
+			state.nCCalls = nCCalls
+			state.stack.push(errVal)
 		}
 	}()
 
@@ -597,22 +832,40 @@ func (state *luaState) PCall(nArgs, nResults, msgh int) (status int) {
 	return
 }
 
+// calls a message handler on top of the function that raised the error
+func (state *luaState) callErrorHandler(handler, errVal luaValue) (result luaValue, status int) {
+	defer func() {
+		if err := recover(); err != nil {
+			if _, ok := err.(*api.LuaError); !ok {
+				panic(err)
+			}
+			result, status = stringValue("error in error handling"), api.LUA_ERRERR
+		}
+	}()
+
+	state.stack.check(2)
+	state.stack.push(handler)
+	state.stack.push(errVal)
+	state.Call(1, 1)
+	return state.stack.pop(), api.LUA_ERRRUN
+}
+
 func (state *luaState) Len(idx int) {
 	val := state.stack.get(idx)
-	if s, ok := val.(string); ok {
-		state.stack.push(int64(len(s)))
+	if s, ok := val.str(); ok {
+		state.stack.push(intValue(int64(len(s))))
 	} else if r, ok := callMetamethod(val, val, "__len", state); ok {
 		state.stack.push(r)
-	} else if t, ok := val.(*luaTable); ok {
-		state.stack.push(int64(t.len()))
+	} else if t := val.table(); t != nil {
+		state.stack.push(intValue(int64(t.len())))
 	} else {
-		panic("length error")
+		state.typeError(val, "get length of")
 	}
 }
 
 func (state *luaState) Concat(n int) {
 	if n == 0 {
-		state.stack.push("")
+		state.stack.push(stringValue(""))
 	} else if n >= 2 {
 		for i := 1; i < n; i++ {
 			if state.IsString(-1) && state.IsString(-2) {
@@ -620,7 +873,7 @@ func (state *luaState) Concat(n int) {
 				s1 := state.ToString(-2)
 				state.stack.pop()
 				state.stack.pop()
-				state.stack.push(s1 + s2)
+				state.stack.push(stringValue(s1 + s2))
 				continue
 			}
 
@@ -629,7 +882,7 @@ func (state *luaState) Concat(n int) {
 			if r, ok := callMetamethod(a, b, "__concat", state); ok {
 				state.stack.push(r)
 			} else {
-				panic("concatenation error")
+				state.concatError(a, b)
 			}
 		}
 	}
@@ -637,20 +890,98 @@ func (state *luaState) Concat(n int) {
 
 func (state *luaState) Next(idx int) bool {
 	val := state.stack.get(idx)
-	if t, ok := val.(*luaTable); ok {
+	if t := val.table(); t != nil {
 		key := state.stack.pop()
-		if nextKey := t.nextKey(key); nextKey != nil {
+		nextKey, nextVal, ok := t.next(key)
+		if !ok {
+			state.runError("invalid key to 'next'")
+		}
+		if !nextKey.isNil() {
 			state.stack.push(nextKey)
-			state.stack.push(t.get(nextKey))
+			state.stack.push(nextVal)
 			return true
 		}
 		return false
 	}
-	panic("table expected")
+	state.runError("table expected")
+	return false
 }
 
+/**
+ * Raises the value on the top of the stack as an error. The error is
+ * located at the innermost running Lua function, if any.
+ */
 func (state *luaState) Error() int {
-	panic(state.stack.pop())
+	val := state.stack.pop()
+	err := &api.LuaError{Value: val.goValue()}
+	switch val.tag {
+	case tagString:
+		err.Message, _ = val.str()
+	case tagInteger:
+		err.Message = strconv.FormatInt(val.integer(), 10)
+	case tagFloat:
+		err.Message = number.FloatToString(val.float())
+	default:
+		err.Message = fmt.Sprintf("(error object is a %s value)", state.TypeName(typeOf(val)))
+	}
+	for stack := state.stack; stack != nil; stack = stack.prev {
+		if proto := stack.proto(); proto != nil {
+			err.ChunkName = proto.Source
+			err.Line = stack.currentLine()
+			break
+		}
+	}
+	panic(err)
+}
+
+// converts a numeral string and pushes the result, returns false if s is not a numeral
+func (state *luaState) StringToNumber(s string) bool {
+	if n := stringToNumber(stringValue(s)); n.tag != tagString {
+		state.stack.push(n)
+		return true
+	}
+	return false
+}
+
+// pushes the value of upvalue n of the function at funcIdx and returns its name
+func (state *luaState) GetUpvalue(funcIdx, n int) (string, bool) {
+	c, name, ok := state.upvalueOf(funcIdx, n)
+	if ok {
+		if uv := c.upvals[n-1]; uv != nil {
+			state.stack.push(*uv.val)
+		} else {
+			state.stack.push(nilValue)
+		}
+	}
+	return name, ok
+}
+
+// pops a value into upvalue n of the function at funcIdx and returns its name
+func (state *luaState) SetUpvalue(funcIdx, n int) (string, bool) {
+	c, name, ok := state.upvalueOf(funcIdx, n)
+	if ok {
+		val := state.stack.pop()
+		if uv := c.upvals[n-1]; uv != nil {
+			*uv.val = val // shared with other closures
+		} else {
+			c.upvals[n-1] = &upvalue{&val}
+		}
+	}
+	return name, ok
+}
+
+func (state *luaState) upvalueOf(funcIdx, n int) (*luaClosure, string, bool) {
+	c := state.stack.get(funcIdx).closure()
+	if c == nil || n < 1 || n > len(c.upvals) {
+		return nil, "", false
+	}
+	if c.proto == nil {
+		return c, "", true // Go closures have no upvalue names
+	}
+	if n > len(c.proto.UpvalueNames) {
+		return c, "(*no name)", true
+	}
+	return c, c.proto.UpvalueNames[n-1], true
 }
 
 func (state *luaState) PC() int {
@@ -662,13 +993,146 @@ func (state *luaState) AddPC(n int) {
 }
 
 func (state *luaState) Fetch() uint32 {
-	c := state.stack.closure.proto.Code[state.stack.pc]
+	c := state.stack.closure.proto.code[state.stack.pc]
 	state.stack.pc++
 	return c
 }
 
 func (state *luaState) GetConst(idx int) {
-	state.stack.push(state.stack.closure.proto.Constants[idx])
+	state.stack.push(state.stack.closure.proto.consts[idx])
+}
+
+// R(A) := RK(B) op RK(C); numbers are computed in place, anything else goes through arith
+func (state *luaState) RegArith(a, b, c int, op api.ArithOp) {
+	x, y := state.rk(b), state.rk(c)
+	if r, ok := numberArith(x, y, op); ok {
+		state.stack.slots[a] = r
+	} else {
+		r := state.arith(x, y, op)
+		state.stack.slots[a] = r // a metamethod may have grown the stack
+	}
+}
+
+// RK(B) op RK(C)
+func (state *luaState) RegCompare(b, c int, op api.CompareOp) bool {
+	x, y := state.rk(b), state.rk(c)
+	if x.tag == tagInteger && y.tag == tagInteger {
+		x, y := x.integer(), y.integer()
+		switch op {
+		case api.LUA_OPEQ:
+			return x == y
+		case api.LUA_OPLT:
+			return x < y
+		case api.LUA_OPLE:
+			return x <= y
+		}
+	} else if x.tag == tagFloat && y.tag == tagFloat {
+		x, y := x.float(), y.float()
+		switch op {
+		case api.LUA_OPEQ:
+			return x == y
+		case api.LUA_OPLT:
+			return x < y
+		case api.LUA_OPLE:
+			return x <= y
+		}
+	}
+	return state.compare(x, y, op)
+}
+
+/**
+ * R(A) -= R(A+2), after checking the control values of a numeric for loop.
+ * The loop counts with integers when the initial value and the step are
+ * integers and the limit is a number, which is then clipped to an integer
+ * as lvm.c's forlimit does. Otherwise all three values become floats.
+ */
+func (state *luaState) ForPrep(a int) {
+	slots := state.stack.slots
+	init, limit, step := slots[a], slots[a+1], slots[a+2]
+	if init.tag == tagInteger && step.tag == tagInteger {
+		if iLimit, stop, ok := forLimit(limit, step.integer()); ok {
+			iInit := init.integer()
+			if stop {
+				iInit = 0 // the clipped limit would still let the loop run once
+			}
+			slots[a], slots[a+1] = intValue(iInit-step.integer()), intValue(iLimit)
+			return
+		}
+	}
+	nLimit, ok := convertToFloat(limit)
+	if !ok {
+		state.runError("'for' limit must be a number")
+	}
+	nStep, ok := convertToFloat(step)
+	if !ok {
+		state.runError("'for' step must be a number")
+	}
+	nInit, ok := convertToFloat(init)
+	if !ok {
+		state.runError("'for' initial value must be a number")
+	}
+	slots[a], slots[a+1], slots[a+2] = floatValue(nInit-nStep), floatValue(nLimit), floatValue(nStep)
+}
+
+/**
+ * The limit of an integer loop: floor(limit) when counting up, ceil(limit)
+ * when counting down, or the largest or smallest integer for a float out of
+ * range. stop is true when no value of the loop can reach that limit.
+ */
+func forLimit(limit luaValue, step int64) (iLimit int64, stop, ok bool) {
+	limit = stringToNumber(limit)
+	switch limit.tag {
+	case tagInteger:
+		return limit.integer(), false, true
+	case tagFloat:
+		round := math.Floor
+		if step < 0 {
+			round = math.Ceil
+		}
+		if i, ok := _roundToInteger(limit.float(), round); ok {
+			return i, false, true
+		} else if limit.float() > 0 {
+			return math.MaxInt64, step < 0, true
+		} else { // negative or NaN
+			return math.MinInt64, step >= 0, true
+		}
+	}
+	return 0, false, false
+}
+
+/**
+ * R(A) += R(A+2), and reports whether R(A) is still within the limit R(A+1),
+ * in which case R(A+3) := R(A). ForPrep leaves either three integers or
+ * three floats in the control registers, which the loop body cannot change.
+ */
+func (state *luaState) ForLoop(a int) bool {
+	slots := state.stack.slots
+	if step := slots[a+2]; step.tag == tagInteger {
+		iStep := step.integer()
+		idx, limit := slots[a].integer()+iStep, slots[a+1].integer()
+		slots[a] = intValue(idx)
+		if 0 < iStep && idx <= limit || iStep <= 0 && limit <= idx {
+			slots[a+3] = slots[a]
+			return true
+		}
+	} else {
+		fStep := step.float()
+		idx, limit := slots[a].float()+fStep, slots[a+1].float()
+		slots[a] = floatValue(idx)
+		if 0 < fStep && idx <= limit || fStep <= 0 && limit <= idx {
+			slots[a+3] = slots[a]
+			return true
+		}
+	}
+	return false
+}
+
+// a constant if the high bit of x is set, a register otherwise
+func (state *luaState) rk(x int) luaValue {
+	if x > 0xff {
+		return state.stack.closure.proto.consts[x&0xff]
+	}
+	return state.stack.slots[x]
 }
 
 func (state *luaState) RegisterCount() int {
@@ -685,7 +1149,7 @@ func (state *luaState) LoadVararg(n int) {
 
 func (state *luaState) LoadProto(idx int) {
 	stack := state.stack
-	proto := stack.closure.proto.Protos[idx]
+	proto := stack.closure.proto.protos[idx]
 	c := newLuaClosure(proto)
 	for i, info := range proto.Upvalues {
 		uvIdx := int(info.Index)
@@ -703,7 +1167,7 @@ func (state *luaState) LoadProto(idx int) {
 			c.upvals[i] = stack.closure.upvals[uvIdx]
 		}
 	}
-	stack.push(c)
+	stack.push(closureValue(c))
 }
 
 func (state *luaState) CloseUpvalues(a int) {
@@ -717,22 +1181,22 @@ func (state *luaState) CloseUpvalues(a int) {
 }
 
 func (state *luaState) getTable(t, k luaValue, raw bool) api.LuaType {
-	if t, ok := t.(*luaTable); ok {
-		v := t.get(k)
-		if v != nil || raw || !t.hasMetafield("__index") {
+	if tbl := t.table(); tbl != nil {
+		v := tbl.get(k)
+		if !v.isNil() || raw || !tbl.hasMetafield("__index") {
 			state.stack.push(v)
 			return typeOf(v)
 		}
 	}
 
 	if !raw {
-		if mf := getMetafield(t, "__index", state); mf != nil {
-			switch x := mf.(type) {
-			case *luaTable:
-				return state.getTable(x, k, false)
-			case *luaClosure:
+		if mf := getMetafield(t, "__index", state); !mf.isNil() {
+			switch mf.tag {
+			case tagTable:
+				return state.getTable(mf, k, false)
+			case tagFunction:
 				state.stack.check(3)
-				state.stack.push(x)
+				state.stack.push(mf)
 				state.stack.push(t)
 				state.stack.push(k)
 				state.Call(2, 1)
@@ -741,26 +1205,32 @@ func (state *luaState) getTable(t, k luaValue, raw bool) api.LuaType {
 		}
 	}
 
-	panic("index error")
+	state.typeError(t, "index")
+	return api.LUA_TNONE
 }
 
 func (state *luaState) setTable(t, k, v luaValue, raw bool) {
-	if t, ok := t.(*luaTable); ok {
-		if raw || t.get(k) != nil || !t.hasMetafield("__newindex") {
-			t.put(k, v)
+	if tbl := t.table(); tbl != nil {
+		if raw || !tbl.get(k).isNil() || !tbl.hasMetafield("__newindex") {
+			if k.isNil() {
+				state.runError("table index is nil")
+			} else if k.tag == tagFloat && math.IsNaN(k.float()) {
+				state.runError("table index is NaN")
+			}
+			tbl.put(k, v)
 			return
 		}
 	}
 
 	if !raw {
-		if mf := getMetafield(t, "__newindex", state); mf != nil {
-			switch x := mf.(type) {
-			case *luaTable:
-				state.setTable(x, k, v, false)
+		if mf := getMetafield(t, "__newindex", state); !mf.isNil() {
+			switch mf.tag {
+			case tagTable:
+				state.setTable(mf, k, v, false)
 				return
-			case *luaClosure:
+			case tagFunction:
 				state.stack.check(4)
-				state.stack.push(x)
+				state.stack.push(mf)
 				state.stack.push(t)
 				state.stack.push(k)
 				state.stack.push(v)
@@ -770,10 +1240,27 @@ func (state *luaState) setTable(t, k, v luaValue, raw bool) {
 		}
 	}
 
-	panic("index error")
+	state.typeError(t, "index")
 }
 
+/**
+ * Pushes a frame, after checking both the depth of nested calls and the
+ * number of slots all frames take together, since a deep recursion of a
+ * function with many registers exhausts the memory long before the depth
+ * limit. Past either limit, "stack overflow" is raised.
+ */
 func (state *luaState) pushLuaStack(stack *luaStack) {
+	size := stack.size()
+	if state.nCalls >= state.callLimit || state.nSlots+size > state.slotLimit {
+		if state.callLimit > state.maxCalls { // overflow while handling overflow?
+			state.runError("error while handling stack overflow")
+		}
+		state.callLimit = state.maxCalls + extraErrorCalls // room for a message handler
+		state.slotLimit = api.LUAI_MAXSTACK + extraErrorSlots
+		state.runError("stack overflow")
+	}
+	state.nCalls++
+	state.nSlots += size
 	stack.prev = state.stack
 	state.stack = stack
 }
@@ -782,9 +1269,29 @@ func (state *luaState) popLuaStack() {
 	stack := state.stack
 	state.stack = stack.prev
 	stack.prev = nil
+	state.nSlots -= stack.size()
+	if state.nCalls--; state.nCalls < state.maxCalls && state.nSlots < api.LUAI_MAXSTACK {
+		state.callLimit = state.maxCalls
+		state.slotLimit = api.LUAI_MAXSTACK
+	}
 }
 
 func (state *luaState) callLuaClosure(nArgs, nResults int, closure *luaClosure) {
+	frame := state.newLuaFrame(nArgs, closure)
+	frame.fresh = true
+	state.pushLuaStack(frame)
+	n := state.runLuaClosure()
+	state.returnResults(n, nResults)
+}
+
+func (state *luaState) callGoClosure(nArgs, nResults int, closure *luaClosure) {
+	state.pushLuaStack(state.newGoFrame(nArgs, closure))
+	n := closure.goFun(state.self)
+	state.returnResults(n, nResults)
+}
+
+// pops a Lua function and its nArgs arguments into a new frame for it
+func (state *luaState) newLuaFrame(nArgs int, closure *luaClosure) *luaStack {
 	nRegs := int(closure.proto.MaxStackSize)
 	nParams := int(closure.proto.NumParams)
 	isVararg := closure.proto.IsVararg != 0
@@ -798,34 +1305,28 @@ func (state *luaState) callLuaClosure(nArgs, nResults int, closure *luaClosure)
 	if nArgs > nParams && isVararg {
 		newStack.varargs = args[nParams:]
 	}
-
-	state.pushLuaStack(newStack)
-	state.runLuaClosure()
-	state.popLuaStack()
-
-	if nResults != 0 {
-		results := newStack.popN(newStack.top - nRegs)
-		if nResults < 0 {
-			nResults = len(results)
-		}
-		state.stack.check(nResults)
-		state.stack.pushN(results, nResults)
-	}
+	return newStack
 }
 
-func (state *luaState) callGoClosure(nArgs, nResults int, closure *luaClosure) {
+// pops a Go function and its nArgs arguments into a new frame for it
+func (state *luaState) newGoFrame(nArgs int, closure *luaClosure) *luaStack {
 	newStack := newLuaStack(nArgs+api.LUA_MINSTACK, state)
 	newStack.closure = closure
 
 	args := state.stack.popN(nArgs + 1)[1:]
 	newStack.pushN(args, nArgs)
+	return newStack
+}
 
-	state.pushLuaStack(newStack)
-	r := closure.goFun(state)
+/**
+ * Pops the running frame and moves the n values on its top to the caller,
+ * adjusted to nResults values unless nResults is negative.
+ */
+func (state *luaState) returnResults(n, nResults int) {
+	results := state.stack.popN(n)
 	state.popLuaStack()
 
 	if nResults != 0 {
-		results := newStack.popN(r)
 		if nResults < 0 {
 			nResults = len(results)
 		}
@@ -834,12 +1335,62 @@ func (state *luaState) callGoClosure(nArgs, nResults int, closure *luaClosure) {
 	}
 }
 
-func (state *luaState) runLuaClosure() {
+/**
+ * Calls the value below the nArgs arguments on the top of the stack in place
+ * of the running Lua function, whose frame is dropped so that tail calls
+ * take no space. A Lua callee goes on running in the loop of runLuaClosure;
+ * a Go callee is run here and leaves only its results in its frame.
+ */
+func (state *luaState) TailCall(nArgs int) {
+	c, nArgs := state.getCallee(nArgs)
+	var frame *luaStack
+	if c.proto != nil {
+		frame = state.newLuaFrame(nArgs, c)
+	} else {
+		frame = state.newGoFrame(nArgs, c)
+	}
+	frame.tailcall = true
+	frame.fresh, frame.nResults = state.stack.fresh, state.stack.nResults
+	state.popLuaStack()
+	state.pushLuaStack(frame)
+
+	if c.proto == nil {
+		n := c.goFun(state.self)
+		results := frame.popN(n)
+		frame.top = 0
+		frame.pushN(results, n)
+	}
+}
+
+/**
+ * Runs the Lua function of the current frame until it returns, along with
+ * the Lua functions it calls: their frames are pushed and popped by the
+ * CALL and RETURN instructions without nesting Go calls. Returns the number
+ * of results left on the top of the last frame.
+ */
+func (state *luaState) runLuaClosure() int {
 	for {
 		inst := vm.Instruction(state.Fetch())
 		inst.Execute(state)
-		if inst.Opcode() == vm.OP_RETURN {
-			break
+
+		var n int
+		switch inst.Opcode() {
+		case vm.OP_RETURN:
+			n = state.stack.top - int(state.stack.closure.proto.MaxStackSize)
+		case vm.OP_TAILCALL:
+			if state.stack.closure.proto != nil {
+				continue // a Lua function runs in place of the caller
+			}
+			n = state.stack.top // a Go function returned in place of the caller
+		default:
+			continue
+		}
+
+		if state.stack.fresh { // called from Go?
+			return n
 		}
+		state.returnResults(n, state.stack.nResults)
+		caller := state.stack
+		vm.Instruction(caller.closure.proto.Code[caller.pc-1]).FinishCall(state)
 	}
 }
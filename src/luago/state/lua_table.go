@@ -3,144 +3,334 @@ package state
 import (
 	"luago/number"
 	"math"
+	"math/bits"
 )
 
+// integer keys up to 2^maxABits are candidates for the array part
+const maxABits = 31
+
+/**
+ * A table has an array part holding the values of keys 1..len(arr), and a
+ * hash part holding the other entries in insertion order, with an index from
+ * key to position. Assigning nil to a field leaves its entry in place with a
+ * nil value, so that a traversal can go on past it; dead entries are
+ * dropped when the hash part is full and gets rebuilt, which is also when
+ * integer keys move between the two parts as in Lua's rehash.
+ */
 type luaTable struct {
 	metatable *luaTable
-	keys      map[luaValue]luaValue
-	lastKey   luaValue
-	changed   bool
-	a         []luaValue
-	m         map[luaValue]luaValue
+	arr       []luaValue
+	nodes     []node           // hash part, cap(nodes) is its size
+	index     map[luaValue]int // position of each key in nodes
+}
+
+type node struct {
+	key, val luaValue
 }
 
+// largest size preallocated for a part of a new table, whatever the hint
+const maxSizeHint = 1 << 16
+
 func newLuaTable(nArr, nRec int) *luaTable {
 	t := &luaTable{}
+	if nArr > maxSizeHint { // the hints may come from a corrupt binary chunk
+		nArr = maxSizeHint
+	}
+	if nRec > maxSizeHint {
+		nRec = maxSizeHint
+	}
 	if nArr > 0 {
-		t.a = make([]luaValue, 0, nArr)
+		t.arr = make([]luaValue, 0, nArr)
 	}
 	if nRec > 0 {
-		t.m = make(map[luaValue]luaValue, nRec)
+		t.nodes = make([]node, 0, nRec)
+		t.index = make(map[luaValue]int, nRec)
 	}
 	return t
 }
 
 func (table *luaTable) get(key luaValue) luaValue {
 	key = _normalizeKey(key)
-	if idx, ok := key.(int64); ok {
-		if 1 <= idx && idx <= int64(len(table.a)) {
-			return table.a[idx-1]
-		}
+	if idx := key.integer(); key.tag == tagInteger && 1 <= idx && idx <= int64(len(table.arr)) {
+		return table.arr[idx-1]
 	}
-	return table.m[key]
-}
-
-func (table *luaTable) len() int {
-	return len(table.a)
+	if pos, found := table.index[key]; found {
+		return table.nodes[pos].val
+	}
+	return nilValue
 }
 
 func (table *luaTable) put(key, val luaValue) {
-	if key == nil {
+	if key.isNil() {
 		panic("table index is nil")
 	}
 
-	if f, ok := key.(float64); ok && math.IsNaN(f) {
+	if key.tag == tagFloat && math.IsNaN(key.float()) {
 		panic("table index is NaN")
 	}
 
-	table.changed = true
-
 	key = _normalizeKey(key)
-	if idx, ok := key.(int64); ok && idx >= 1 {
-		nArr := int64(len(table.a))
+	if idx := key.integer(); key.tag == tagInteger && idx >= 1 {
+		nArr := int64(len(table.arr))
 		if idx <= nArr {
-			table.a[idx-1] = val
-			if idx == nArr && val == nil {
-				table._shrinkArr()
-			}
+			table.arr[idx-1] = val
 			return
 		}
-		if idx == nArr+1 {
-			delete(table.m, key)
-			if val != nil {
-				table.a = append(table.a, val)
-				table._expandArr()
+		if _, found := table.index[key]; !found && idx == nArr+1 && !val.isNil() {
+			if len(table.arr) == cap(table.arr) && table._isSparse() {
+				table._rehash(key) // shrink it rather than grow it further
+				table.put(key, val)
+				return
 			}
+			table.arr = append(table.arr, val)
+			table._migrateFromHash()
 			return
 		}
 	}
 
-	if val != nil {
-		if table.m == nil {
-			table.m = make(map[luaValue]luaValue, 8)
+	if pos, found := table.index[key]; found {
+		table.nodes[pos].val = val // a nil value keeps the entry for `next`
+		return
+	}
+	if val.isNil() {
+		return
+	}
+	if len(table.nodes) == cap(table.nodes) {
+		table._rehash(key)
+		table.put(key, val) // there is room now, in one part or the other
+		return
+	}
+	if table.index == nil {
+		table.index = make(map[luaValue]int)
+	}
+	table.index[key] = len(table.nodes)
+	table.nodes = append(table.nodes, node{key, val})
+}
+
+/**
+ * Returns a border of the table: an index n such that t[n] is not nil and
+ * t[n+1] is nil, or 0 if t[1] is nil. A table with holes may have several
+ * borders, any of them is returned.
+ */
+func (table *luaTable) len() int {
+	j := len(table.arr)
+	if j > 0 && table.arr[j-1].isNil() {
+		// binary search for a border in the array part
+		i := 0
+		for j-i > 1 {
+			m := (i + j) / 2
+			if table.arr[m-1].isNil() {
+				j = m
+			} else {
+				i = m
+			}
 		}
-		table.m[key] = val
-	} else {
-		delete(table.m, key)
+		return i
 	}
+	if len(table.nodes) == 0 {
+		return j
+	}
+	return int(table._unboundSearch(int64(j)))
 }
 
 func (table *luaTable) hasMetafield(name string) bool {
-	return table.metatable != nil && table.metatable.get(name) != nil
+	return table.metatable != nil && !table.metatable.get(stringValue(name)).isNil()
 }
 
-func (table *luaTable) nextKey(key luaValue) luaValue {
-	if table.keys == nil || (key == nil && table.changed) {
-		table.keys = make(map[luaValue]luaValue)
-		var lastKey luaValue
-
-		for i, v := range table.a {
-			if v != nil {
-				table.keys[lastKey] = int64(i + 1)
-				lastKey = int64(i + 1)
-			}
+/**
+ * Returns the entry following key, the array part first and then the hash
+ * part in insertion order, or a nil key after the last entry. ok is false if
+ * key is not in the table. Fields cleared since the traversal began are
+ * still found, so they can be passed back to next.
+ */
+func (table *luaTable) next(key luaValue) (nextKey, nextVal luaValue, ok bool) {
+	i := 0 // position in the array part followed by the hash part
+	if !key.isNil() {
+		key = _normalizeKey(key)
+		if idx := key.integer(); key.tag == tagInteger && 1 <= idx && idx <= int64(len(table.arr)) {
+			i = int(idx)
+		} else if pos, found := table.index[key]; found {
+			i = len(table.arr) + pos + 1
+		} else {
+			return nilValue, nilValue, false
 		}
+	}
 
-		for k, v := range table.m {
-			if v != nil {
-				table.keys[lastKey] = k
-				lastKey = k
-			}
+	for ; i < len(table.arr); i++ {
+		if v := table.arr[i]; !v.isNil() {
+			return intValue(int64(i + 1)), v, true
 		}
-
-		table.lastKey = lastKey
-		table.changed = false
 	}
-
-	nextKey := table.keys[key]
-	if nextKey == nil && key != nil && key != table.lastKey {
-		panic("invalid key to `next`")
+	for i -= len(table.arr); i < len(table.nodes); i++ {
+		if n := table.nodes[i]; !n.val.isNil() {
+			return n.key, n.val, true
+		}
 	}
-
-	return nextKey
+	return nilValue, nilValue, true
 }
 
 func _normalizeKey(key luaValue) luaValue {
-	if f, ok := key.(float64); ok {
-		if i, ok := number.FloatToInteger(f); ok {
-			return i
+	if key.tag == tagFloat {
+		if i, ok := number.FloatToInteger(key.float()); ok {
+			return intValue(i)
 		}
 	}
 	return key
 }
 
-func (table *luaTable) _shrinkArr() {
-	nArr := len(table.a)
-	for nArr > 0 {
-		if table.a[nArr-1] != nil {
+/**
+ * Tells whether at most half of the array part is in use, as when a queue
+ * appends at one end and clears the other: growing it again would keep the
+ * cleared slots forever, while a rehash drops them.
+ */
+func (table *luaTable) _isSparse() bool {
+	n := 0
+	for _, v := range table.arr {
+		if !v.isNil() {
+			n++
+		}
+	}
+	return 2*n < len(table.arr)
+}
+
+// moves the keys following the array part from the hash part, after an append
+func (table *luaTable) _migrateFromHash() {
+	for len(table.nodes) > 0 {
+		pos, found := table.index[intValue(int64(len(table.arr))+1)]
+		if !found || table.nodes[pos].val.isNil() {
 			break
 		}
+		table.arr = append(table.arr, table.nodes[pos].val)
+		table.nodes[pos].val = nilValue // dead, the array part has it now
 	}
-	table.a = table.a[:nArr]
 }
 
-func (table *luaTable) _expandArr() {
-	for idx := int64(len(table.a)) + 1; true; idx++ {
-		if val, found := table.m[idx]; found {
-			delete(table.m, idx)
-			table.a = append(table.a, val)
+// looks for a border past the array part, which ends at j, as luaH_getn
+func (table *luaTable) _unboundSearch(j int64) int64 {
+	i := j
+	j++
+	for !table.get(intValue(j)).isNil() {
+		i = j
+		if j > math.MaxInt64/2 { // a table built to overflow: search linearly
+			i = 1
+			for !table.get(intValue(i)).isNil() {
+				i++
+			}
+			return i - 1
+		}
+		j *= 2
+	}
+	for j-i > 1 {
+		m := (i + j) / 2
+		if table.get(intValue(m)).isNil() {
+			j = m
 		} else {
-			break
+			i = m
+		}
+	}
+	return i
+}
+
+/**
+ * Rebuilds the table when the hash part is full and extra is about to be
+ * added. The array part gets the largest size n such that more than half
+ * of the slots 1..n would be in use, counting extra; the other entries go
+ * to a new hash part, without the dead ones.
+ */
+func (table *luaTable) _rehash(extra luaValue) {
+	var nums [maxABits + 1]int // nums[i]: number of keys k with 2^(i-1) < k <= 2^i
+	nInt := 0
+	for i, v := range table.arr {
+		if !v.isNil() {
+			nums[ceilLog2(int64(i+1))]++
+			nInt++
+		}
+	}
+	nHash := 1 // extra
+	for _, n := range table.nodes {
+		if !n.val.isNil() {
+			nHash++
+			if countInt(n.key, &nums) {
+				nInt++
+			}
 		}
 	}
+	if countInt(extra, &nums) {
+		nInt++
+	}
+	size := computeSizes(&nums, nInt)
+
+	oldArr, oldNodes := table.arr, table.nodes
+	for i := size; i < len(oldArr); i++ { // leaving the array part
+		if !oldArr[i].isNil() {
+			nHash++
+		}
+	}
+	arr := make([]luaValue, size)
+	copy(arr, oldArr)
+	table.nodes = make([]node, 0, ceilPow2(nHash))
+	table.index = make(map[luaValue]int, cap(table.nodes))
+	for i := size; i < len(oldArr); i++ {
+		if v := oldArr[i]; !v.isNil() {
+			table._rehashEntry(arr, intValue(int64(i+1)), v)
+		}
+	}
+	for _, n := range oldNodes {
+		if !n.val.isNil() {
+			table._rehashEntry(arr, n.key, n.val)
+		}
+	}
+
+	n := len(arr)
+	for n > 0 && arr[n-1].isNil() {
+		n--
+	}
+	table.arr = arr[:n]
+}
+
+func (table *luaTable) _rehashEntry(arr []luaValue, key, val luaValue) {
+	if idx := key.integer(); key.tag == tagInteger && 1 <= idx && idx <= int64(len(arr)) {
+		arr[idx-1] = val
+	} else {
+		table.index[key] = len(table.nodes)
+		table.nodes = append(table.nodes, node{key, val})
+	}
+}
+
+// counts key in nums if it is an integer candidate for the array part
+func countInt(key luaValue, nums *[maxABits + 1]int) bool {
+	if idx := key.integer(); key.tag == tagInteger && 1 <= idx && idx <= 1<<maxABits {
+		nums[ceilLog2(idx)]++
+		return true
+	}
+	return false
+}
+
+/**
+ * Returns the optimal size of the array part, as computesizes: the largest
+ * power of 2, n, such that more than n/2 of the keys 1..n are present.
+ * nInt is the number of integer keys counted in nums.
+ */
+func computeSizes(nums *[maxABits + 1]int, nInt int) int {
+	a, optimal := 0, 0
+	for i, twoToI := 0, 1; i <= maxABits && nInt > twoToI/2; i, twoToI = i+1, twoToI*2 {
+		if nums[i] > 0 {
+			a += nums[i]
+			if a > twoToI/2 {
+				optimal = twoToI
+			}
+		}
+	}
+	return optimal
+}
+
+func ceilLog2(x int64) int {
+	return bits.Len64(uint64(x - 1))
+}
+
+func ceilPow2(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
 }
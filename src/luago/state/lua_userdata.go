@@ -0,0 +1,17 @@
+package state
+
+// a full userdata: a Go value with its own metatable and user value
+type userdata struct {
+	metatable *luaTable
+	data      interface{}
+	uservalue luaValue
+}
+
+/**
+ * A light userdata is a bare Go value without metatable of its own. Light
+ * userdata are equal when their values are, so the value must be
+ * comparable, typically a pointer.
+ */
+type lightUserdata struct {
+	data interface{}
+}
@@ -4,40 +4,196 @@ import (
 	"fmt"
 	"luago/api"
 	"luago/number"
+	"math"
 )
 
-type luaValue interface{}
+/**
+ * A Lua value. Numbers and booleans are held in n, so that they are never
+ * boxed on the heap; strings and the reference types are held in ref. The
+ * zero value is nil. Values are comparable, and two of them are == when
+ * they are the same raw Lua value, as long as float keys are normalized
+ * (see _normalizeKey) and NaN is kept out.
+ */
+type luaValue struct {
+	tag valueTag
+	n   uint64      // integer, bits of a float, or 1 for true
+	ref interface{} // string, *luaTable, *luaClosure, *luaState, *userdata or lightUserdata
+}
 
-func typeOf(v luaValue) api.LuaType {
-	switch v.(type) {
+type valueTag uint8
+
+const (
+	tagNil valueTag = iota
+	tagBoolean
+	tagInteger
+	tagFloat
+	tagString
+	tagTable
+	tagFunction
+	tagThread
+	tagUserdata
+	tagLightUserdata
+)
+
+var tagTypes = [...]api.LuaType{
+	tagNil:           api.LUA_TNIL,
+	tagBoolean:       api.LUA_TBOOLEAN,
+	tagInteger:       api.LUA_TNUMBER,
+	tagFloat:         api.LUA_TNUMBER,
+	tagString:        api.LUA_TSTRING,
+	tagTable:         api.LUA_TTABLE,
+	tagFunction:      api.LUA_TFUNCTION,
+	tagThread:        api.LUA_TTHREAD,
+	tagUserdata:      api.LUA_TUSERDATA,
+	tagLightUserdata: api.LUA_TLIGHTUSERDATA,
+}
+
+var nilValue luaValue
+
+func boolValue(b bool) luaValue {
+	if b {
+		return luaValue{tag: tagBoolean, n: 1}
+	}
+	return luaValue{tag: tagBoolean}
+}
+
+func intValue(i int64) luaValue {
+	return luaValue{tag: tagInteger, n: uint64(i)}
+}
+
+func floatValue(f float64) luaValue {
+	return luaValue{tag: tagFloat, n: math.Float64bits(f)}
+}
+
+func stringValue(s string) luaValue {
+	return luaValue{tag: tagString, ref: s}
+}
+
+func tableValue(t *luaTable) luaValue {
+	return luaValue{tag: tagTable, ref: t}
+}
+
+func closureValue(c *luaClosure) luaValue {
+	return luaValue{tag: tagFunction, ref: c}
+}
+
+func threadValue(t *luaThread) luaValue {
+	return luaValue{tag: tagThread, ref: t}
+}
+
+func userdataValue(u *userdata) luaValue {
+	return luaValue{tag: tagUserdata, ref: u}
+}
+
+func lightUserdataValue(data interface{}) luaValue {
+	return luaValue{tag: tagLightUserdata, ref: lightUserdata{data}}
+}
+
+/**
+ * Converts a Go value of one of the types the VM used to hold, as found in
+ * the constants of a prototype or in the Value of an api.LuaError, back to a
+ * Lua value. This is the inverse of goValue.
+ */
+func valueOf(x interface{}) luaValue {
+	switch x := x.(type) {
 	case nil:
-		return api.LUA_TNIL
+		return nilValue
 	case bool:
-		return api.LUA_TBOOLEAN
-	case int64, float64:
-		return api.LUA_TNUMBER
+		return boolValue(x)
+	case int64:
+		return intValue(x)
+	case float64:
+		return floatValue(x)
 	case string:
-		return api.LUA_TSTRING
+		return stringValue(x)
 	case *luaTable:
-		return api.LUA_TTABLE
+		return tableValue(x)
 	case *luaClosure:
-		return api.LUA_TFUNCTION
+		return closureValue(x)
+	case *luaThread:
+		return threadValue(x)
+	case *userdata:
+		return userdataValue(x)
+	case lightUserdata:
+		return luaValue{tag: tagLightUserdata, ref: x}
 	default:
-		panic(v)
+		panic(x)
 	}
 }
 
+// the value as a Go value, for the api boundary where values are interface{}
+func (v luaValue) goValue() interface{} {
+	switch v.tag {
+	case tagNil:
+		return nil
+	case tagBoolean:
+		return v.n != 0
+	case tagInteger:
+		return v.integer()
+	case tagFloat:
+		return v.float()
+	default:
+		return v.ref
+	}
+}
+
+func (v luaValue) isNil() bool {
+	return v.tag == tagNil
+}
+
+// the integer of a value tagged tagInteger
+func (v luaValue) integer() int64 {
+	return int64(v.n)
+}
+
+// the float of a value tagged tagFloat
+func (v luaValue) float() float64 {
+	return math.Float64frombits(v.n)
+}
+
+func (v luaValue) str() (string, bool) {
+	s, ok := v.ref.(string)
+	return s, ok
+}
+
+// the following return nil for values of other types
+
+func (v luaValue) table() *luaTable {
+	t, _ := v.ref.(*luaTable)
+	return t
+}
+
+func (v luaValue) closure() *luaClosure {
+	c, _ := v.ref.(*luaClosure)
+	return c
+}
+
+func (v luaValue) thread() *luaThread {
+	t, _ := v.ref.(*luaThread)
+	return t
+}
+
+func (v luaValue) userdata() *userdata {
+	u, _ := v.ref.(*userdata)
+	return u
+}
+
+func typeOf(v luaValue) api.LuaType {
+	return tagTypes[v.tag]
+}
+
 func convertToInteger(val luaValue) (int64, bool) {
-	switch x := val.(type) {
-	case int64:
-		return x, true
-	case float64:
-		return int64(x), true
-	case string:
-		if i, ok := number.ParseInteger(x); ok {
+	switch val.tag {
+	case tagInteger:
+		return val.integer(), true
+	case tagFloat:
+		return number.FloatToInteger(val.float())
+	case tagString:
+		s, _ := val.str()
+		if i, ok := number.ParseInteger(s); ok {
 			return i, ok
 		}
-		if f, ok := number.ParseFloat(x); ok {
+		if f, ok := number.ParseFloat(s); ok {
 			return number.FloatToInteger(f)
 		}
 	}
@@ -45,59 +201,166 @@ func convertToInteger(val luaValue) (int64, bool) {
 }
 
 func convertToFloat(val luaValue) (float64, bool) {
-	switch x := val.(type) {
-	case float64:
-		return x, true
-	case int64:
-		return float64(x), true
-	case string:
-		return number.ParseFloat(x)
+	switch val.tag {
+	case tagFloat:
+		return val.float(), true
+	case tagInteger:
+		return float64(val.integer()), true
+	case tagString:
+		s, _ := val.str()
+		if i, ok := number.ParseInteger(s); ok {
+			return float64(i), true
+		}
+		return number.ParseFloat(s)
 	default:
 		return 0.0, false
 	}
 }
 
+// converts a numeric string to an integer or a float, other values are kept
+func stringToNumber(val luaValue) luaValue {
+	if s, ok := val.str(); ok {
+		if i, ok := number.ParseInteger(s); ok {
+			return intValue(i)
+		}
+		if f, ok := number.ParseFloat(s); ok {
+			return floatValue(f)
+		}
+	}
+	return val
+}
+
+/**
+ * a op b when both operands are numbers, which is what the VM sees almost
+ * every time. Strings, integer division by zero and floats in bitwise
+ * operations are left to the general path, which coerces or reports them.
+ */
+func numberArith(a, b luaValue, op api.ArithOp) (luaValue, bool) {
+	switch a.tag {
+	case tagInteger:
+		switch b.tag {
+		case tagInteger:
+			return integerArith(a.integer(), b.integer(), op)
+		case tagFloat:
+			return floatArith(float64(a.integer()), b.float(), op)
+		}
+	case tagFloat:
+		switch b.tag {
+		case tagFloat:
+			return floatArith(a.float(), b.float(), op)
+		case tagInteger:
+			return floatArith(a.float(), float64(b.integer()), op)
+		}
+	}
+	return nilValue, false
+}
+
+func integerArith(a, b int64, op api.ArithOp) (luaValue, bool) {
+	switch op {
+	case api.LUA_OPADD:
+		return intValue(a + b), true
+	case api.LUA_OPSUB:
+		return intValue(a - b), true
+	case api.LUA_OPMUL:
+		return intValue(a * b), true
+	case api.LUA_OPMOD:
+		if b != 0 {
+			return intValue(number.IMod(a, b)), true
+		}
+	case api.LUA_OPIDIV:
+		if b != 0 {
+			return intValue(number.IFloorDiv(a, b)), true
+		}
+	case api.LUA_OPBAND:
+		return intValue(a & b), true
+	case api.LUA_OPBOR:
+		return intValue(a | b), true
+	case api.LUA_OPBXOR:
+		return intValue(a ^ b), true
+	case api.LUA_OPSHL:
+		return intValue(number.ShiftLeft(a, b)), true
+	case api.LUA_OPSHR:
+		return intValue(number.ShiftRight(a, b)), true
+	case api.LUA_OPUNM:
+		return intValue(-a), true
+	case api.LUA_OPBNOT:
+		return intValue(^a), true
+	default: // '/' and '^' always work on floats
+		return floatArith(float64(a), float64(b), op)
+	}
+	return nilValue, false
+}
+
+func floatArith(a, b float64, op api.ArithOp) (luaValue, bool) {
+	switch op {
+	case api.LUA_OPADD:
+		return floatValue(a + b), true
+	case api.LUA_OPSUB:
+		return floatValue(a - b), true
+	case api.LUA_OPMUL:
+		return floatValue(a * b), true
+	case api.LUA_OPMOD:
+		return floatValue(number.FMod(a, b)), true
+	case api.LUA_OPPOW:
+		return floatValue(math.Pow(a, b)), true
+	case api.LUA_OPDIV:
+		return floatValue(a / b), true
+	case api.LUA_OPIDIV:
+		return floatValue(number.FFloorDiv(a, b)), true
+	case api.LUA_OPUNM:
+		return floatValue(-a), true
+	}
+	return nilValue, false
+}
+
 func convertToBoolean(val luaValue) bool {
-	switch x := val.(type) {
-	case nil:
+	switch val.tag {
+	case tagNil:
 		return false
-	case bool:
-		return x
+	case tagBoolean:
+		return val.n != 0
 	default:
 		return true
 	}
 }
 
 func getMetatable(val luaValue, state *luaState) *luaTable {
-	if t, ok := val.(*luaTable); ok {
-		return t.metatable
-	}
-	if mt := state.registry.get(fmt.Sprintf("_MT%d", typeOf(val))); mt != nil {
-		return mt.(*luaTable)
+	switch val.tag {
+	case tagTable:
+		return val.table().metatable
+	case tagUserdata:
+		return val.userdata().metatable
 	}
-	return nil
+	return state.registry.get(stringValue(fmt.Sprintf("_MT%d", typeOf(val)))).table()
 }
 
 func setMetatable(val luaValue, mt *luaTable, state *luaState) {
-	if t, ok := val.(*luaTable); ok {
-		t.metatable = mt
-	} else {
-		state.registry.put(fmt.Sprintf("_MT%d", typeOf(val)), mt)
+	switch val.tag {
+	case tagTable:
+		val.table().metatable = mt
+	case tagUserdata:
+		val.userdata().metatable = mt
+	default:
+		mtVal := nilValue
+		if mt != nil {
+			mtVal = tableValue(mt)
+		}
+		state.registry.put(stringValue(fmt.Sprintf("_MT%d", typeOf(val))), mtVal)
 	}
 }
 
 func getMetafield(val luaValue, name string, state *luaState) luaValue {
 	if mt := getMetatable(val, state); mt != nil {
-		return mt.get(name)
+		return mt.get(stringValue(name))
 	}
-	return nil
+	return nilValue
 }
 
 func callMetamethod(a, b luaValue, mName string, state *luaState) (luaValue, bool) {
 	var mm luaValue
-	if mm = getMetafield(a, mName, state); mm == nil {
-		if mm = getMetafield(b, mName, state); mm == nil {
-			return nil, false
+	if mm = getMetafield(a, mName, state); mm.isNil() {
+		if mm = getMetafield(b, mName, state); mm.isNil() {
+			return nilValue, false
 		}
 	}
 
@@ -110,35 +373,27 @@ func callMetamethod(a, b luaValue, mName string, state *luaState) (luaValue, boo
 }
 
 func equal(a, b luaValue, state *luaState) bool {
-	switch a := a.(type) {
-	case nil:
-		return b == nil
-	case bool:
-		b, ok := b.(bool)
-		return ok && a == b
-	case string:
-		b, ok := b.(string)
-		return ok && a == b
-	case int64:
-		switch b := b.(type) {
-		case int64:
-			return a == b
-		case float64:
-			return float64(a) == b
+	switch a.tag {
+	case tagInteger:
+		switch b.tag {
+		case tagInteger:
+			return a.n == b.n
+		case tagFloat:
+			return eqIntFloat(a.integer(), b.float())
 		default:
 			return false
 		}
-	case float64:
-		switch b := b.(type) {
-		case float64:
-			return a == b
-		case int64:
-			return a == float64(b)
+	case tagFloat:
+		switch b.tag {
+		case tagFloat:
+			return a.float() == b.float()
+		case tagInteger:
+			return eqIntFloat(b.integer(), a.float())
 		default:
 			return false
 		}
-	case *luaTable:
-		if b, ok := b.(*luaTable); ok && a != b && state != nil {
+	case tagTable, tagUserdata:
+		if a.tag == b.tag && a.ref != b.ref && state != nil {
 			if r, ok := callMetamethod(a, b, "__eq", state); ok {
 				return convertToBoolean(r)
 			}
@@ -146,3 +401,70 @@ func equal(a, b luaValue, state *luaState) bool {
 	}
 	return a == b
 }
+
+// integers in [-2^53, 2^53] convert to float64 without rounding
+const maxExactInt = 1 << 53
+
+func _fitsFloat(i int64) bool {
+	return -maxExactInt <= i && i <= maxExactInt
+}
+
+// rounds f to an integer with round (math.Floor or math.Ceil), if in range
+func _roundToInteger(f float64, round func(float64) float64) (int64, bool) {
+	f = round(f)
+	if f >= -(1<<63) && f < (1<<63) {
+		return int64(f), true
+	}
+	return 0, false
+}
+
+/**
+ * Comparisons between an integer and a float, done exactly as in Lua 5.3:
+ * an integer that a float64 cannot represent is not rounded, the float is
+ * converted to an integer instead when it is in range. Any comparison with
+ * NaN is false.
+ */
+func eqIntFloat(i int64, f float64) bool {
+	fi, ok := number.FloatToInteger(f)
+	return ok && i == fi
+}
+
+func ltIntFloat(i int64, f float64) bool {
+	if _fitsFloat(i) {
+		return float64(i) < f
+	}
+	if fi, ok := _roundToInteger(f, math.Ceil); ok {
+		return i < fi // i < f <=> i < ceil(f)
+	}
+	return f > 0
+}
+
+func leIntFloat(i int64, f float64) bool {
+	if _fitsFloat(i) {
+		return float64(i) <= f
+	}
+	if fi, ok := _roundToInteger(f, math.Floor); ok {
+		return i <= fi // i <= f <=> i <= floor(f)
+	}
+	return f > 0
+}
+
+func ltFloatInt(f float64, i int64) bool {
+	if _fitsFloat(i) {
+		return f < float64(i)
+	}
+	if fi, ok := _roundToInteger(f, math.Floor); ok {
+		return fi < i // f < i <=> floor(f) < i
+	}
+	return f < 0
+}
+
+func leFloatInt(f float64, i int64) bool {
+	if _fitsFloat(i) {
+		return f <= float64(i)
+	}
+	if fi, ok := _roundToInteger(f, math.Ceil); ok {
+		return fi <= i // f <= i <=> ceil(f) <= i
+	}
+	return f < 0
+}
@@ -0,0 +1,433 @@
+package stdlib
+
+import (
+	"luago/api"
+	"os"
+	"runtime"
+	"strings"
+)
+
+var baseFuncs = api.FuncReg{
+	"assert":         baseAssert,
+	"collectgarbage": baseCollectGarbage,
+	"dofile":         baseDoFile,
+	"error":          baseError,
+	"getmetatable":   baseGetMetatable,
+	"ipairs":         baseIPairs,
+	"loadfile":       baseLoadFile,
+	"load":           baseLoad,
+	"next":           baseNext,
+	"pairs":          basePairs,
+	"pcall":          basePCall,
+	"print":          basePrint,
+	"rawequal":       baseRawEqual,
+	"rawlen":         baseRawLen,
+	"rawget":         baseRawGet,
+	"rawset":         baseRawSet,
+	"select":         baseSelect,
+	"setmetatable":   baseSetMetatable,
+	"tonumber":       baseToNumber,
+	"tostring":       baseToString,
+	"type":           baseType,
+	"xpcall":         baseXPCall,
+}
+
+// installs the base functions, `_G` and `_VERSION` in the global table
+func OpenBase(ls api.LuaState) {
+	ls.PushGlobalTable()
+	ls.SetFuncs(baseFuncs, 0)
+	ls.PushValue(-1)
+	ls.SetField(-2, "_G") // set global _G
+	ls.PushString("Lua 5.3")
+	ls.SetField(-2, "_VERSION") // set global _VERSION
+	ls.Pop(1)
+}
+
+// print (···)
+// Each argument is converted by the global `tostring`, so overriding it
+// changes what print writes.
+func basePrint(ls api.LuaState) int {
+	n := ls.GetTop()
+	ls.GetGlobal("tostring")
+	for i := 1; i <= n; i++ {
+		ls.PushValue(-1) // function to be called
+		ls.PushValue(i)  // value to print
+		ls.Call(1, 1)
+		s, ok := ls.ToStringX(-1) // get result
+		if !ok {
+			return ls.Error2("'tostring' must return a string to 'print'")
+		}
+		ls.Pop(1) // pop result
+		if i > 1 {
+			os.Stdout.WriteString("\t")
+		}
+		os.Stdout.WriteString(s)
+	}
+	os.Stdout.WriteString("\n")
+	return 0
+}
+
+// tonumber (e [, base])
+func baseToNumber(ls api.LuaState) int {
+	if ls.IsNoneOrNil(2) { // standard conversion?
+		if ls.Type(1) == api.LUA_TNUMBER { // already a number?
+			ls.SetTop(1)
+			return 1
+		}
+		if s, ok := ls.ToStringX(1); ok && ls.StringToNumber(s) {
+			return 1 // successful conversion to number
+		}
+		ls.CheckAny(1) // (but there must be some parameter)
+	} else {
+		base := ls.CheckInteger(2)
+		ls.CheckType(1, api.LUA_TSTRING) // no numbers as strings
+		s := ls.ToString(1)
+		ls.ArgCheck(2 <= base && base <= 36, 2, "base out of range")
+		if n, ok := stringToInteger(s, int(base)); ok {
+			ls.PushInteger(n)
+			return 1
+		}
+	}
+	ls.PushNil() // not a number
+	return 1
+}
+
+// converts a numeral in the given base, wrapping around on overflow
+func stringToInteger(s string, base int) (int64, bool) {
+	s = strings.TrimSpace(s)
+	neg := false
+	if strings.HasPrefix(s, "-") {
+		s, neg = s[1:], true
+	} else if strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+	if s == "" {
+		return 0, false // no digit
+	}
+	var n uint64
+	for i := 0; i < len(s); i++ {
+		var digit int
+		switch c := s[i]; {
+		case '0' <= c && c <= '9':
+			digit = int(c - '0')
+		case 'a' <= c && c <= 'z':
+			digit = int(c-'a') + 10
+		case 'A' <= c && c <= 'Z':
+			digit = int(c-'A') + 10
+		default:
+			return 0, false
+		}
+		if digit >= base {
+			return 0, false // invalid numeral
+		}
+		n = n*uint64(base) + uint64(digit)
+	}
+	if neg {
+		n = -n
+	}
+	return int64(n), true
+}
+
+// error (message [, level])
+func baseError(ls api.LuaState) int {
+	level := int(ls.OptInteger(2, 1))
+	ls.SetTop(1)
+	if ls.Type(1) == api.LUA_TSTRING && level > 0 {
+		ls.Where(level) // add extra information
+		ls.PushValue(1)
+		ls.Concat(2)
+	}
+	return ls.Error()
+}
+
+// getmetatable (object)
+func baseGetMetatable(ls api.LuaState) int {
+	ls.CheckAny(1)
+	if !ls.GetMetatable(1) {
+		ls.PushNil()
+		return 1 // no metatable
+	}
+	ls.GetMetafield(1, "__metatable")
+	return 1 // returns either __metatable field (if present) or metatable
+}
+
+// setmetatable (table, metatable)
+func baseSetMetatable(ls api.LuaState) int {
+	t := ls.Type(2)
+	ls.CheckType(1, api.LUA_TTABLE)
+	ls.ArgCheck(t == api.LUA_TNIL || t == api.LUA_TTABLE, 2, "nil or table expected")
+	if ls.GetMetafield(1, "__metatable") != api.LUA_TNIL {
+		return ls.Error2("cannot change a protected metatable")
+	}
+	ls.SetTop(2)
+	ls.SetMetatable(1)
+	return 1
+}
+
+// rawequal (v1, v2)
+func baseRawEqual(ls api.LuaState) int {
+	ls.CheckAny(1)
+	ls.CheckAny(2)
+	ls.PushBoolean(ls.RawEqual(1, 2))
+	return 1
+}
+
+// rawlen (v)
+func baseRawLen(ls api.LuaState) int {
+	t := ls.Type(1)
+	ls.ArgCheck(t == api.LUA_TTABLE || t == api.LUA_TSTRING, 1,
+		"table or string expected")
+	ls.PushInteger(int64(ls.RawLen(1)))
+	return 1
+}
+
+// rawget (table, index)
+func baseRawGet(ls api.LuaState) int {
+	ls.CheckType(1, api.LUA_TTABLE)
+	ls.CheckAny(2)
+	ls.SetTop(2)
+	ls.RawGet(1)
+	return 1
+}
+
+// rawset (table, index, value)
+func baseRawSet(ls api.LuaState) int {
+	ls.CheckType(1, api.LUA_TTABLE)
+	ls.CheckAny(2)
+	ls.CheckAny(3)
+	ls.SetTop(3)
+	ls.RawSet(1)
+	return 1
+}
+
+// collectgarbage ([opt [, arg]])
+func baseCollectGarbage(ls api.LuaState) int {
+	opts := []string{"stop", "restart", "collect", "count", "step",
+		"setpause", "setstepmul", "isrunning"}
+	switch opts[ls.CheckOption(1, "collect", opts)] {
+	case "count":
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		ls.PushNumber(float64(stats.HeapAlloc) / 1024)
+	case "step":
+		runtime.GC()
+		ls.PushBoolean(true) // the Go collector always completes a cycle
+	case "isrunning":
+		ls.PushBoolean(true) // the Go collector cannot be stopped
+	case "collect":
+		runtime.GC()
+		ls.PushInteger(0)
+	default: // the Go collector has no per-state parameters
+		ls.PushInteger(0)
+	}
+	return 1
+}
+
+// type (v)
+func baseType(ls api.LuaState) int {
+	ls.CheckAny(1)
+	ls.PushString(ls.TypeName2(1))
+	return 1
+}
+
+// next (table [, index])
+func baseNext(ls api.LuaState) int {
+	ls.CheckType(1, api.LUA_TTABLE)
+	ls.SetTop(2) // create a 2nd argument if there isn't one
+	if ls.Next(1) {
+		return 2
+	}
+	ls.PushNil()
+	return 1
+}
+
+// pairs (t)
+func basePairs(ls api.LuaState) int {
+	ls.CheckAny(1)
+	if ls.GetMetafield(1, "__pairs") == api.LUA_TNIL { // no metamethod?
+		ls.PushGoFunction(baseNext) // will return generator,
+		ls.PushValue(1)             // state,
+		ls.PushNil()                // and initial value
+	} else {
+		ls.PushValue(1) // argument 'self' to metamethod
+		ls.Call(1, 3)   // get 3 values from metamethod
+	}
+	return 3
+}
+
+// ipairs (t)
+func baseIPairs(ls api.LuaState) int {
+	ls.CheckAny(1)
+	ls.PushGoFunction(ipairsAux) // iteration function
+	ls.PushValue(1)              // state
+	ls.PushInteger(0)            // initial value
+	return 3
+}
+
+// traversal function for 'ipairs'
+func ipairsAux(ls api.LuaState) int {
+	i := ls.CheckInteger(2) + 1
+	ls.PushInteger(i)
+	if ls.GetI(1, i) == api.LUA_TNIL {
+		return 1
+	}
+	return 2
+}
+
+// load (chunk [, chunkname [, mode [, env]]])
+func baseLoad(ls api.LuaState) int {
+	var status int
+	mode := ls.OptString(3, "bt")
+	env := 0 // 'env' index or 0 if no 'env'
+	if !ls.IsNone(4) {
+		env = 4
+	}
+	if s, ok := ls.ToStringX(1); ok { // loading a string?
+		chunkName := ls.OptString(2, s)
+		status = ls.Load([]byte(s), chunkName, mode)
+	} else { // loading from a reader function
+		chunkName := ls.OptString(2, "=(load)")
+		ls.CheckType(1, api.LUA_TFUNCTION)
+		if chunk, ok := readChunk(ls); ok {
+			status = ls.Load(chunk, chunkName, mode)
+		} else {
+			status = api.LUA_ERRRUN
+		}
+	}
+	return loadAux(ls, status, env)
+}
+
+/**
+ * Calls the reader function at index 1 until it returns nil or an empty
+ * string. If it fails, returns false with the error message pushed.
+ */
+func readChunk(ls api.LuaState) ([]byte, bool) {
+	var buf []byte
+	for {
+		ls.CheckStack2(2, "too many nested functions")
+		ls.PushValue(1)                      // get function
+		if ls.PCall(0, 1, 0) != api.LUA_OK { // call it
+			return nil, false
+		}
+		if ls.IsNil(-1) {
+			ls.Pop(1) // pop result
+			return buf, true
+		} else if !ls.IsString(-1) {
+			ls.Pop(1)
+			ls.PushString("reader function must return a string")
+			return nil, false
+		}
+		s := ls.ToString(-1)
+		ls.Pop(1)
+		if s == "" {
+			return buf, true
+		}
+		buf = append(buf, s...)
+	}
+}
+
+/**
+ * Returns the function pushed by a successful load, with its first upvalue
+ * set to the value at index env if env is not 0. Otherwise returns nil and
+ * the error message.
+ */
+func loadAux(ls api.LuaState, status, env int) int {
+	if status != api.LUA_OK {
+		ls.PushNil()
+		ls.Insert(-2) // put before error message
+		return 2      // return nil plus error message
+	}
+	if env != 0 { // 'env' parameter?
+		ls.PushValue(env)                       // environment for loaded function
+		if _, ok := ls.SetUpvalue(-2, 1); !ok { // set it as 1st upvalue
+			ls.Pop(1) // remove 'env' if not used by previous call
+		}
+	}
+	return 1
+}
+
+// loadfile ([filename [, mode [, env]]])
+func baseLoadFile(ls api.LuaState) int {
+	fname := ls.OptString(1, "")
+	mode := ls.OptString(2, "bt")
+	env := 0 // 'env' index or 0 if no 'env'
+	if !ls.IsNone(3) {
+		env = 3
+	}
+	return loadAux(ls, ls.LoadFileX(fname, mode), env)
+}
+
+// dofile ([filename])
+func baseDoFile(ls api.LuaState) int {
+	fname := ls.OptString(1, "")
+	ls.SetTop(1)
+	if ls.LoadFile(fname) != api.LUA_OK {
+		return ls.Error()
+	}
+	ls.Call(0, api.LUA_MULTRET)
+	return ls.GetTop() - 1
+}
+
+// select (n, ···)
+func baseSelect(ls api.LuaState) int {
+	n := int64(ls.GetTop())
+	if ls.Type(1) == api.LUA_TSTRING && ls.ToString(1) == "#" {
+		ls.PushInteger(n - 1)
+		return 1
+	}
+	i := ls.CheckInteger(1)
+	if i < 0 {
+		i = n + i
+	} else if i > n {
+		i = n
+	}
+	ls.ArgCheck(1 <= i, 1, "index out of range")
+	return int(n - i)
+}
+
+// assert (v [, message])
+func baseAssert(ls api.LuaState) int {
+	if ls.ToBoolean(1) { // condition is true?
+		return ls.GetTop() // return all arguments
+	}
+	ls.CheckAny(1)                     // there must be a condition
+	ls.Remove(1)                       // remove it
+	ls.PushString("assertion failed!") // default message
+	ls.SetTop(1)                       // leave only message (default if no other one)
+	return baseError(ls)               // call 'error'
+}
+
+// pcall (f [, arg1, ···])
+func basePCall(ls api.LuaState) int {
+	ls.CheckAny(1)
+	ls.PushBoolean(true) // first result if no errors
+	ls.Insert(1)
+	if ls.PCall(ls.GetTop()-2, api.LUA_MULTRET, 0) != api.LUA_OK {
+		ls.PushBoolean(false)
+		ls.PushValue(-2)
+		return 2 // return false plus error message
+	}
+	return ls.GetTop() // return true plus all results
+}
+
+// xpcall (f, msgh [, arg1, ···])
+func baseXPCall(ls api.LuaState) int {
+	n := ls.GetTop()
+	ls.CheckType(2, api.LUA_TFUNCTION) // check error function
+	ls.PushBoolean(true)               // first result if no errors
+	ls.PushValue(1)                    // function
+	ls.Rotate(3, 2)                    // move them below function's arguments
+	if ls.PCall(n-2, api.LUA_MULTRET, 2) != api.LUA_OK {
+		ls.PushBoolean(false)
+		ls.PushValue(-2)
+		return 2 // return false plus error message
+	}
+	return ls.GetTop() - 2 // return true plus all results
+}
+
+// tostring (v)
+func baseToString(ls api.LuaState) int {
+	ls.CheckAny(1)
+	ls.ToStringMeta(1)
+	return 1
+}
@@ -0,0 +1,132 @@
+package stdlib
+
+import "luago/api"
+
+var coFuncs = api.FuncReg{
+	"create":      coCreate,
+	"resume":      coResume,
+	"yield":       coYield,
+	"status":      coStatus,
+	"wrap":        coWrap,
+	"isyieldable": coIsYieldable,
+	"running":     coRunning,
+}
+
+// installs the `coroutine` table in the global table
+func OpenCoroutine(ls api.LuaState) {
+	ls.NewLib(coFuncs)
+	ls.SetGlobal("coroutine")
+}
+
+// coroutine.create (f)
+func coCreate(ls api.LuaState) int {
+	ls.CheckType(1, api.LUA_TFUNCTION)
+	co := ls.NewThread()
+	ls.PushValue(1) // move function to top
+	ls.XMove(co, 1) // move function from ls to co
+	return 1
+}
+
+// coroutine.resume (co [, val1, ···])
+func coResume(ls api.LuaState) int {
+	co := getCo(ls)
+	if r := auxResume(ls, co, ls.GetTop()-1); r < 0 {
+		ls.PushBoolean(false)
+		ls.Insert(-2)
+		return 2 // false + error message
+	} else {
+		ls.PushBoolean(true)
+		ls.Insert(-(r + 1))
+		return r + 1 // true + values passed to yield or returned
+	}
+}
+
+// returns the number of results, or -1 with an error object on the stack
+func auxResume(ls, co api.LuaState, nArgs int) int {
+	ls.CheckStack2(nArgs, "too many arguments to resume")
+	if co.Status() == api.LUA_OK && co.GetTop() == 0 {
+		ls.PushString("cannot resume dead coroutine")
+		return -1
+	}
+	ls.XMove(co, nArgs)
+	status := co.Resume(ls, nArgs)
+	if status == api.LUA_OK || status == api.LUA_YIELD {
+		nResults := co.GetTop()
+		ls.CheckStack(nResults + 1)
+		co.XMove(ls, nResults) // move yielded values
+		return nResults
+	}
+	co.XMove(ls, 1) // move error message
+	return -1
+}
+
+// coroutine.yield (···)
+func coYield(ls api.LuaState) int {
+	return ls.Yield(ls.GetTop())
+}
+
+// coroutine.status (co)
+func coStatus(ls api.LuaState) int {
+	co := getCo(ls)
+	ls.PushString(auxStatus(ls, co))
+	return 1
+}
+
+func auxStatus(ls, co api.LuaState) string {
+	if ls == co {
+		return "running"
+	}
+	switch co.Status() {
+	case api.LUA_YIELD:
+		return "suspended"
+	case api.LUA_OK:
+		if co.GetStack() { // does it have frames?
+			return "normal" // it is running
+		} else if co.GetTop() == 0 {
+			return "dead"
+		} else {
+			return "suspended" // initial state
+		}
+	default: // some error occurred
+		return "dead"
+	}
+}
+
+// coroutine.wrap (f)
+func coWrap(ls api.LuaState) int {
+	coCreate(ls)
+	ls.PushGoClosure(auxWrap, 1)
+	return 1
+}
+
+func auxWrap(ls api.LuaState) int {
+	co := ls.ToThread(api.UpvalueIndex(1))
+	if r := auxResume(ls, co, ls.GetTop()); r >= 0 {
+		return r
+	}
+	if ls.Type(-1) == api.LUA_TSTRING { // error object is a string?
+		ls.Where(1) // get extra info
+		ls.Insert(-2)
+		ls.Concat(2)
+	}
+	return ls.Error() // propagate error
+}
+
+// coroutine.isyieldable ()
+func coIsYieldable(ls api.LuaState) int {
+	ls.PushBoolean(ls.IsYieldable())
+	return 1
+}
+
+// coroutine.running ()
+func coRunning(ls api.LuaState) int {
+	isMain := ls.PushThread()
+	ls.PushBoolean(isMain)
+	return 2
+}
+
+func getCo(ls api.LuaState) api.LuaState {
+	co := ls.ToThread(1)
+	ls.ArgCheck(co != nil, 1, "coroutine expected")
+	return co
+}
@@ -0,0 +1,30 @@
+package stdlib
+
+import "luago/api"
+
+// the standard libraries with the global names they are opened as
+var loadedLibs = []struct {
+	name string
+	open func(api.LuaState)
+}{
+	{"_G", OpenBase},
+	{"package", OpenPackage},
+	{"coroutine", OpenCoroutine},
+	{"string", OpenString},
+	{"table", OpenTable},
+	{"math", OpenMath},
+	{"utf8", OpenUTF8},
+	{"io", OpenIO},
+	{"os", OpenOS},
+}
+
+// opens all standard libraries into the global table and `package.loaded`
+func OpenLibs(ls api.LuaState) {
+	ls.GetSubTable(api.LUA_REGISTRYINDEX, api.LUA_LOADED_TABLE)
+	for _, lib := range loadedLibs {
+		lib.open(ls)
+		ls.GetGlobal(lib.name)
+		ls.SetField(-2, lib.name) // LOADED[name] = module
+	}
+	ls.Pop(1) // remove LOADED table
+}
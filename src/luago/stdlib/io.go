@@ -0,0 +1,791 @@
+package stdlib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"luago/api"
+	"luago/number"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	luaFileHandle = "FILE*"
+	ioInput       = "_IO_input"  // registry key of the default input file
+	ioOutput      = "_IO_output" // registry key of the default output file
+	ioFiles       = "_IO_files"  // registry key of the list of open files
+	maxArgLine    = 250          // maximum number of arguments to 'lines'
+	maxLenNum     = 200          // maximum length of a numeral read by 'read'
+)
+
+/**
+ * A file handle as seen by Lua. Reads go through a bufio.Reader, writes
+ * are unbuffered unless `setvbuf` asks otherwise. closef is nil once the
+ * stream is closed.
+ */
+type luaStream struct {
+	*fileBuf
+	r       *bufio.Reader
+	lineBuf bool // flush w at every newline
+	closef  api.GoFunction
+	readErr error // last read error, the equivalent of ferror
+}
+
+// the file of a stream and its write buffer, which is all closing it takes
+type fileBuf struct {
+	f api.File
+	w *bufio.Writer // nil when unbuffered
+}
+
+/**
+ * The files of a state that are still open, so that CloseFiles can flush
+ * and close them as lua_close does. It does not refer to the handles: when
+ * a handle becomes unreachable, a finalizer closes its file as the __gc
+ * metamethod of file handles would, possibly on another goroutine.
+ */
+type openFiles struct {
+	mu    sync.Mutex
+	files map[*fileBuf]bool // true for the standard files, which stay open
+}
+
+var ioFuncs = api.FuncReg{
+	"close":   ioClose,
+	"flush":   ioFlush,
+	"input":   ioInputFile,
+	"lines":   ioLines,
+	"open":    ioOpen,
+	"output":  ioOutputFile,
+	"popen":   ioPopen,
+	"read":    ioRead,
+	"tmpfile": ioTmpFile,
+	"type":    ioType,
+	"write":   ioWrite,
+}
+
+// methods for file handles
+var fileMethods = api.FuncReg{
+	"close":   ioClose,
+	"flush":   fFlush,
+	"lines":   fLines,
+	"read":    fRead,
+	"seek":    fSeek,
+	"setvbuf": fSetvbuf,
+	"write":   fWrite,
+}
+
+// installs the `io` table in the global table
+func OpenIO(ls api.LuaState) {
+	if getOpenFiles(ls) == nil {
+		ls.NewUserdata(&openFiles{files: map[*fileBuf]bool{}})
+		ls.SetField(api.LUA_REGISTRYINDEX, ioFiles)
+	}
+	ls.NewLib(ioFuncs)
+	createMeta(ls)
+	// create (and set) default files
+	createStdFile(ls, os.Stdin, ioInput, "stdin")
+	createStdFile(ls, os.Stdout, ioOutput, "stdout")
+	createStdFile(ls, os.Stderr, "", "stderr")
+	ls.SetGlobal("io")
+}
+
+func createMeta(ls api.LuaState) {
+	ls.NewMetatable(luaFileHandle) // create metatable for file handles
+	ls.PushValue(-1)               // push metatable
+	ls.SetField(-2, "__index")     // metatable.__index = metatable
+	ls.SetFuncs(api.FuncReg{"__tostring": fToString}, 0)
+	ls.SetFuncs(fileMethods, 0) // add file methods to new metatable
+	ls.Pop(1)                   // pop new metatable
+}
+
+// function to (not) close the standard files stdin, stdout, and stderr
+func ioNoClose(ls api.LuaState) int {
+	p := toLStream(ls)
+	p.closef = ioNoClose // keep file opened
+	ls.PushNil()
+	ls.PushString("cannot close standard file")
+	return 2
+}
+
+func createStdFile(ls api.LuaState, f *os.File, k, fname string) {
+	p := newPreFile(ls)
+	p.setFile(f)
+	getOpenFiles(ls).add(p.fileBuf, true)
+	p.closef = ioNoClose
+	if k != "" {
+		ls.PushValue(-1)
+		ls.SetField(api.LUA_REGISTRYINDEX, k) // add file to registry
+	}
+	ls.SetField(-2, fname) // add file to module
+}
+
+/**
+ * When creating file handles, always creates a `closed' file handle
+ * before opening the actual file; so, if there is a memory error, the
+ * handle is in a consistent state.
+ */
+func newPreFile(ls api.LuaState) *luaStream {
+	p := &luaStream{fileBuf: &fileBuf{}}
+	ls.NewUserdata(p)
+	ls.SetMetatable2(luaFileHandle)
+	return p
+}
+
+func newFile(ls api.LuaState) *luaStream {
+	p := newPreFile(ls)
+	p.closef = ioFClose
+	return p
+}
+
+func (p *luaStream) setFile(f api.File) {
+	p.f = f
+	p.r = bufio.NewReader(f)
+}
+
+func (p *luaStream) isClosed() bool {
+	return p.closef == nil
+}
+
+func toLStream(ls api.LuaState) *luaStream {
+	return ls.CheckUdata(1, luaFileHandle).(*luaStream)
+}
+
+func toFile(ls api.LuaState) *luaStream {
+	p := toLStream(ls)
+	if p.isClosed() {
+		ls.Error2("attempt to use a closed file")
+	}
+	return p
+}
+
+// checks the mode of 'open': [rwa]%+?b*
+func checkMode(mode string) bool {
+	if mode == "" || strings.IndexByte("rwa", mode[0]) < 0 {
+		return false
+	}
+	mode = mode[1:]
+	if mode != "" && mode[0] == '+' {
+		mode = mode[1:] // skip if char is '+'
+	}
+	return strings.Trim(mode, "b") == "" // check extensions
+}
+
+// translates a mode of 'open' to os.OpenFile flags
+func modeFlags(mode string) int {
+	update := strings.IndexByte(mode, '+') >= 0
+	switch mode[0] {
+	case 'r':
+		if update {
+			return os.O_RDWR
+		}
+		return os.O_RDONLY
+	case 'w':
+		if update {
+			return os.O_RDWR | os.O_CREATE | os.O_TRUNC
+		}
+		return os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	default: // 'a'
+		if update {
+			return os.O_RDWR | os.O_CREATE | os.O_APPEND
+		}
+		return os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+}
+
+// opens a file in a new handle, returning the error of the file system
+func openFile(ls api.LuaState, fname, mode string) (*luaStream, error) {
+	p := newFile(ls)
+	p.closef = nil // stays closed if the file cannot be opened
+	f, err := ls.FileSystem().OpenFile(fname, modeFlags(mode), 0666)
+	if err == nil {
+		p.setFile(f)
+		p.closef = ioFClose
+		files := getOpenFiles(ls)
+		files.add(p.fileBuf, false)
+		runtime.SetFinalizer(p, func(p *luaStream) { files.close(p.fileBuf) })
+	}
+	return p, err
+}
+
+func openCheckFile(ls api.LuaState, fname, mode string) {
+	if _, err := openFile(ls, fname, mode); err != nil {
+		ls.FileResult(err, "") // get the message of the error
+		ls.Error2("cannot open file '%s' (%s)", fname, ls.ToString(-2))
+	}
+}
+
+func auxClose(ls api.LuaState) int {
+	p := toLStream(ls)
+	cf := p.closef
+	p.closef = nil // mark stream as closed
+	return cf(ls)  // close it
+}
+
+// function to close regular files
+func ioFClose(ls api.LuaState) int {
+	p := toLStream(ls)
+	return ls.FileResult(getOpenFiles(ls).close(p.fileBuf), "")
+}
+
+// io.close ([file])
+func ioClose(ls api.LuaState) int {
+	if ls.IsNone(1) { // no argument?
+		ls.GetField(api.LUA_REGISTRYINDEX, ioOutput) // use standard output
+	}
+	toFile(ls) // make sure argument is an open stream
+	return auxClose(ls)
+}
+
+func fToString(ls api.LuaState) int {
+	p := toLStream(ls)
+	if p.isClosed() {
+		ls.PushString("file (closed)")
+	} else {
+		ls.PushString(fmt.Sprintf("file (%p)", p))
+	}
+	return 1
+}
+
+// io.open (filename [, mode])
+func ioOpen(ls api.LuaState) int {
+	filename := ls.CheckString(1)
+	mode := ls.OptString(2, "r")
+	ls.ArgCheck(checkMode(mode), 2, "invalid mode")
+	if _, err := openFile(ls, filename, mode); err != nil {
+		return ls.FileResult(err, filename)
+	}
+	return 1
+}
+
+// io.popen (prog [, mode])
+func ioPopen(ls api.LuaState) int {
+	ls.CheckString(1)
+	return ls.Error2("'popen' not supported")
+}
+
+/**
+ * io.tmpfile ()
+ * The file is created through the file system and removed when closed.
+ */
+func ioTmpFile(ls api.LuaState) int {
+	fsys := ls.FileSystem()
+	name, err := tmpName(fsys)
+	if err != nil {
+		return ls.FileResult(err, "")
+	}
+	p, err := openFile(ls, name, "w+")
+	if err != nil {
+		return ls.FileResult(err, "")
+	}
+	p.closef = func(ls api.LuaState) int {
+		n := ioFClose(ls)
+		fsys.Remove(name)
+		return n
+	}
+	return 1
+}
+
+// io.type (obj)
+func ioType(ls api.LuaState) int {
+	ls.CheckAny(1)
+	if data, ok := ls.TestUdata(1, luaFileHandle); !ok {
+		ls.PushNil() // not a file
+	} else if p := data.(*luaStream); p.isClosed() {
+		ls.PushString("closed file")
+	} else {
+		ls.PushString("file")
+	}
+	return 1
+}
+
+func getIOFile(ls api.LuaState, findex string) *luaStream {
+	ls.GetField(api.LUA_REGISTRYINDEX, findex)
+	p := ls.ToUserdata(-1).(*luaStream)
+	if p.isClosed() {
+		ls.Error2("standard %s file is closed", findex[len("_IO_"):])
+	}
+	return p
+}
+
+func gIOFile(ls api.LuaState, f, mode string) int {
+	if !ls.IsNoneOrNil(1) {
+		if ls.Type(1) == api.LUA_TSTRING || ls.Type(1) == api.LUA_TNUMBER {
+			openCheckFile(ls, ls.ToString(1), mode)
+		} else {
+			toFile(ls) // check that it's a valid file handle
+			ls.PushValue(1)
+		}
+		ls.SetField(api.LUA_REGISTRYINDEX, f)
+	}
+	// return current value
+	ls.GetField(api.LUA_REGISTRYINDEX, f)
+	return 1
+}
+
+// io.input ([file])
+func ioInputFile(ls api.LuaState) int {
+	return gIOFile(ls, ioInput, "r")
+}
+
+// io.output ([file])
+func ioOutputFile(ls api.LuaState) int {
+	return gIOFile(ls, ioOutput, "w")
+}
+
+/**
+ * Returns the iteration function of 'lines'. The file to read is at index
+ * 1 and the formats follow it, they become upvalues of the iterator
+ * together with their count and whether to close the file at the end.
+ */
+func auxLines(ls api.LuaState, toClose bool) {
+	n := ls.GetTop() - 1 // number of arguments to read
+	ls.ArgCheck(n <= maxArgLine, maxArgLine+2, "too many arguments")
+	ls.PushInteger(int64(n)) // number of arguments to read
+	ls.PushBoolean(toClose)  // close/not close file when finished
+	ls.Rotate(2, 2)          // move 'n' and 'toclose' to their positions
+	ls.PushGoClosure(ioReadLine, 3+n)
+}
+
+// file:lines (···)
+func fLines(ls api.LuaState) int {
+	toFile(ls) // check that it's a valid file handle
+	auxLines(ls, false)
+	return 1
+}
+
+// io.lines ([filename, ···])
+func ioLines(ls api.LuaState) int {
+	if ls.IsNone(1) {
+		ls.PushNil() // at least one argument
+	}
+	toClose := false
+	if ls.IsNil(1) { // no file name?
+		ls.GetField(api.LUA_REGISTRYINDEX, ioInput) // get default input
+		ls.Replace(1)                               // put it at index 1
+		toFile(ls)                                  // check that it's a valid file handle
+	} else { // open a new file
+		filename := ls.CheckString(1)
+		openCheckFile(ls, filename, "r")
+		ls.Replace(1) // put file at index 1
+		toClose = true
+	}
+	auxLines(ls, toClose)
+	return 1
+}
+
+/*
+** {======================================================
+** READ
+** =======================================================
+ */
+
+// auxiliary structure used by 'readNumber'
+type rn struct {
+	r    *bufio.Reader
+	c    int // current character (look ahead)
+	buff []byte
+}
+
+func (rn *rn) getc() {
+	if c, err := rn.r.ReadByte(); err == nil {
+		rn.c = int(c)
+	} else {
+		rn.c = -1 // EOF
+	}
+}
+
+// adds current char to buffer (if not out of space) and reads next one
+func (rn *rn) nextc() bool {
+	if len(rn.buff) >= maxLenNum { // buffer overflow?
+		rn.buff = rn.buff[:0] // invalidate result
+		return false          // fail
+	}
+	rn.buff = append(rn.buff, byte(rn.c)) // save current char
+	rn.getc()                             // read next one
+	return true
+}
+
+// accepts current char if it is in 'set' (of size 2)
+func (rn *rn) test2(set string) bool {
+	if rn.c == int(set[0]) || rn.c == int(set[1]) {
+		return rn.nextc()
+	}
+	return false
+}
+
+// reads a sequence of (hex)digits
+func (rn *rn) readDigits(hex bool) int {
+	count := 0
+	for rn.c >= 0 && (hex && isXDigit(byte(rn.c)) || !hex && isDigit(byte(rn.c))) && rn.nextc() {
+		count++
+	}
+	return count
+}
+
+/**
+ * Reads a numeral from stream and pushes its value, or nil when it is not
+ * a valid numeral. Accepts at most 200 characters and reads one character
+ * past the numeral, which it then pushes back.
+ */
+func readNumber(ls api.LuaState, p *luaStream) bool {
+	rn := &rn{r: p.r}
+	count := 0
+	hex := false
+	for rn.getc(); rn.c >= 0 && isSpace(byte(rn.c)); rn.getc() { // skip spaces
+	}
+	rn.test2("-+") // optional signal
+	if rn.test2("00") {
+		if rn.test2("xX") {
+			hex = true // numeral is hexadecimal
+		} else {
+			count = 1 // count initial '0' as a valid digit
+		}
+	}
+	count += rn.readDigits(hex) // integral part
+	if rn.test2("..") {         // decimal point?
+		count += rn.readDigits(hex) // fractional part
+	}
+	expo := "eE"
+	if hex {
+		expo = "pP"
+	}
+	if count > 0 && rn.test2(expo) { // exponent mark?
+		rn.test2("-+")       // exponent signal
+		rn.readDigits(false) // exponent digits
+	}
+	if rn.c >= 0 {
+		p.r.UnreadByte() // unread look-ahead char
+	}
+	if ls.StringToNumber(string(rn.buff)) {
+		return true // ok
+	}
+	// invalid format
+	ls.PushNil() // "result" to be removed
+	return false // read fails
+}
+
+func testEOF(ls api.LuaState, p *luaStream) bool {
+	_, err := p.r.Peek(1)
+	p.noteError(err)
+	ls.PushString("")
+	return err == nil
+}
+
+func readLine(ls api.LuaState, p *luaStream, chop bool) bool {
+	line, err := p.r.ReadBytes('\n')
+	p.noteError(err)
+	if err == nil && chop {
+		line = line[:len(line)-1] // remove '\n'
+	}
+	ls.PushString(string(line))
+	// return ok if read something (either a newline or something else)
+	return err == nil || len(line) > 0
+}
+
+func readAll(ls api.LuaState, p *luaStream) {
+	all, err := io.ReadAll(p.r)
+	p.noteError(err)
+	ls.PushString(string(all))
+}
+
+func readChars(ls api.LuaState, p *luaStream, n int64) bool {
+	var buf bytes.Buffer
+	_, err := io.CopyN(&buf, p.r, n)
+	p.noteError(err)
+	ls.PushString(buf.String())
+	return buf.Len() > 0 // true iff read something
+}
+
+// records read errors other than the end of file
+func (p *luaStream) noteError(err error) {
+	if err != nil && err != io.EOF {
+		p.readErr = err
+	}
+}
+
+func gRead(ls api.LuaState, p *luaStream, first int) int {
+	nargs := ls.GetTop() - 1
+	var success bool
+	var n int
+	p.readErr = nil
+	if err := p.prepareRead(); err != nil {
+		return ls.FileResult(err, "")
+	}
+	if nargs == 0 { // no arguments?
+		success = readLine(ls, p, true)
+		n = first + 1 // to return 1 result
+	} else { // ensure stack space for all results and for auxlib's buffer
+		ls.CheckStack2(nargs+api.LUA_MINSTACK, "too many arguments")
+		success = true
+		for n = first; nargs > 0 && success; n++ {
+			nargs--
+			if ls.Type(n) == api.LUA_TNUMBER {
+				l := ls.CheckInteger(n)
+				if l == 0 {
+					success = testEOF(ls, p)
+				} else {
+					success = readChars(ls, p, l)
+				}
+			} else {
+				format := ls.CheckString(n)
+				if format != "" && format[0] == '*' {
+					format = format[1:] // skip optional '*' (for compatibility)
+				}
+				switch {
+				case strings.HasPrefix(format, "n"): // number
+					success = readNumber(ls, p)
+				case strings.HasPrefix(format, "l"): // line
+					success = readLine(ls, p, true)
+				case strings.HasPrefix(format, "L"): // line with end-of-line
+					success = readLine(ls, p, false)
+				case strings.HasPrefix(format, "a"): // file
+					readAll(ls, p) // read entire file
+					success = true // always success
+				default:
+					return ls.ArgError(n, "invalid format")
+				}
+			}
+		}
+	}
+	if p.readErr != nil {
+		return ls.FileResult(p.readErr, "")
+	}
+	if !success {
+		ls.Pop(1)    // remove last result
+		ls.PushNil() // push nil instead
+	}
+	return n - first
+}
+
+// io.read (···)
+func ioRead(ls api.LuaState) int {
+	return gRead(ls, getIOFile(ls, ioInput), 1)
+}
+
+// file:read (···)
+func fRead(ls api.LuaState) int {
+	return gRead(ls, toFile(ls), 2)
+}
+
+// iteration function of 'lines'
+func ioReadLine(ls api.LuaState) int {
+	p := ls.ToUserdata(api.UpvalueIndex(1)).(*luaStream)
+	n := int(ls.ToInteger(api.UpvalueIndex(2)))
+	if p.isClosed() { // file is already closed?
+		return ls.Error2("file is already closed")
+	}
+	ls.SetTop(1)
+	ls.CheckStack2(n, "too many arguments")
+	for i := 1; i <= n; i++ { // push arguments to 'gRead'
+		ls.PushValue(api.UpvalueIndex(3 + i))
+	}
+	n = gRead(ls, p, 2)   // 'n' is number of results
+	if ls.ToBoolean(-n) { // read at least one value?
+		return n // return them
+	}
+	// first result is nil: EOF or error
+	if n > 1 { // is there error information?
+		// 2nd result is error message
+		return ls.Error2("%s", ls.ToString(-n+1))
+	}
+	if ls.ToBoolean(api.UpvalueIndex(3)) { // generate error?
+		ls.SetTop(0)
+		ls.PushValue(api.UpvalueIndex(1))
+		auxClose(ls) // close it
+	}
+	return 0
+}
+
+/* }====================================================== */
+
+func gWrite(ls api.LuaState, p *luaStream, arg int) int {
+	nargs := ls.GetTop() - arg
+	if err := p.prepareWrite(); err != nil {
+		return ls.FileResult(err, "")
+	}
+	var err error
+	for ; nargs > 0; nargs-- {
+		var s string
+		if ls.Type(arg) == api.LUA_TNUMBER {
+			// optimization: could be done exactly as for strings
+			if ls.IsInteger(arg) {
+				s = strconv.FormatInt(ls.ToInteger(arg), 10)
+			} else {
+				s = formatNumber(ls.ToNumber(arg))
+			}
+		} else {
+			s = ls.CheckString(arg)
+		}
+		if err == nil {
+			err = p.write(s)
+		}
+		arg++
+	}
+	if err == nil {
+		return 1 // file handle already on stack top
+	}
+	return ls.FileResult(err, "")
+}
+
+// formats a float as "%.14g", without the ".0" that tostring adds
+func formatNumber(f float64) string {
+	s := number.FloatToString(f)
+	return strings.TrimSuffix(s, ".0")
+}
+
+// io.write (···)
+func ioWrite(ls api.LuaState) int {
+	return gWrite(ls, getIOFile(ls, ioOutput), 1)
+}
+
+// file:write (···)
+func fWrite(ls api.LuaState) int {
+	p := toFile(ls)
+	ls.PushValue(1) // push file at the stack top (to be returned)
+	return gWrite(ls, p, 2)
+}
+
+// file:seek ([whence [, offset]])
+func fSeek(ls api.LuaState) int {
+	modeNames := []string{"set", "cur", "end"}
+	p := toFile(ls)
+	op := ls.CheckOption(2, "cur", modeNames)
+	offset := ls.OptInteger(3, 0)
+	if err := p.flush(); err != nil {
+		return ls.FileResult(err, "")
+	}
+	if op == io.SeekCurrent {
+		offset -= int64(p.r.Buffered()) // the reader is ahead of the stream
+	}
+	pos, err := p.f.Seek(offset, op)
+	if err != nil {
+		return ls.FileResult(err, "") // error
+	}
+	p.r.Reset(p.f)
+	ls.PushInteger(pos)
+	return 1
+}
+
+// file:setvbuf (mode [, size])
+func fSetvbuf(ls api.LuaState) int {
+	modeNames := []string{"no", "full", "line"}
+	p := toFile(ls)
+	op := ls.CheckOption(2, "", modeNames)
+	sz := ls.OptInteger(3, 1024)
+	err := p.flush()
+	if op == 0 {
+		p.w = nil
+	} else {
+		if sz <= 0 {
+			sz = 1024
+		}
+		p.w = bufio.NewWriterSize(p.f, int(sz))
+	}
+	p.lineBuf = op == 2
+	return ls.FileResult(err, "")
+}
+
+// io.flush ()
+func ioFlush(ls api.LuaState) int {
+	return ls.FileResult(getIOFile(ls, ioOutput).flush(), "")
+}
+
+// file:flush ()
+func fFlush(ls api.LuaState) int {
+	return ls.FileResult(toFile(ls).flush(), "")
+}
+
+// writes s, through the buffer if there is one
+func (p *luaStream) write(s string) error {
+	if p.w == nil {
+		_, err := io.WriteString(p.f, s)
+		return err
+	}
+	if _, err := p.w.WriteString(s); err != nil {
+		return err
+	}
+	if p.lineBuf && strings.IndexByte(s, '\n') >= 0 {
+		return p.w.Flush()
+	}
+	return nil
+}
+
+// writes out buffered data
+func (fb *fileBuf) flush() error {
+	if fb.w == nil {
+		return nil
+	}
+	return fb.w.Flush()
+}
+
+// pending writes must reach the stream before it is read
+func (p *luaStream) prepareRead() error {
+	return p.flush()
+}
+
+// data read ahead must be given back before writing at the current position
+func (p *luaStream) prepareWrite() error {
+	if n := p.r.Buffered(); n > 0 {
+		if _, err := p.f.Seek(-int64(n), io.SeekCurrent); err != nil {
+			return err
+		}
+		p.r.Reset(p.f)
+	}
+	return nil
+}
+
+/**
+ * Flushes and closes the files opened through the io library of ls, and
+ * flushes the standard files, as lua_close does when it collects the file
+ * handles. A host calls it when it is done with the state; os.exit calls
+ * it before exiting.
+ */
+func CloseFiles(ls api.LuaState) {
+	if files := getOpenFiles(ls); files != nil {
+		files.closeAll()
+	}
+}
+
+func getOpenFiles(ls api.LuaState) *openFiles {
+	ls.GetField(api.LUA_REGISTRYINDEX, ioFiles)
+	files, _ := ls.ToUserdata(-1).(*openFiles)
+	ls.Pop(1)
+	return files
+}
+
+func (of *openFiles) add(fb *fileBuf, std bool) {
+	of.mu.Lock()
+	defer of.mu.Unlock()
+	of.files[fb] = std
+}
+
+// flushes and closes the file, if it is still open
+func (of *openFiles) close(fb *fileBuf) error {
+	of.mu.Lock()
+	defer of.mu.Unlock()
+	if _, found := of.files[fb]; !found {
+		return nil
+	}
+	delete(of.files, fb)
+	err := fb.flush()
+	if cerr := fb.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (of *openFiles) closeAll() {
+	of.mu.Lock()
+	defer of.mu.Unlock()
+	for fb, std := range of.files {
+		fb.flush()
+		if !std {
+			fb.f.Close()
+			delete(of.files, fb)
+		}
+	}
+}
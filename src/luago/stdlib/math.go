@@ -0,0 +1,322 @@
+package stdlib
+
+import (
+	"luago/api"
+	"luago/number"
+	"math"
+	"math/rand"
+)
+
+var mathFuncs = api.FuncReg{
+	"abs":       mathAbs,
+	"ceil":      mathCeil,
+	"floor":     mathFloor,
+	"fmod":      mathFmod,
+	"tointeger": mathToInt,
+	"modf":      mathModf,
+	"sqrt":      mathSqrt,
+	"ult":       mathUlt,
+	"log":       mathLog,
+	"exp":       mathExp,
+	"deg":       mathDeg,
+	"rad":       mathRad,
+	"sin":       mathSin,
+	"cos":       mathCos,
+	"tan":       mathTan,
+	"asin":      mathAsin,
+	"acos":      mathAcos,
+	"atan":      mathAtan,
+	"min":       mathMin,
+	"max":       mathMax,
+	"type":      mathType,
+}
+
+// installs the `math` table in the global table
+func OpenMath(ls api.LuaState) {
+	ls.NewLib(mathFuncs)
+	// each state gets its own generator, seeded as C's rand() is by default
+	g := &mathRand{rand.New(rand.NewSource(1))}
+	ls.SetFuncs(api.FuncReg{"random": g.random, "randomseed": g.randomSeed}, 0)
+	ls.PushNumber(math.Pi)
+	ls.SetField(-2, "pi")
+	ls.PushNumber(math.Inf(1))
+	ls.SetField(-2, "huge")
+	ls.PushInteger(math.MaxInt64)
+	ls.SetField(-2, "maxinteger")
+	ls.PushInteger(math.MinInt64)
+	ls.SetField(-2, "mininteger")
+	ls.SetGlobal("math")
+}
+
+// pushes d as an integer if it has an exact integer representation
+func pushNumInt(ls api.LuaState, d float64) {
+	if n, ok := number.FloatToInteger(d); ok { // does 'd' fit in an integer?
+		ls.PushInteger(n) // result is integer
+	} else {
+		ls.PushNumber(d) // result is float
+	}
+}
+
+// math.abs (x)
+func mathAbs(ls api.LuaState) int {
+	if ls.IsInteger(1) {
+		if n := ls.ToInteger(1); n < 0 {
+			ls.PushInteger(-n) // wraps around for math.mininteger
+		} else {
+			ls.PushInteger(n)
+		}
+	} else {
+		ls.PushNumber(math.Abs(ls.CheckNumber(1)))
+	}
+	return 1
+}
+
+// math.floor (x)
+func mathFloor(ls api.LuaState) int {
+	if ls.IsInteger(1) {
+		ls.SetTop(1) // integer is its own floor
+	} else {
+		pushNumInt(ls, math.Floor(ls.CheckNumber(1)))
+	}
+	return 1
+}
+
+// math.ceil (x)
+func mathCeil(ls api.LuaState) int {
+	if ls.IsInteger(1) {
+		ls.SetTop(1) // integer is its own ceil
+	} else {
+		pushNumInt(ls, math.Ceil(ls.CheckNumber(1)))
+	}
+	return 1
+}
+
+// math.fmod (x, y)
+func mathFmod(ls api.LuaState) int {
+	if ls.IsInteger(1) && ls.IsInteger(2) {
+		d := ls.ToInteger(2)
+		if uint64(d)+1 <= 1 { // special cases: -1 or 0
+			ls.ArgCheck(d != 0, 2, "zero")
+			ls.PushInteger(0) // avoid overflow with 0x80000... / -1
+		} else {
+			ls.PushInteger(ls.ToInteger(1) % d)
+		}
+	} else {
+		ls.PushNumber(math.Mod(ls.CheckNumber(1), ls.CheckNumber(2)))
+	}
+	return 1
+}
+
+// math.modf (x)
+func mathModf(ls api.LuaState) int {
+	if ls.IsInteger(1) {
+		ls.SetTop(1)     // number is its own integer part
+		ls.PushNumber(0) // no fractional part
+	} else {
+		n := ls.CheckNumber(1)
+		ip := math.Trunc(n) // integer part (rounds toward zero)
+		ls.PushNumber(ip)
+		if n == ip { // fractional part (test needed for inf/-inf)
+			ls.PushNumber(0)
+		} else {
+			ls.PushNumber(n - ip)
+		}
+	}
+	return 2
+}
+
+// math.tointeger (x)
+func mathToInt(ls api.LuaState) int {
+	if n, ok := ls.ToIntegerX(1); ok {
+		ls.PushInteger(n)
+	} else {
+		ls.CheckAny(1)
+		ls.PushNil() // value is not convertible to integer
+	}
+	return 1
+}
+
+// math.ult (m, n)
+func mathUlt(ls api.LuaState) int {
+	a := ls.CheckInteger(1)
+	b := ls.CheckInteger(2)
+	ls.PushBoolean(uint64(a) < uint64(b))
+	return 1
+}
+
+// math.log (x [, base])
+func mathLog(ls api.LuaState) int {
+	x := ls.CheckNumber(1)
+	var res float64
+	if ls.IsNoneOrNil(2) {
+		res = math.Log(x)
+	} else {
+		switch base := ls.CheckNumber(2); base {
+		case 2:
+			res = math.Log2(x)
+		case 10:
+			res = math.Log10(x)
+		default:
+			res = math.Log(x) / math.Log(base)
+		}
+	}
+	ls.PushNumber(res)
+	return 1
+}
+
+// math.exp (x)
+func mathExp(ls api.LuaState) int {
+	ls.PushNumber(math.Exp(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.deg (x)
+func mathDeg(ls api.LuaState) int {
+	ls.PushNumber(ls.CheckNumber(1) * (180 / math.Pi))
+	return 1
+}
+
+// math.rad (x)
+func mathRad(ls api.LuaState) int {
+	ls.PushNumber(ls.CheckNumber(1) * (math.Pi / 180))
+	return 1
+}
+
+// math.sqrt (x)
+func mathSqrt(ls api.LuaState) int {
+	ls.PushNumber(math.Sqrt(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.sin (x)
+func mathSin(ls api.LuaState) int {
+	ls.PushNumber(math.Sin(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.cos (x)
+func mathCos(ls api.LuaState) int {
+	ls.PushNumber(math.Cos(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.tan (x)
+func mathTan(ls api.LuaState) int {
+	ls.PushNumber(math.Tan(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.asin (x)
+func mathAsin(ls api.LuaState) int {
+	ls.PushNumber(math.Asin(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.acos (x)
+func mathAcos(ls api.LuaState) int {
+	ls.PushNumber(math.Acos(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.atan (y [, x])
+func mathAtan(ls api.LuaState) int {
+	y := ls.CheckNumber(1)
+	x := ls.OptNumber(2, 1)
+	ls.PushNumber(math.Atan2(y, x))
+	return 1
+}
+
+// math.min (x, ···)
+func mathMin(ls api.LuaState) int {
+	n := ls.GetTop() // number of arguments
+	imin := 1        // index of current minimum value
+	ls.ArgCheck(n >= 1, 1, "number expected")
+	for i := 2; i <= n; i++ {
+		if ls.Compare(i, imin, api.LUA_OPLT) {
+			imin = i
+		}
+	}
+	ls.PushValue(imin)
+	return 1
+}
+
+// math.max (x, ···)
+func mathMax(ls api.LuaState) int {
+	n := ls.GetTop() // number of arguments
+	imax := 1        // index of current maximum value
+	ls.ArgCheck(n >= 1, 1, "number expected")
+	for i := 2; i <= n; i++ {
+		if ls.Compare(imax, i, api.LUA_OPLT) {
+			imax = i
+		}
+	}
+	ls.PushValue(imax)
+	return 1
+}
+
+// math.type (x)
+func mathType(ls api.LuaState) int {
+	if ls.Type(1) == api.LUA_TNUMBER {
+		if ls.IsInteger(1) {
+			ls.PushString("integer")
+		} else {
+			ls.PushString("float")
+		}
+	} else {
+		ls.CheckAny(1)
+		ls.PushNil()
+	}
+	return 1
+}
+
+// pseudo-random generator behind math.random, the sequence only depends on the seed
+type mathRand struct {
+	r *rand.Rand
+}
+
+// math.random ([m [, n]])
+func (g *mathRand) random(ls api.LuaState) int {
+	var low, up int64
+	switch ls.GetTop() { // check number of arguments
+	case 0: // no arguments
+		ls.PushNumber(g.r.Float64()) // Number between 0 and 1
+		return 1
+	case 1: // only upper limit
+		low = 1
+		up = ls.CheckInteger(1)
+	case 2: // lower and upper limits
+		low = ls.CheckInteger(1)
+		up = ls.CheckInteger(2)
+	default:
+		return ls.Error2("wrong number of arguments")
+	}
+	// random integer in the interval [low, up]
+	ls.ArgCheck(low <= up, 1, "interval is empty")
+	ls.PushInteger(low + int64(g.uint64n(uint64(up-low)+1)))
+	return 1
+}
+
+// returns a uniform value in [0, n), or in the whole range if n is 0
+func (g *mathRand) uint64n(n uint64) uint64 {
+	if n == 0 { // interval is [mininteger, maxinteger]
+		return g.r.Uint64()
+	}
+	limit := math.MaxUint64 - math.MaxUint64%n // reject values past the last full cycle
+	for {
+		if v := g.r.Uint64(); v < limit {
+			return v % n
+		}
+	}
+}
+
+// math.randomseed (x)
+func (g *mathRand) randomSeed(ls api.LuaState) int {
+	var seed int64
+	if ls.IsInteger(1) {
+		seed = ls.ToInteger(1)
+	} else {
+		seed = int64(ls.CheckNumber(1))
+	}
+	g.r.Seed(seed)
+	return 0
+}
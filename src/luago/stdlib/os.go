@@ -0,0 +1,338 @@
+package stdlib
+
+import (
+	"fmt"
+	"luago/api"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// conversions accepted by os.date, C99 ones with their E and O modifiers
+const (
+	strftimeOptions  = "aAbBcCdDeFgGhHIjmMnprRStTuUVwWxXyYzZ%"
+	strftimeEOptions = "cCxXyY"
+	strftimeOOptions = "deHImMSuUVwWy"
+)
+
+const maxDateField = math.MaxInt32 / 2 // limit for the fields of os.time
+
+var startTime = time.Now() // origin of os.clock
+
+var osFuncs = api.FuncReg{
+	"clock":    osClock,
+	"date":     osDate,
+	"difftime": osDiffTime,
+	"exit":     osExit,
+	"getenv":   osGetEnv,
+	"remove":   osRemove,
+	"rename":   osRename,
+	"time":     osTime,
+	"tmpname":  osTmpName,
+}
+
+// installs the `os` table in the global table
+func OpenOS(ls api.LuaState) {
+	ls.NewLib(osFuncs)
+	ls.SetGlobal("os")
+}
+
+// os.exit ([code [, close]])
+func osExit(ls api.LuaState) int {
+	status := 0 // EXIT_SUCCESS
+	if ls.IsBoolean(1) {
+		if !ls.ToBoolean(1) {
+			status = 1 // EXIT_FAILURE
+		}
+	} else {
+		status = int(ls.OptInteger(1, 0))
+	}
+	CloseFiles(ls) // buffered data would be lost
+	os.Exit(status)
+	return 0
+}
+
+// os.getenv (varname)
+func osGetEnv(ls api.LuaState) int {
+	if v, ok := os.LookupEnv(ls.CheckString(1)); ok {
+		ls.PushString(v)
+	} else {
+		ls.PushNil()
+	}
+	return 1
+}
+
+// os.remove (filename)
+func osRemove(ls api.LuaState) int {
+	filename := ls.CheckString(1)
+	return ls.FileResult(ls.FileSystem().Remove(filename), filename)
+}
+
+// os.rename (oldname, newname)
+func osRename(ls api.LuaState) int {
+	fromName := ls.CheckString(1)
+	toName := ls.CheckString(2)
+	return ls.FileResult(ls.FileSystem().Rename(fromName, toName), "")
+}
+
+// os.tmpname ()
+func osTmpName(ls api.LuaState) int {
+	name, err := tmpName(ls.FileSystem())
+	if err != nil {
+		return ls.Error2("unable to generate a unique filename")
+	}
+	ls.PushString(name)
+	return 1
+}
+
+// creates an empty file with a fresh name in the temporary directory
+func tmpName(fsys api.FileSystem) (string, error) {
+	var err error
+	seed := time.Now().UnixNano()
+	for try := int64(0); try < 100; try++ {
+		name := filepath.Join(os.TempDir(), "lua_"+strconv.FormatInt((seed+try)%1e9, 36))
+		var f api.File
+		if f, err = fsys.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600); err == nil {
+			return name, f.Close()
+		}
+	}
+	return "", err
+}
+
+/**
+ * os.clock ()
+ * Go has no portable processor time, so this is the wall-clock time
+ * elapsed since the program started.
+ */
+func osClock(ls api.LuaState) int {
+	ls.PushNumber(time.Since(startTime).Seconds())
+	return 1
+}
+
+/*
+** {======================================================
+** Time/Date operations
+** { year=%Y, month=%m, day=%d, hour=%H, min=%M, sec=%S,
+**   wday=%w+1, yday=%j, isdst=? }
+** =======================================================
+ */
+
+func setField(ls api.LuaState, key string, value int) {
+	ls.PushInteger(int64(value))
+	ls.SetField(-2, key)
+}
+
+// set all fields from 't' in the table on top of the stack
+func setAllFields(ls api.LuaState, t time.Time) {
+	setField(ls, "sec", t.Second())
+	setField(ls, "min", t.Minute())
+	setField(ls, "hour", t.Hour())
+	setField(ls, "day", t.Day())
+	setField(ls, "month", int(t.Month()))
+	setField(ls, "year", t.Year())
+	setField(ls, "wday", int(t.Weekday())+1)
+	setField(ls, "yday", t.YearDay())
+	ls.PushBoolean(t.IsDST())
+	ls.SetField(-2, "isdst")
+}
+
+func getField(ls api.LuaState, key string, d, delta int) int {
+	t := ls.GetField(-1, key) // get field and its type
+	res, isNum := ls.ToIntegerX(-1)
+	if !isNum { // field is not an integer?
+		if t != api.LUA_TNIL { // some other value?
+			return ls.Error2("field '%s' is not an integer", key)
+		} else if d < 0 { // absent field; no default?
+			return ls.Error2("field '%s' missing in date table", key)
+		}
+		res = int64(d)
+	} else {
+		if !(-maxDateField <= res && res <= maxDateField) {
+			return ls.Error2("field '%s' is out-of-bound", key)
+		}
+		res -= int64(delta)
+	}
+	ls.Pop(1)
+	return int(res)
+}
+
+func checkTime(ls api.LuaState, arg int) time.Time {
+	return time.Unix(ls.CheckInteger(arg), 0)
+}
+
+// os.date ([format [, time]])
+func osDate(ls api.LuaState) int {
+	s := ls.OptString(1, "%c")
+	t := time.Now()
+	if !ls.IsNoneOrNil(2) {
+		t = checkTime(ls, 2)
+	}
+	if strings.HasPrefix(s, "!") { // UTC?
+		t = t.UTC()
+		s = s[1:] // skip '!'
+	} else {
+		t = t.Local()
+	}
+	if strings.HasPrefix(s, "*t") {
+		ls.CreateTable(0, 9) // 9 = number of fields
+		setAllFields(ls, t)
+	} else {
+		var b strings.Builder
+		for i := 0; i < len(s); i++ {
+			if s[i] != '%' { // not a conversion specifier?
+				b.WriteByte(s[i])
+				continue
+			}
+			conv := checkOption(ls, s[i+1:])
+			i += len(conv)
+			strftime(&b, conv[len(conv)-1], t)
+		}
+		ls.PushString(b.String())
+	}
+	return 1
+}
+
+// returns the conversion at the start of conv, with its modifier if any
+func checkOption(ls api.LuaState, conv string) string {
+	if conv != "" {
+		if strings.IndexByte(strftimeOptions, conv[0]) >= 0 {
+			return conv[:1]
+		}
+		if len(conv) > 1 && (conv[0] == 'E' && strings.IndexByte(strftimeEOptions, conv[1]) >= 0 ||
+			conv[0] == 'O' && strings.IndexByte(strftimeOOptions, conv[1]) >= 0) {
+			return conv[:2]
+		}
+	}
+	ls.ArgError(1, fmt.Sprintf("invalid conversion specifier '%%%s'", conv))
+	return ""
+}
+
+var weekdayAbbrs = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+var monthAbbrs = []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+
+// writes one conversion of strftime in the "C" locale
+func strftime(b *strings.Builder, c byte, t time.Time) {
+	switch c {
+	case 'a':
+		b.WriteString(weekdayAbbrs[t.Weekday()])
+	case 'A':
+		b.WriteString(t.Weekday().String())
+	case 'b', 'h':
+		b.WriteString(monthAbbrs[t.Month()-1])
+	case 'B':
+		b.WriteString(t.Month().String())
+	case 'c':
+		strftimeString(b, "%a %b %e %H:%M:%S %Y", t)
+	case 'C':
+		fmt.Fprintf(b, "%02d", t.Year()/100)
+	case 'd':
+		fmt.Fprintf(b, "%02d", t.Day())
+	case 'D', 'x':
+		strftimeString(b, "%m/%d/%y", t)
+	case 'e':
+		fmt.Fprintf(b, "%2d", t.Day())
+	case 'F':
+		strftimeString(b, "%Y-%m-%d", t)
+	case 'g':
+		year, _ := t.ISOWeek()
+		fmt.Fprintf(b, "%02d", year%100)
+	case 'G':
+		year, _ := t.ISOWeek()
+		fmt.Fprintf(b, "%d", year)
+	case 'H':
+		fmt.Fprintf(b, "%02d", t.Hour())
+	case 'I':
+		fmt.Fprintf(b, "%02d", (t.Hour()+11)%12+1)
+	case 'j':
+		fmt.Fprintf(b, "%03d", t.YearDay())
+	case 'm':
+		fmt.Fprintf(b, "%02d", int(t.Month()))
+	case 'M':
+		fmt.Fprintf(b, "%02d", t.Minute())
+	case 'n':
+		b.WriteByte('\n')
+	case 'p':
+		if t.Hour() < 12 {
+			b.WriteString("AM")
+		} else {
+			b.WriteString("PM")
+		}
+	case 'r':
+		strftimeString(b, "%I:%M:%S %p", t)
+	case 'R':
+		strftimeString(b, "%H:%M", t)
+	case 'S':
+		fmt.Fprintf(b, "%02d", t.Second())
+	case 't':
+		b.WriteByte('\t')
+	case 'T', 'X':
+		strftimeString(b, "%H:%M:%S", t)
+	case 'u':
+		fmt.Fprintf(b, "%d", (int(t.Weekday())+6)%7+1)
+	case 'U': // week of the year, weeks starting on Sunday
+		fmt.Fprintf(b, "%02d", (t.YearDay()+6-int(t.Weekday()))/7)
+	case 'V':
+		_, week := t.ISOWeek()
+		fmt.Fprintf(b, "%02d", week)
+	case 'w':
+		fmt.Fprintf(b, "%d", int(t.Weekday()))
+	case 'W': // week of the year, weeks starting on Monday
+		fmt.Fprintf(b, "%02d", (t.YearDay()+6-(int(t.Weekday())+6)%7)/7)
+	case 'y':
+		fmt.Fprintf(b, "%02d", t.Year()%100)
+	case 'Y':
+		fmt.Fprintf(b, "%d", t.Year())
+	case 'z':
+		b.WriteString(t.Format("-0700"))
+	case 'Z':
+		zone, _ := t.Zone()
+		b.WriteString(zone)
+	case '%':
+		b.WriteByte('%')
+	}
+}
+
+func strftimeString(b *strings.Builder, format string, t time.Time) {
+	for i := 0; i < len(format); i++ {
+		if format[i] == '%' {
+			i++
+			strftime(b, format[i], t)
+		} else {
+			b.WriteByte(format[i])
+		}
+	}
+}
+
+// os.time ([table])
+func osTime(ls api.LuaState) int {
+	var t time.Time
+	if ls.IsNoneOrNil(1) { // called without args?
+		t = time.Now() // get current time
+	} else {
+		ls.CheckType(1, api.LUA_TTABLE)
+		ls.SetTop(1) // make sure table is at the top
+		sec := getField(ls, "sec", 0, 0)
+		min := getField(ls, "min", 0, 0)
+		hour := getField(ls, "hour", 12, 0)
+		day := getField(ls, "day", -1, 0)
+		month := getField(ls, "month", -1, 0)
+		year := getField(ls, "year", -1, 0)
+		t = time.Date(year, time.Month(month), day, hour, min, sec, 0, time.Local)
+		setAllFields(ls, t) // update fields with normalized values
+	}
+	ls.PushInteger(t.Unix())
+	return 1
+}
+
+// os.difftime (t2, t1)
+func osDiffTime(ls api.LuaState) int {
+	t1 := ls.CheckInteger(1)
+	t2 := ls.CheckInteger(2)
+	ls.PushNumber(float64(t1 - t2))
+	return 1
+}
+
+/* }====================================================== */
@@ -0,0 +1,236 @@
+package stdlib
+
+import (
+	"fmt"
+	"luago/api"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	luaDirSep   = string(filepath.Separator)
+	luaPathSep  = ";" // separates templates in a path
+	luaPathMark = "?" // marks the substitution points in a template
+	luaExecDir  = "!" // in a Windows path is replaced by the executable's directory
+	luaIgMark   = "-" // in luaopen_ names, marks the end of the module name to ignore
+	luaLSubSep  = luaDirSep
+)
+
+const luaPathDefault = "/usr/local/share/lua/5.3/?.lua;/usr/local/share/lua/5.3/?/init.lua;" +
+	"/usr/local/lib/lua/5.3/?.lua;/usr/local/lib/lua/5.3/?/init.lua;" +
+	"./?.lua;./?/init.lua"
+
+var pkgFuncs = api.FuncReg{
+	"searchpath": pkgSearchPath,
+}
+
+var llFuncs = api.FuncReg{
+	"require": pkgRequire,
+}
+
+// installs the `package` table and `require` in the global table
+func OpenPackage(ls api.LuaState) {
+	ls.NewLib(pkgFuncs) // create 'package' table
+	createSearchersTable(ls)
+	// set field 'path'
+	setPath(ls, "path", "LUA_PATH_5_3", "LUA_PATH", luaPathDefault)
+	// store config information
+	ls.PushString(luaDirSep + "\n" + luaPathSep + "\n" + luaPathMark + "\n" +
+		luaExecDir + "\n" + luaIgMark + "\n")
+	ls.SetField(-2, "config")
+	// set field 'loaded'
+	ls.GetSubTable(api.LUA_REGISTRYINDEX, api.LUA_LOADED_TABLE)
+	ls.SetField(-2, "loaded")
+	// set field 'preload'
+	ls.GetSubTable(api.LUA_REGISTRYINDEX, api.LUA_PRELOAD_TABLE)
+	ls.SetField(-2, "preload")
+	ls.PushGlobalTable()
+	ls.PushValue(-2)        // set 'package' as upvalue for next lib
+	ls.SetFuncs(llFuncs, 1) // open lib into global table
+	ls.Pop(1)               // pop global table
+	ls.SetGlobal("package")
+}
+
+/**
+ * Registers f as the loader of the module name, so that `require(name)`
+ * calls it with the module name and takes its result as the module. Can
+ * be called before or after the package library is opened.
+ */
+func PreloadModule(ls api.LuaState, name string, f api.GoFunction) {
+	ls.GetSubTable(api.LUA_REGISTRYINDEX, api.LUA_PRELOAD_TABLE)
+	ls.PushGoFunction(f)
+	ls.SetField(-2, name) // PRELOAD[name] = f
+	ls.Pop(1)             // remove PRELOAD table
+}
+
+func createSearchersTable(ls api.LuaState) {
+	searchers := []api.GoFunction{searcherPreload, searcherLua}
+	// create 'searchers' table
+	ls.CreateTable(len(searchers), 0)
+	// fill it with predefined searchers
+	for i, searcher := range searchers {
+		ls.PushValue(-2) // set 'package' as upvalue for all searchers
+		ls.PushGoClosure(searcher, 1)
+		ls.RawSetI(-2, int64(i+1))
+	}
+	ls.SetField(-2, "searchers") // put it in field 'searchers'
+}
+
+/**
+ * Sets package[fieldName] from the first environment variable set among
+ * envName1 and envName2, with ";;" replaced by the default path def.
+ */
+func setPath(ls api.LuaState, fieldName, envName1, envName2, def string) {
+	path, ok := os.LookupEnv(envName1)
+	if !ok {
+		path, ok = os.LookupEnv(envName2)
+	}
+	if !ok { // no environment variable?
+		path = def // use default
+	} else {
+		// replace ";;" by ";AUXMARK;" and then AUXMARK by default path
+		path = strings.ReplaceAll(path, luaPathSep+luaPathSep, luaPathSep+"\x01"+luaPathSep)
+		path = strings.ReplaceAll(path, "\x01", def)
+	}
+	ls.PushString(path)
+	ls.SetField(-2, fieldName)
+}
+
+// reports whether filename can be opened for reading
+func readable(ls api.LuaState, filename string) bool {
+	f, err := ls.FileSystem().OpenFile(filename, os.O_RDONLY, 0)
+	if err != nil { // open failed
+		return false
+	}
+	f.Close()
+	return true
+}
+
+/**
+ * Looks for name in path, trying each template with its '?' replaced by
+ * name, after the occurrences of sep in name have been replaced by dirSep.
+ * Returns the first readable file name, or "" and the list of files tried.
+ */
+func searchPath(ls api.LuaState, name, path, sep, dirSep string) (string, string) {
+	var msg strings.Builder // to build error message
+	if sep != "" {
+		name = strings.ReplaceAll(name, sep, dirSep) // replace it by 'dirsep'
+	}
+	for _, template := range strings.Split(path, luaPathSep) {
+		if template == "" {
+			continue // skip separators
+		}
+		filename := strings.ReplaceAll(template, luaPathMark, name)
+		if readable(ls, filename) { // does file exist and is readable?
+			return filename, "" // return that file name
+		}
+		fmt.Fprintf(&msg, "\n\tno file '%s'", filename)
+	}
+	return "", msg.String() // not found
+}
+
+// package.searchpath (name, path [, sep [, rep]])
+func pkgSearchPath(ls api.LuaState) int {
+	filename, msg := searchPath(ls, ls.CheckString(1), ls.CheckString(2),
+		ls.OptString(3, "."), ls.OptString(4, luaDirSep))
+	if filename != "" {
+		ls.PushString(filename)
+		return 1
+	}
+	// file not found
+	ls.PushNil()
+	ls.PushString(msg)
+	return 2 // return nil + error message
+}
+
+func findFile(ls api.LuaState, name, pname, dirSep string) (string, string) {
+	ls.GetField(api.UpvalueIndex(1), pname)
+	if !ls.IsString(-1) {
+		ls.Error2("'package.%s' must be a string", pname)
+	}
+	path := ls.ToString(-1)
+	ls.Pop(1)
+	return searchPath(ls, name, path, ".", dirSep)
+}
+
+func checkLoad(ls api.LuaState, stat bool, filename string) int {
+	if stat { // module loaded successfully?
+		ls.PushString(filename) // will be 2nd argument to module
+		return 2                // return open function and file name
+	}
+	return ls.Error2("error loading module '%s' from file '%s':\n\t%s",
+		ls.ToString(1), filename, ls.ToString(-1))
+}
+
+// finds a Lua module, be it source or precompiled, along package.path
+func searcherLua(ls api.LuaState) int {
+	name := ls.CheckString(1)
+	filename, msg := findFile(ls, name, "path", luaLSubSep)
+	if filename == "" {
+		ls.PushString(msg)
+		return 1 // module not found in this path
+	}
+	return checkLoad(ls, ls.LoadFile(filename) == api.LUA_OK, filename)
+}
+
+func searcherPreload(ls api.LuaState) int {
+	name := ls.CheckString(1)
+	ls.GetField(api.LUA_REGISTRYINDEX, api.LUA_PRELOAD_TABLE)
+	if ls.GetField(-1, name) == api.LUA_TNIL { // not found?
+		ls.PushFString("\n\tno field package.preload['%s']", name)
+	}
+	return 1
+}
+
+func findLoader(ls api.LuaState, name string) {
+	var msg strings.Builder // to build error message
+	// push 'package.searchers' to index 3 in the stack
+	if ls.GetField(api.UpvalueIndex(1), "searchers") != api.LUA_TTABLE {
+		ls.Error2("'package.searchers' must be a table")
+	}
+	// iterate over available searchers to find a loader
+	for i := int64(1); ; i++ {
+		if ls.RawGetI(3, i) == api.LUA_TNIL { // no more searchers?
+			ls.Pop(1) // remove nil
+			ls.Error2("module '%s' not found:%s", name, msg.String())
+		}
+		ls.PushString(name)
+		ls.Call(1, 2)          // call it
+		if ls.IsFunction(-2) { // did it find a loader?
+			return // module loader found
+		} else if ls.IsString(-2) { // searcher returned error message?
+			ls.Pop(1)                        // remove extra return
+			msg.WriteString(ls.ToString(-1)) // concatenate error message
+			ls.Pop(1)
+		} else {
+			ls.Pop(2) // remove both returns
+		}
+	}
+}
+
+// require (modname)
+func pkgRequire(ls api.LuaState) int {
+	name := ls.CheckString(1)
+	ls.SetTop(1) // LOADED table will be at index 2
+	ls.GetField(api.LUA_REGISTRYINDEX, api.LUA_LOADED_TABLE)
+	ls.GetField(2, name)  // LOADED[name]
+	if ls.ToBoolean(-1) { // is it there?
+		return 1 // package is already loaded
+	}
+	// else must load package
+	ls.Pop(1) // remove 'getfield' result
+	findLoader(ls, name)
+	ls.PushString(name) // pass name as argument to module loader
+	ls.Insert(-2)       // name is 1st argument (before search data)
+	ls.Call(2, 1)       // run loader to load module
+	if !ls.IsNil(-1) {  // non-nil return?
+		ls.SetField(2, name) // LOADED[name] = returned value
+	}
+	if ls.GetField(2, name) == api.LUA_TNIL { // module set no value?
+		ls.PushBoolean(true) // use true as result
+		ls.PushValue(-1)     // extra copy to be returned
+		ls.SetField(2, name) // LOADED[name] = true
+	}
+	return 1
+}
@@ -0,0 +1,193 @@
+package stdlib
+
+import (
+	"luago/api"
+	"math"
+	"strings"
+)
+
+const maxStringSize = math.MaxInt32 // limit for strings built by 'rep'
+
+var strFuncs = api.FuncReg{
+	"byte":     strByte,
+	"char":     strChar,
+	"dump":     strDump,
+	"find":     strFind,
+	"format":   strFormat,
+	"gmatch":   strGmatch,
+	"gsub":     strGsub,
+	"len":      strLen,
+	"lower":    strLower,
+	"match":    strMatch,
+	"pack":     strPack,
+	"packsize": strPackSize,
+	"rep":      strRep,
+	"reverse":  strReverse,
+	"sub":      strSub,
+	"unpack":   strUnpack,
+	"upper":    strUpper,
+}
+
+// installs the `string` table and the metatable shared by all strings
+func OpenString(ls api.LuaState) {
+	ls.NewLib(strFuncs)
+	createStrMetatable(ls)
+	ls.SetGlobal("string")
+}
+
+func createStrMetatable(ls api.LuaState) {
+	ls.CreateTable(0, 1)       // table to be metatable for strings
+	ls.PushString("")          // dummy string
+	ls.PushValue(-2)           // copy table
+	ls.SetMetatable(-2)        // set table as metatable for strings
+	ls.Pop(1)                  // pop dummy string
+	ls.PushValue(-2)           // get string library
+	ls.SetField(-2, "__index") // metatable.__index = string
+	ls.Pop(1)                  // pop metatable
+}
+
+// translates a relative string position: negative means back from end
+func posRelat(pos int64, l int) int64 {
+	if pos >= 0 {
+		return pos
+	} else if -pos > int64(l) {
+		return 0
+	}
+	return int64(l) + pos + 1
+}
+
+// string.len (s)
+func strLen(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	ls.PushInteger(int64(len(s)))
+	return 1
+}
+
+// string.sub (s, i [, j])
+func strSub(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	l := len(s)
+	start := posRelat(ls.CheckInteger(2), l)
+	end := posRelat(ls.OptInteger(3, -1), l)
+	if start < 1 {
+		start = 1
+	}
+	if end > int64(l) {
+		end = int64(l)
+	}
+	if start <= end {
+		ls.PushString(s[start-1 : end])
+	} else {
+		ls.PushString("")
+	}
+	return 1
+}
+
+// string.reverse (s)
+func strReverse(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	b := make([]byte, len(s))
+	for i := range b {
+		b[i] = s[len(s)-1-i]
+	}
+	ls.PushString(string(b))
+	return 1
+}
+
+// string.lower (s)
+func strLower(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + ('a' - 'A')
+		}
+	}
+	ls.PushString(string(b))
+	return 1
+}
+
+// string.upper (s)
+func strUpper(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	b := []byte(s)
+	for i, c := range b {
+		if 'a' <= c && c <= 'z' {
+			b[i] = c - ('a' - 'A')
+		}
+	}
+	ls.PushString(string(b))
+	return 1
+}
+
+// string.rep (s, n [, sep])
+func strRep(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	n := ls.CheckInteger(2)
+	sep := ls.OptString(3, "")
+	if n <= 0 {
+		ls.PushString("")
+		return 1
+	}
+	if total := int64(len(s) + len(sep)); total > 0 && total > maxStringSize/n {
+		return ls.Error2("resulting string too large")
+	}
+	var sb strings.Builder
+	sb.Grow(int(n)*len(s) + int(n-1)*len(sep))
+	for ; n > 1; n-- { // first n-1 copies (followed by separator)
+		sb.WriteString(s)
+		sb.WriteString(sep)
+	}
+	sb.WriteString(s) // last copy (not followed by separator)
+	ls.PushString(sb.String())
+	return 1
+}
+
+// string.byte (s [, i [, j]])
+func strByte(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	l := len(s)
+	posi := posRelat(ls.OptInteger(2, 1), l)
+	pose := posRelat(ls.OptInteger(3, posi), l)
+	if posi < 1 {
+		posi = 1
+	}
+	if pose > int64(l) {
+		pose = int64(l)
+	}
+	if posi > pose {
+		return 0 // empty interval; return no values
+	}
+	n := int(pose - posi + 1)
+	ls.CheckStack2(n, "string slice too long")
+	for i := 0; i < n; i++ {
+		ls.PushInteger(int64(s[int(posi)+i-1]))
+	}
+	return n
+}
+
+// string.char (···)
+func strChar(ls api.LuaState) int {
+	n := ls.GetTop() // number of arguments
+	b := make([]byte, n)
+	for i := 1; i <= n; i++ {
+		c := ls.CheckInteger(i)
+		ls.ArgCheck(uint64(c) <= math.MaxUint8, i, "value out of range")
+		b[i-1] = byte(c)
+	}
+	ls.PushString(string(b))
+	return 1
+}
+
+// string.dump (function [, strip])
+func strDump(ls api.LuaState) int {
+	strip := ls.ToBoolean(2)
+	ls.CheckType(1, api.LUA_TFUNCTION)
+	ls.SetTop(1)
+	chunk := ls.Dump(strip)
+	if chunk == nil {
+		return ls.Error2("unable to dump given function")
+	}
+	ls.PushString(string(chunk))
+	return 1
+}
@@ -0,0 +1,277 @@
+package stdlib
+
+import (
+	"fmt"
+	"luago/api"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const formatFlags = "-+ #0" // valid flags in a format specification
+
+// string.format (formatstring, ···)
+func strFormat(ls api.LuaState) int {
+	top := ls.GetTop()
+	sfmt := ls.CheckString(1)
+	arg := 1
+	var b strings.Builder
+	for i := 0; i < len(sfmt); {
+		if sfmt[i] != lEsc {
+			b.WriteByte(sfmt[i])
+			i++
+			continue
+		}
+		i++ // skip '%'
+		if i < len(sfmt) && sfmt[i] == lEsc {
+			b.WriteByte(lEsc) // %%
+			i++
+			continue
+		}
+		// format item
+		if arg++; arg > top {
+			ls.ArgError(arg, "no value")
+		}
+		var spec string
+		spec, i = scanFormat(ls, sfmt, i)
+		var conv byte
+		if i < len(sfmt) {
+			conv = sfmt[i]
+			i++
+		}
+		switch conv {
+		case 'c':
+			c := byte(ls.CheckInteger(arg))
+			b.WriteString(padString(spec, string([]byte{c})))
+		case 'd', 'i':
+			n := ls.CheckInteger(arg)
+			b.WriteString(fmt.Sprintf("%"+spec+"d", n))
+		case 'u':
+			n := ls.CheckInteger(arg)
+			b.WriteString(fmt.Sprintf("%"+unsignedSpec(spec)+"d", uint64(n)))
+		case 'o', 'x', 'X':
+			n := ls.CheckInteger(arg)
+			b.WriteString(fmt.Sprintf("%"+unsignedSpec(spec)+string(conv), uint64(n)))
+		case 'a', 'A':
+			f := ls.CheckNumber(arg)
+			b.WriteString(formatHexFloat(spec, conv, f))
+		case 'e', 'E', 'f', 'F', 'g', 'G':
+			f := ls.CheckNumber(arg)
+			b.WriteString(formatFloat(spec, conv, f))
+		case 'q':
+			addLiteral(ls, &b, arg)
+		case 's':
+			s := ls.ToStringMeta(arg)
+			ls.Pop(1)
+			if spec == "" { // no modifiers?
+				b.WriteString(s) // keep entire string
+			} else {
+				ls.ArgCheck(strings.IndexByte(s, 0) < 0, arg, "string contains zeros")
+				b.WriteString(padString(spec, s))
+			}
+		default: // also treat cases 'pnLlh'
+			return ls.Error2("invalid option '%%%c' to 'format'", conv)
+		}
+	}
+	ls.PushString(b.String())
+	return 1
+}
+
+/**
+ * Scans the flags, width and precision of a format item starting at
+ * sfmt[i] and returns them together with the index of the conversion.
+ */
+func scanFormat(ls api.LuaState, sfmt string, i int) (string, int) {
+	p := i
+	for p < len(sfmt) && strings.IndexByte(formatFlags, sfmt[p]) >= 0 {
+		p++ // skip flags
+	}
+	if p-i >= len(formatFlags)+1 {
+		ls.Error2("invalid format (repeated flags)")
+	}
+	p = skipDigits(sfmt, p, 2) // skip width
+	if p < len(sfmt) && sfmt[p] == '.' {
+		p = skipDigits(sfmt, p+1, 2) // skip precision
+	}
+	if p < len(sfmt) && isDigit(sfmt[p]) {
+		ls.Error2("invalid format (width or precision too long)")
+	}
+	return sfmt[i:p], p
+}
+
+// skips at most n digits
+func skipDigits(s string, p, n int) int {
+	for ; n > 0 && p < len(s) && isDigit(s[p]); n-- {
+		p++
+	}
+	return p
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// splits a format spec into its flags, width and precision (-1 if absent)
+func parseSpec(spec string) (flags string, width, prec int) {
+	i := 0
+	for i < len(spec) && strings.IndexByte(formatFlags, spec[i]) >= 0 {
+		i++
+	}
+	flags = spec[:i]
+	j := i
+	for j < len(spec) && isDigit(spec[j]) {
+		j++
+	}
+	width, _ = strconv.Atoi(spec[i:j])
+	prec = -1
+	if j < len(spec) && spec[j] == '.' {
+		prec, _ = strconv.Atoi(spec[j+1:])
+	}
+	return
+}
+
+// C ignores the sign flags in unsigned conversions, fmt does not
+func unsignedSpec(spec string) string {
+	flags, _, _ := parseSpec(spec)
+	return strings.NewReplacer("+", "", " ", "").Replace(flags) + spec[len(flags):]
+}
+
+// applies width and precision to s counting bytes, as C does for '%s'
+func padString(spec, s string) string {
+	flags, width, prec := parseSpec(spec)
+	if prec >= 0 && prec < len(s) {
+		s = s[:prec]
+	}
+	return pad(flags, width, "", s)
+}
+
+// pads sign+digits to width, with zeros after the sign if the '0' flag is set
+func pad(flags string, width int, sign, digits string) string {
+	n := width - len(sign) - len(digits)
+	if n <= 0 {
+		return sign + digits
+	} else if strings.IndexByte(flags, '-') >= 0 {
+		return sign + digits + strings.Repeat(" ", n)
+	} else if strings.IndexByte(flags, '0') >= 0 {
+		return sign + strings.Repeat("0", n) + digits
+	}
+	return strings.Repeat(" ", n) + sign + digits
+}
+
+func signOf(flags string, f float64) string {
+	if math.Signbit(f) {
+		return "-"
+	} else if strings.IndexByte(flags, '+') >= 0 {
+		return "+"
+	} else if strings.IndexByte(flags, ' ') >= 0 {
+		return " "
+	}
+	return ""
+}
+
+// formats inf and nan the way C does, other values are left to fmt
+func formatFloat(spec string, conv byte, f float64) string {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		flags, width, _ := parseSpec(spec)
+		s := "inf"
+		if math.IsNaN(f) {
+			s = "nan"
+		}
+		if conv == 'E' || conv == 'F' || conv == 'G' {
+			s = strings.ToUpper(s)
+		}
+		flags = strings.ReplaceAll(flags, "0", "") // no zero padding
+		return pad(flags, width, signOf(flags, f), s)
+	}
+	if (conv == 'g' || conv == 'G') && strings.IndexByte(spec, '.') < 0 {
+		spec += ".6" // C default, fmt would use the shortest representation
+	}
+	return fmt.Sprintf("%"+spec+string(conv), f)
+}
+
+// formats f like C's '%a': 0x1.8p+1
+func formatHexFloat(spec string, conv byte, f float64) string {
+	flags, width, prec := parseSpec(spec)
+	sign := signOf(flags, f)
+	f = math.Abs(f)
+	var s string
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		s = "inf"
+		if math.IsNaN(f) {
+			s = "nan"
+		}
+		flags = strings.ReplaceAll(flags, "0", "")
+	} else {
+		s = strconv.FormatFloat(f, 'x', prec, 64) // 0x1.8p+01
+		mant, exp, _ := strings.Cut(s, "p")
+		if exp[1] == '0' && len(exp) > 2 {
+			exp = exp[:1] + exp[2:] // C uses as few exponent digits as possible
+		}
+		if strings.IndexByte(flags, '#') >= 0 && strings.IndexByte(mant, '.') < 0 {
+			mant += "."
+		}
+		if strings.IndexByte(flags, '0') >= 0 && strings.IndexByte(flags, '-') < 0 {
+			sign += "0x" // zeros go between "0x" and the digits
+			mant = mant[2:]
+		}
+		s = mant + "p" + exp
+	}
+	if conv == 'A' {
+		sign, s = strings.ToUpper(sign), strings.ToUpper(s)
+	}
+	return pad(flags, width, sign, s)
+}
+
+// string.format's '%q'
+func addLiteral(ls api.LuaState, b *strings.Builder, arg int) {
+	switch ls.Type(arg) {
+	case api.LUA_TSTRING:
+		addQuoted(b, ls.ToString(arg))
+	case api.LUA_TNUMBER:
+		if !ls.IsInteger(arg) { // float?
+			f := ls.ToNumber(arg)
+			switch {
+			case math.IsInf(f, 1):
+				b.WriteString("1e9999")
+			case math.IsInf(f, -1):
+				b.WriteString("-1e9999")
+			case math.IsNaN(f):
+				b.WriteString("(0/0)")
+			default: // to be read back exactly
+				b.WriteString(formatHexFloat("", 'a', f))
+			}
+		} else { // integers
+			n := ls.ToInteger(arg)
+			if n == math.MinInt64 { // corner case?
+				b.WriteString("0x8000000000000000") // cannot be written as a decimal
+			} else {
+				b.WriteString(strconv.FormatInt(n, 10))
+			}
+		}
+	case api.LUA_TNIL, api.LUA_TBOOLEAN:
+		b.WriteString(ls.ToStringMeta(arg))
+		ls.Pop(1)
+	default:
+		ls.ArgError(arg, "value has no literal form")
+	}
+}
+
+func addQuoted(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' || c == '\\' || c == '\n' {
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		} else if c < ' ' || c == 0x7f { // control character
+			if i+1 < len(s) && isDigit(s[i+1]) {
+				fmt.Fprintf(b, "\\%03d", c)
+			} else {
+				fmt.Fprintf(b, "\\%d", c)
+			}
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+}
@@ -0,0 +1,566 @@
+package stdlib
+
+import (
+	"luago/api"
+	"strings"
+)
+
+const (
+	maxCaptures   = 32  // maximum number of captures in a pattern
+	maxMatchCalls = 200 // maximum recursion depth of 'match'
+	capUnfinished = -1
+	capPosition   = -2
+	lEsc          = '%'
+	specials      = "^$*+?.([%-"
+)
+
+/**
+ * State of a pattern match, as in lstrlib.c. Positions are byte offsets in
+ * src and pat, and a failed match is reported as -1 instead of NULL.
+ */
+type matchState struct {
+	ls         api.LuaState
+	src        string
+	pat        string
+	level      int // total number of captures (finished or unfinished)
+	matchDepth int // control for recursive depth (to avoid stack overflow)
+	capture    [maxCaptures]struct {
+		init int
+		len  int
+	}
+}
+
+func newMatchState(ls api.LuaState, src, pat string) *matchState {
+	return &matchState{ls: ls, src: src, pat: pat}
+}
+
+func (ms *matchState) reprep() {
+	ms.level = 0
+	ms.matchDepth = maxMatchCalls
+}
+
+// byte of the pattern at p, or 0 past its end
+func (ms *matchState) patAt(p int) byte {
+	if p < len(ms.pat) {
+		return ms.pat[p]
+	}
+	return 0
+}
+
+func (ms *matchState) checkCapture(l byte) int {
+	i := int(l) - '1'
+	if i < 0 || i >= ms.level || ms.capture[i].len == capUnfinished {
+		ms.ls.Error2("invalid capture index %%%d", i+1)
+	}
+	return i
+}
+
+func (ms *matchState) captureToClose() int {
+	level := ms.level
+	for level--; level >= 0; level-- {
+		if ms.capture[level].len == capUnfinished {
+			return level
+		}
+	}
+	ms.ls.Error2("invalid pattern capture")
+	return 0
+}
+
+func (ms *matchState) classEnd(p int) int {
+	c := ms.pat[p]
+	p++
+	switch c {
+	case lEsc:
+		if p == len(ms.pat) {
+			ms.ls.Error2("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	case '[':
+		if ms.patAt(p) == '^' {
+			p++
+		}
+		for { // look for a ']'
+			if p == len(ms.pat) {
+				ms.ls.Error2("malformed pattern (missing ']')")
+			}
+			c := ms.pat[p]
+			p++
+			if c == lEsc && p < len(ms.pat) {
+				p++ // skip escapes (e.g. '%]')
+			}
+			if ms.patAt(p) == ']' {
+				return p + 1
+			}
+		}
+	default:
+		return p
+	}
+}
+
+func matchClass(c, cl byte) bool {
+	var res bool
+	switch cl | 0x20 { // tolower
+	case 'a':
+		res = isAlpha(c)
+	case 'c':
+		res = c < ' ' || c == 0x7f
+	case 'd':
+		res = '0' <= c && c <= '9'
+	case 'g':
+		res = '!' <= c && c <= '~'
+	case 'l':
+		res = 'a' <= c && c <= 'z'
+	case 'p':
+		res = isPunct(c)
+	case 's':
+		res = isSpace(c)
+	case 'u':
+		res = 'A' <= c && c <= 'Z'
+	case 'w':
+		res = isAlpha(c) || '0' <= c && c <= '9'
+	case 'x':
+		res = isXDigit(c)
+	default:
+		return cl == c
+	}
+	if 'A' <= cl && cl <= 'Z' {
+		return !res
+	}
+	return res
+}
+
+func isAlpha(c byte) bool {
+	return 'a' <= c|0x20 && c|0x20 <= 'z'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || '\t' <= c && c <= '\r'
+}
+
+func isXDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c|0x20 && c|0x20 <= 'f'
+}
+
+func isPunct(c byte) bool {
+	return '!' <= c && c <= '~' && !isAlpha(c) && !('0' <= c && c <= '9')
+}
+
+// p is the index of '[' and ec the index of the closing ']'
+func (ms *matchState) matchBracketClass(c byte, p, ec int) bool {
+	sig := true
+	if ms.pat[p+1] == '^' {
+		sig = false
+		p++ // skip the '^'
+	}
+	for p++; p < ec; p++ {
+		if ms.pat[p] == lEsc {
+			p++
+			if matchClass(c, ms.pat[p]) {
+				return sig
+			}
+		} else if ms.pat[p+1] == '-' && p+2 < ec {
+			p += 2
+			if ms.pat[p-2] <= c && c <= ms.pat[p] {
+				return sig
+			}
+		} else if ms.pat[p] == c {
+			return sig
+		}
+	}
+	return !sig
+}
+
+func (ms *matchState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+	c := ms.src[s]
+	switch ms.pat[p] {
+	case '.':
+		return true // matches any char
+	case lEsc:
+		return matchClass(c, ms.pat[p+1])
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	default:
+		return ms.pat[p] == c
+	}
+}
+
+func (ms *matchState) matchBalance(s, p int) int {
+	if p >= len(ms.pat)-1 {
+		ms.ls.Error2("malformed pattern (missing arguments to '%%b')")
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pat[p] {
+		return -1
+	}
+	b, e := ms.pat[p], ms.pat[p+1]
+	cont := 1
+	for s++; s < len(ms.src); s++ {
+		if ms.src[s] == e {
+			if cont--; cont == 0 {
+				return s + 1
+			}
+		} else if ms.src[s] == b {
+			cont++
+		}
+	}
+	return -1 // string ends out of balance
+}
+
+func (ms *matchState) maxExpand(s, p, ep int) int {
+	i := 0 // counts maximum expand for item
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	// keeps trying to match with the maximum repetitions
+	for ; i >= 0; i-- {
+		if res := ms.match(s+i, ep+1); res != -1 {
+			return res
+		}
+	}
+	return -1
+}
+
+func (ms *matchState) minExpand(s, p, ep int) int {
+	for {
+		if res := ms.match(s, ep+1); res != -1 {
+			return res
+		} else if ms.singleMatch(s, p, ep) {
+			s++ // try with one more repetition
+		} else {
+			return -1
+		}
+	}
+}
+
+func (ms *matchState) startCapture(s, p, what int) int {
+	level := ms.level
+	if level >= maxCaptures {
+		ms.ls.Error2("too many captures")
+	}
+	ms.capture[level].init = s
+	ms.capture[level].len = what
+	ms.level = level + 1
+	res := ms.match(s, p)
+	if res == -1 { // match failed?
+		ms.level-- // undo capture
+	}
+	return res
+}
+
+func (ms *matchState) endCapture(s, p int) int {
+	l := ms.captureToClose()
+	ms.capture[l].len = s - ms.capture[l].init // close capture
+	res := ms.match(s, p)
+	if res == -1 { // match failed?
+		ms.capture[l].len = capUnfinished // undo capture
+	}
+	return res
+}
+
+func (ms *matchState) matchCapture(s int, l byte) int {
+	i := ms.checkCapture(l)
+	init, n := ms.capture[i].init, ms.capture[i].len
+	if len(ms.src)-s >= n && ms.src[init:init+n] == ms.src[s:s+n] {
+		return s + n
+	}
+	return -1
+}
+
+// returns the end of the match of pat[p:] at src[s:], or -1
+func (ms *matchState) match(s, p int) int {
+	if ms.matchDepth == 0 {
+		ms.ls.Error2("pattern too complex")
+	}
+	ms.matchDepth--
+	res := ms.doMatch(s, p)
+	ms.matchDepth++
+	return res
+}
+
+func (ms *matchState) doMatch(s, p int) int {
+	for p != len(ms.pat) { // end of pattern?
+		switch ms.pat[p] {
+		case '(': // start capture
+			if ms.patAt(p+1) == ')' { // position capture?
+				return ms.startCapture(s, p+2, capPosition)
+			}
+			return ms.startCapture(s, p+1, capUnfinished)
+		case ')': // end capture
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(ms.pat) { // is the '$' the last char in pattern?
+				if s == len(ms.src) { // check end of string
+					return s
+				}
+				return -1
+			}
+		case lEsc: // escaped sequences not in the format class[*+?-]?
+			switch c := ms.patAt(p + 1); {
+			case c == 'b': // balanced string?
+				if s = ms.matchBalance(s, p+2); s == -1 {
+					return -1
+				}
+				p += 4
+				continue // return match(ms, s, p + 4)
+			case c == 'f': // frontier?
+				p += 2
+				if ms.patAt(p) != '[' {
+					ms.ls.Error2("missing '[' after '%%f' in pattern")
+				}
+				ep := ms.classEnd(p) // points to what is next
+				var previous, current byte
+				if s > 0 {
+					previous = ms.src[s-1]
+				}
+				if s < len(ms.src) {
+					current = ms.src[s]
+				}
+				if !ms.matchBracketClass(previous, p, ep-1) &&
+					ms.matchBracketClass(current, p, ep-1) {
+					p = ep
+					continue // return match(ms, s, ep)
+				}
+				return -1 // match failed
+			case '0' <= c && c <= '9': // capture results (%0-%9)?
+				if s = ms.matchCapture(s, c); s == -1 {
+					return -1
+				}
+				p += 2
+				continue // return match(ms, s, p + 2)
+			}
+		}
+		// default: pattern class plus optional suffix
+		ep := ms.classEnd(p) // points to optional suffix
+		// does not match at least once?
+		if !ms.singleMatch(s, p, ep) {
+			if c := ms.patAt(ep); c == '*' || c == '?' || c == '-' { // accept empty?
+				p = ep + 1
+				continue // return match(ms, s, ep + 1)
+			}
+			return -1 // '+' or no suffix
+		}
+		// matched once
+		switch ms.patAt(ep) { // handle optional suffix
+		case '?': // optional
+			if res := ms.match(s+1, ep+1); res != -1 {
+				return res
+			}
+			p = ep + 1
+			continue // else return match(ms, s, ep + 1)
+		case '+': // 1 or more repetitions
+			return ms.maxExpand(s+1, p, ep)
+		case '*': // 0 or more repetitions
+			return ms.maxExpand(s, p, ep)
+		case '-': // 0 or more repetitions (minimum)
+			return ms.minExpand(s, p, ep)
+		default: // no suffix
+			s++
+			p = ep
+		}
+	}
+	return s
+}
+
+// pushes capture i, or the whole match src[s:e] if there are no captures
+func (ms *matchState) pushOneCapture(i, s, e int) {
+	if i >= ms.level {
+		if i == 0 { // ms.level == 0, too
+			ms.ls.PushString(ms.src[s:e]) // add whole match
+		} else {
+			ms.ls.Error2("invalid capture index %%%d", i+1)
+		}
+		return
+	}
+	init, l := ms.capture[i].init, ms.capture[i].len
+	if l == capUnfinished {
+		ms.ls.Error2("unfinished capture")
+	}
+	if l == capPosition {
+		ms.ls.PushInteger(int64(init) + 1)
+	} else {
+		ms.ls.PushString(ms.src[init : init+l])
+	}
+}
+
+// pushes all captures, s is -1 when the whole match is not wanted
+func (ms *matchState) pushCaptures(s, e int) int {
+	nLevels := ms.level
+	if nLevels == 0 && s != -1 {
+		nLevels = 1
+	}
+	ms.ls.CheckStack2(nLevels, "too many captures")
+	for i := 0; i < nLevels; i++ {
+		ms.pushOneCapture(i, s, e)
+	}
+	return nLevels // number of strings pushed
+}
+
+// string.find (s, pattern [, init [, plain]])
+func strFind(ls api.LuaState) int {
+	return strFindAux(ls, true)
+}
+
+// string.match (s, pattern [, init])
+func strMatch(ls api.LuaState) int {
+	return strFindAux(ls, false)
+}
+
+func strFindAux(ls api.LuaState, find bool) int {
+	s := ls.CheckString(1)
+	p := ls.CheckString(2)
+	init := posRelat(ls.OptInteger(3, 1), len(s))
+	if init < 1 {
+		init = 1
+	} else if init > int64(len(s))+1 { // start after string's end?
+		ls.PushNil() // cannot find anything
+		return 1
+	}
+	// explicit request or no special characters?
+	if find && (ls.ToBoolean(4) || !strings.ContainsAny(p, specials)) {
+		// do a plain search
+		if i := strings.Index(s[init-1:], p); i >= 0 {
+			ls.PushInteger(init + int64(i))
+			ls.PushInteger(init + int64(i+len(p)) - 1)
+			return 2
+		}
+	} else {
+		s1 := int(init - 1)
+		anchor := p != "" && p[0] == '^'
+		if anchor {
+			p = p[1:] // skip anchor character
+		}
+		ms := newMatchState(ls, s, p)
+		for {
+			ms.reprep()
+			if e := ms.match(s1, 0); e != -1 {
+				if find {
+					ls.PushInteger(int64(s1) + 1) // start
+					ls.PushInteger(int64(e))      // end
+					return ms.pushCaptures(-1, 0) + 2
+				}
+				return ms.pushCaptures(s1, e)
+			}
+			s1++
+			if s1 > len(s) || anchor {
+				break
+			}
+		}
+	}
+	ls.PushNil() // not found
+	return 1
+}
+
+// string.gmatch (s, pattern)
+func strGmatch(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	p := ls.CheckString(2)
+	ms := newMatchState(ls, s, p)
+	src, lastMatch := 0, -1
+	ls.PushGoFunction(func(ls api.LuaState) int {
+		ms.ls = ls
+		for ; src <= len(s); src++ {
+			ms.reprep()
+			if e := ms.match(src, 0); e != -1 && e != lastMatch {
+				start := src
+				src, lastMatch = e, e
+				return ms.pushCaptures(start, e)
+			}
+		}
+		return 0 // not found
+	})
+	return 1
+}
+
+// string.gsub (s, pattern, repl [, n])
+func strGsub(ls api.LuaState) int {
+	src := ls.CheckString(1)
+	p := ls.CheckString(2)
+	tr := ls.Type(3) // replacement type
+	maxS := ls.OptInteger(4, int64(len(src))+1)
+	ls.ArgCheck(tr == api.LUA_TNUMBER || tr == api.LUA_TSTRING ||
+		tr == api.LUA_TFUNCTION || tr == api.LUA_TTABLE, 3,
+		"string/function/table expected")
+	anchor := p != "" && p[0] == '^'
+	if anchor {
+		p = p[1:] // skip anchor character
+	}
+	ms := newMatchState(ls, src, p)
+	var b strings.Builder
+	s, lastMatch := 0, -1
+	n := int64(0) // replacement count
+	for n < maxS {
+		ms.reprep()
+		if e := ms.match(s, 0); e != -1 && e != lastMatch { // match?
+			n++
+			ms.addValue(&b, s, e, tr) // add replacement to buffer
+			s, lastMatch = e, e
+		} else if s < len(src) { // otherwise, skip one character
+			b.WriteByte(src[s])
+			s++
+		} else {
+			break // end of subject
+		}
+		if anchor {
+			break
+		}
+	}
+	b.WriteString(src[s:])
+	ls.PushString(b.String())
+	ls.PushInteger(n) // number of substitutions
+	return 2
+}
+
+// adds the replacement string at index 3, with '%' escapes, for the match src[s:e]
+func (ms *matchState) addS(b *strings.Builder, s, e int) {
+	news := ms.ls.ToString(3)
+	for i := 0; i < len(news); i++ {
+		if news[i] != lEsc {
+			b.WriteByte(news[i])
+			continue
+		}
+		i++ // skip ESC
+		var c byte
+		if i < len(news) {
+			c = news[i]
+		}
+		if c < '0' || c > '9' {
+			if c != lEsc {
+				ms.ls.Error2("invalid use of '%c' in replacement string", lEsc)
+			}
+			b.WriteByte(c) // %%
+		} else if c == '0' {
+			b.WriteString(ms.src[s:e]) // add whole match
+		} else {
+			ms.pushOneCapture(int(c-'1'), s, e)
+			b.WriteString(ms.ls.ToStringMeta(-1)) // add capture to accumulated result
+			ms.ls.Pop(2)                          // remove capture and its string
+		}
+	}
+}
+
+func (ms *matchState) addValue(b *strings.Builder, s, e int, tr api.LuaType) {
+	ls := ms.ls
+	switch tr {
+	case api.LUA_TFUNCTION: // call the function
+		ls.PushValue(3)
+		n := ms.pushCaptures(s, e) // arguments
+		ls.Call(n, 1)              // call it
+	case api.LUA_TTABLE: // index the table
+		ms.pushOneCapture(0, s, e)
+		ls.GetTable(3)
+	default: // LUA_TNUMBER or LUA_TSTRING
+		ms.addS(b, s, e) // add value to the buffer
+		return
+	}
+	if !ls.ToBoolean(-1) { // nil or false?
+		ls.Pop(1)
+		b.WriteString(ms.src[s:e]) // keep original text
+		return
+	} else if !ls.IsString(-1) {
+		ls.Error2("invalid replacement value (a %s)", ls.TypeName2(-1))
+	}
+	b.WriteString(ls.ToString(-1)) // add result to accumulator
+	ls.Pop(1)
+}
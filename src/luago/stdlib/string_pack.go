@@ -0,0 +1,338 @@
+package stdlib
+
+import (
+	"luago/api"
+	"math"
+	"strings"
+	"unsafe"
+)
+
+const (
+	maxIntSize = 16 // maximum size for the binary representation of an integer
+	szInt      = 8  // size of a lua_Integer
+	maxAlign   = 8  // maximum alignment for '!'
+	packPad    = 0  // value used for padding
+)
+
+var nativeLittle = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// options for pack/unpack
+type kOption int
+
+const (
+	kInt       kOption = iota // signed integers
+	kUint                     // unsigned integers
+	kFloat                    // floating-point numbers
+	kChar                     // fixed-length strings
+	kString                   // strings with prefixed length
+	kZstr                     // zero-terminated strings
+	kPadding                  // padding
+	kPaddAlign                // padding for alignment
+	kNop                      // no-op (configuration or spaces)
+)
+
+// information to pack/unpack stuff
+type packHeader struct {
+	ls       api.LuaState
+	fmt      string
+	pos      int // next option in fmt
+	isLittle bool
+	maxAlign int
+}
+
+func newPackHeader(ls api.LuaState, fmt string) *packHeader {
+	return &packHeader{ls: ls, fmt: fmt, isLittle: nativeLittle, maxAlign: 1}
+}
+
+func (h *packHeader) more() bool {
+	return h.pos < len(h.fmt)
+}
+
+// reads an optional size, returning df if there is none
+func (h *packHeader) getNum(df int) int {
+	if !h.more() || !isDigit(h.fmt[h.pos]) { // no number?
+		return df // return default value
+	}
+	a := 0
+	for h.more() && isDigit(h.fmt[h.pos]) && a <= (math.MaxInt32-9)/10 {
+		a = a*10 + int(h.fmt[h.pos]-'0')
+		h.pos++
+	}
+	return a
+}
+
+// reads an optional size and checks that it is a valid integer size
+func (h *packHeader) getNumLimit(df int) int {
+	sz := h.getNum(df)
+	if sz > maxIntSize || sz <= 0 {
+		h.ls.Error2("integral size (%d) out of limits [1,%d]", sz, maxIntSize)
+	}
+	return sz
+}
+
+// reads an option and returns its kind and size
+func (h *packHeader) getOption() (kOption, int) {
+	opt := h.fmt[h.pos]
+	h.pos++
+	switch opt {
+	case 'b':
+		return kInt, 1
+	case 'B':
+		return kUint, 1
+	case 'h':
+		return kInt, 2
+	case 'H':
+		return kUint, 2
+	case 'l', 'j':
+		return kInt, 8
+	case 'L', 'J', 'T':
+		return kUint, 8
+	case 'f':
+		return kFloat, 4
+	case 'd', 'n':
+		return kFloat, 8
+	case 'i':
+		return kInt, h.getNumLimit(4)
+	case 'I':
+		return kUint, h.getNumLimit(4)
+	case 's':
+		return kString, h.getNumLimit(8)
+	case 'c':
+		size := h.getNum(-1)
+		if size == -1 {
+			h.ls.Error2("missing size for format option 'c'")
+		}
+		return kChar, size
+	case 'z':
+		return kZstr, 0
+	case 'x':
+		return kPadding, 1
+	case 'X':
+		return kPaddAlign, 0
+	case ' ':
+	case '<':
+		h.isLittle = true
+	case '>':
+		h.isLittle = false
+	case '=':
+		h.isLittle = nativeLittle
+	case '!':
+		h.maxAlign = h.getNumLimit(maxAlign)
+	default:
+		h.ls.Error2("invalid format option '%c'", opt)
+	}
+	return kNop, 0
+}
+
+/**
+ * Reads the next option and returns its kind, its size and the padding
+ * needed to align it, given the current total size.
+ */
+func (h *packHeader) getDetails(totalSize int) (opt kOption, size, nToAlign int) {
+	opt, size = h.getOption()
+	align := size          // usually, alignment follows size
+	if opt == kPaddAlign { // 'X' gets alignment from following option
+		var next kOption
+		if h.more() {
+			next, align = h.getOption()
+		}
+		if next == kChar || align == 0 {
+			h.ls.ArgError(1, "invalid next option for option 'X'")
+		}
+	}
+	if align <= 1 || opt == kChar { // need no alignment?
+		return opt, size, 0
+	}
+	if align > h.maxAlign { // enforce maximum alignment
+		align = h.maxAlign
+	}
+	if align&(align-1) != 0 { // is 'align' not a power of 2?
+		h.ls.ArgError(1, "format asks for alignment not power of 2")
+	}
+	return opt, size, (align - totalSize&(align-1)) & (align - 1)
+}
+
+// packs an integer with size bytes, sign-extending it past 8 bytes
+func packInt(b *strings.Builder, n uint64, isLittle bool, size int, neg bool) {
+	buf := make([]byte, size)
+	for i := 0; i < size; i++ {
+		var c byte
+		if i < szInt {
+			c = byte(n >> (8 * i))
+		} else if neg {
+			c = 0xff
+		}
+		if isLittle {
+			buf[i] = c
+		} else {
+			buf[size-1-i] = c
+		}
+	}
+	b.Write(buf)
+}
+
+func unpackInt(ls api.LuaState, str string, isLittle bool, size int, isSigned bool) int64 {
+	at := func(i int) byte {
+		if isLittle {
+			return str[i]
+		}
+		return str[size-1-i]
+	}
+	limit := size
+	if limit > szInt {
+		limit = szInt
+	}
+	var res uint64
+	for i := limit - 1; i >= 0; i-- {
+		res = res<<8 | uint64(at(i))
+	}
+	if size < szInt { // real size smaller than lua_Integer?
+		if isSigned { // needs sign extension?
+			mask := uint64(1) << (size*8 - 1)
+			res = (res ^ mask) - mask // do sign extension
+		}
+	} else if size > szInt { // must check unread bytes
+		var mask byte
+		if isSigned && int64(res) < 0 {
+			mask = 0xff
+		}
+		for i := limit; i < size; i++ {
+			if at(i) != mask {
+				ls.Error2("%d-byte integer does not fit into Lua Integer", size)
+			}
+		}
+	}
+	return int64(res)
+}
+
+// string.pack (fmt, v1, v2, ···)
+func strPack(ls api.LuaState) int {
+	h := newPackHeader(ls, ls.CheckString(1))
+	var b strings.Builder
+	arg := 1       // current argument to pack
+	totalSize := 0 // accumulate total size of result
+	for h.more() {
+		opt, size, nToAlign := h.getDetails(totalSize)
+		totalSize += nToAlign + size
+		for ; nToAlign > 0; nToAlign-- {
+			b.WriteByte(packPad) // fill alignment
+		}
+		arg++
+		switch opt {
+		case kInt: // signed integers
+			n := ls.CheckInteger(arg)
+			if size < szInt { // need overflow check?
+				lim := int64(1) << (size*8 - 1)
+				ls.ArgCheck(-lim <= n && n < lim, arg, "integer overflow")
+			}
+			packInt(&b, uint64(n), h.isLittle, size, n < 0)
+		case kUint: // unsigned integers
+			n := ls.CheckInteger(arg)
+			if size < szInt { // need overflow check?
+				ls.ArgCheck(uint64(n) < uint64(1)<<(size*8), arg, "unsigned overflow")
+			}
+			packInt(&b, uint64(n), h.isLittle, size, false)
+		case kFloat: // floating-point options
+			f := ls.CheckNumber(arg)
+			if size == 4 {
+				packInt(&b, uint64(math.Float32bits(float32(f))), h.isLittle, size, false)
+			} else {
+				packInt(&b, math.Float64bits(f), h.isLittle, size, false)
+			}
+		case kChar: // fixed-size string
+			s := ls.CheckString(arg)
+			ls.ArgCheck(len(s) <= size, arg, "string longer than given size")
+			b.WriteString(s)
+			for i := len(s); i < size; i++ { // pad extra space
+				b.WriteByte(packPad)
+			}
+		case kString: // strings with length count
+			s := ls.CheckString(arg)
+			ls.ArgCheck(size >= 8 || uint64(len(s)) < uint64(1)<<(size*8),
+				arg, "string length does not fit in given size")
+			packInt(&b, uint64(len(s)), h.isLittle, size, false) // pack length
+			b.WriteString(s)
+			totalSize += len(s)
+		case kZstr: // zero-terminated string
+			s := ls.CheckString(arg)
+			ls.ArgCheck(strings.IndexByte(s, 0) < 0, arg, "string contains zeros")
+			b.WriteString(s)
+			b.WriteByte(0) // add zero at the end
+			totalSize += len(s) + 1
+		case kPadding:
+			b.WriteByte(packPad)
+			arg-- // undo increment
+		case kPaddAlign, kNop:
+			arg-- // undo increment
+		}
+	}
+	ls.PushString(b.String())
+	return 1
+}
+
+// string.packsize (fmt)
+func strPackSize(ls api.LuaState) int {
+	h := newPackHeader(ls, ls.CheckString(1))
+	totalSize := 0 // accumulate total size of result
+	for h.more() {
+		opt, size, nToAlign := h.getDetails(totalSize)
+		size += nToAlign
+		ls.ArgCheck(totalSize <= math.MaxInt32-size, 1, "format result too large")
+		totalSize += size
+		if opt == kString || opt == kZstr {
+			ls.ArgError(1, "variable-length format")
+		}
+	}
+	ls.PushInteger(int64(totalSize))
+	return 1
+}
+
+// string.unpack (fmt, s [, pos])
+func strUnpack(ls api.LuaState) int {
+	h := newPackHeader(ls, ls.CheckString(1))
+	data := ls.CheckString(2)
+	ld := len(data)
+	pos := posRelat(ls.OptInteger(3, 1), ld) - 1
+	ls.ArgCheck(0 <= pos && pos <= int64(ld), 3, "initial position out of string")
+	n := 0 // number of results
+	for h.more() {
+		opt, size, nToAlign := h.getDetails(int(pos))
+		if int64(nToAlign+size) > int64(ld)-pos {
+			ls.ArgError(2, "data string too short")
+		}
+		pos += int64(nToAlign) // skip alignment
+		ls.CheckStack2(2, "too many results")
+		n++
+		switch opt {
+		case kInt, kUint:
+			ls.PushInteger(unpackInt(ls, data[pos:], h.isLittle, size, opt == kInt))
+		case kFloat:
+			bits := uint64(unpackInt(ls, data[pos:], h.isLittle, size, false))
+			if size == 4 {
+				ls.PushNumber(float64(math.Float32frombits(uint32(bits))))
+			} else {
+				ls.PushNumber(math.Float64frombits(bits))
+			}
+		case kChar:
+			ls.PushString(data[pos : pos+int64(size)])
+		case kString:
+			l := uint64(unpackInt(ls, data[pos:], h.isLittle, size, false))
+			ls.ArgCheck(l <= uint64(int64(ld)-pos-int64(size)), 2, "data string too short")
+			ls.PushString(data[pos+int64(size) : pos+int64(size)+int64(l)])
+			pos += int64(l) // skip string
+		case kZstr:
+			l := strings.IndexByte(data[pos:], 0)
+			ls.ArgCheck(l >= 0, 2, "unfinished string for format 'z'")
+			ls.PushString(data[pos : pos+int64(l)])
+			pos += int64(l) + 1 // skip string plus final '\0'
+		case kPaddAlign, kPadding, kNop:
+			n-- // undo increment
+		}
+		pos += int64(size)
+	}
+	ls.PushInteger(pos + 1) // next position
+	return n + 1
+}
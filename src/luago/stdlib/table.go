@@ -0,0 +1,393 @@
+package stdlib
+
+import (
+	"luago/api"
+	"math"
+	"math/bits"
+	"strings"
+)
+
+// operations that an argument must support to be used as a table
+const (
+	tabR  = 1           // read
+	tabW  = 2           // write
+	tabL  = 4           // length
+	tabRW = tabR | tabW // read/write
+)
+
+var tabFuncs = api.FuncReg{
+	"concat": tabConcat,
+	"insert": tabInsert,
+	"move":   tabMove,
+	"pack":   tabPack,
+	"remove": tabRemove,
+	"sort":   tabSort,
+	"unpack": tabUnpack,
+}
+
+// installs the `table` table in the global table
+func OpenTable(ls api.LuaState) {
+	ls.NewLib(tabFuncs)
+	ls.SetGlobal("table")
+}
+
+func checkField(ls api.LuaState, key string, n int) bool {
+	ls.PushString(key)
+	return ls.RawGet(-n) != api.LUA_TNIL
+}
+
+/**
+ * Checks that the argument is a table, or that it has a metatable with the
+ * metamethods needed by the given operations.
+ */
+func checkTab(ls api.LuaState, arg, what int) {
+	if ls.Type(arg) != api.LUA_TTABLE { // is it not a table?
+		n := 1                     // number of elements to pop
+		ok := ls.GetMetatable(arg) // must have metatable
+		if ok && what&tabR != 0 {
+			n++
+			ok = checkField(ls, "__index", n)
+		}
+		if ok && what&tabW != 0 {
+			n++
+			ok = checkField(ls, "__newindex", n)
+		}
+		if ok && what&tabL != 0 {
+			n++
+			ok = checkField(ls, "__len", n)
+		}
+		if ok {
+			ls.Pop(n) // pop metatable and tested metamethods
+		} else {
+			ls.CheckType(arg, api.LUA_TTABLE) // force an error
+		}
+	}
+}
+
+func auxGetN(ls api.LuaState, arg, what int) int64 {
+	checkTab(ls, arg, what|tabL)
+	return ls.Len2(arg)
+}
+
+// table.insert (list, [pos,] value)
+func tabInsert(ls api.LuaState) int {
+	e := auxGetN(ls, 1, tabRW) + 1 // first empty element
+	var pos int64                  // where to insert new element
+	switch ls.GetTop() {
+	case 2: // called with only 2 arguments
+		pos = e // insert new element at the end
+	case 3:
+		pos = ls.CheckInteger(2) // 2nd argument is the position
+		ls.ArgCheck(1 <= pos && pos <= e, 2, "position out of bounds")
+		for i := e; i > pos; i-- { // move up elements
+			ls.GetI(1, i-1)
+			ls.SetI(1, i) // t[i] = t[i - 1]
+		}
+	default:
+		return ls.Error2("wrong number of arguments to 'insert'")
+	}
+	ls.SetI(1, pos) // t[pos] = v
+	return 0
+}
+
+// table.remove (list [, pos])
+func tabRemove(ls api.LuaState) int {
+	size := auxGetN(ls, 1, tabRW)
+	pos := ls.OptInteger(2, size)
+	if pos != size { // validate 'pos' if given
+		ls.ArgCheck(1 <= pos && pos <= size+1, 1, "position out of bounds")
+	}
+	ls.GetI(1, pos) // result = t[pos]
+	for ; pos < size; pos++ {
+		ls.GetI(1, pos+1)
+		ls.SetI(1, pos) // t[pos] = t[pos + 1]
+	}
+	ls.PushNil()
+	ls.SetI(1, pos) // t[pos] = nil
+	return 1
+}
+
+// table.move (a1, f, e, t [,a2])
+func tabMove(ls api.LuaState) int {
+	f := ls.CheckInteger(2)
+	e := ls.CheckInteger(3)
+	t := ls.CheckInteger(4)
+	tt := 1 // destination table
+	if !ls.IsNoneOrNil(5) {
+		tt = 5
+	}
+	checkTab(ls, 1, tabR)
+	checkTab(ls, tt, tabW)
+	if e >= f { // otherwise, nothing to move
+		ls.ArgCheck(f > 0 || e < math.MaxInt64+f, 3, "too many elements to move")
+		n := e - f + 1 // number of elements to move
+		ls.ArgCheck(t <= math.MaxInt64-n+1, 4, "destination wrap around")
+		if t > e || t <= f || (tt != 1 && !ls.Compare(1, tt, api.LUA_OPEQ)) {
+			for i := int64(0); i < n; i++ {
+				ls.GetI(1, f+i)
+				ls.SetI(tt, t+i)
+			}
+		} else {
+			for i := n - 1; i >= 0; i-- {
+				ls.GetI(1, f+i)
+				ls.SetI(tt, t+i)
+			}
+		}
+	}
+	ls.PushValue(tt) // return destination table
+	return 1
+}
+
+// table.concat (list [, sep [, i [, j]]])
+func tabConcat(ls api.LuaState) int {
+	last := auxGetN(ls, 1, tabR)
+	sep := ls.OptString(2, "")
+	i := ls.OptInteger(3, 1)
+	last = ls.OptInteger(4, last)
+	var b strings.Builder
+	for ; i < last; i++ {
+		addField(ls, &b, i)
+		b.WriteString(sep)
+	}
+	if i == last { // add last value (if interval was not empty)
+		addField(ls, &b, i)
+	}
+	ls.PushString(b.String())
+	return 1
+}
+
+func addField(ls api.LuaState, b *strings.Builder, i int64) {
+	ls.GetI(1, i)
+	if !ls.IsString(-1) {
+		ls.Error2("invalid value (at index %d) in table for 'concat'", i)
+	}
+	b.WriteString(ls.ToString(-1))
+	ls.Pop(1)
+}
+
+// table.pack (···)
+func tabPack(ls api.LuaState) int {
+	n := ls.GetTop()          // number of elements to pack
+	ls.CreateTable(n, 1)      // create result table
+	ls.Insert(1)              // put it at index 1
+	for i := n; i >= 1; i-- { // assign elements
+		ls.SetI(1, int64(i))
+	}
+	ls.PushInteger(int64(n))
+	ls.SetField(1, "n") // t.n = number of elements
+	return 1            // return table
+}
+
+// table.unpack (list [, i [, j]])
+func tabUnpack(ls api.LuaState) int {
+	i := ls.OptInteger(2, 1)
+	var e int64
+	if ls.IsNoneOrNil(3) {
+		e = ls.Len2(1)
+	} else {
+		e = ls.CheckInteger(3)
+	}
+	if i > e {
+		return 0 // empty range
+	}
+	n := uint64(e) - uint64(i) // number of elements minus 1 (avoid overflows)
+	if n >= math.MaxInt32 || !ls.CheckStack(int(n+1)) {
+		return ls.Error2("too many results to unpack")
+	}
+	for ; i < e; i++ { // push arg[i..e - 1] (to avoid overflows)
+		ls.GetI(1, i)
+	}
+	ls.GetI(1, e) // push last element
+	return int(n + 1)
+}
+
+/**
+ * table.sort (list [, comp])
+ *
+ * Quicksort with median-of-three pivots, as in ltablib.c, that switches to
+ * heapsort when the recursion gets too deep (introsort). Elements are read
+ * and written with GetI/SetI, so metamethods are respected.
+ */
+func tabSort(ls api.LuaState) int {
+	n := auxGetN(ls, 1, tabRW)
+	if n > 1 { // non-trivial interval?
+		ls.ArgCheck(n < math.MaxInt32, 1, "array too big")
+		if !ls.IsNoneOrNil(2) { // is there a 2nd argument?
+			ls.CheckType(2, api.LUA_TFUNCTION) // must be a function
+		}
+		ls.SetTop(2) // make sure there are two arguments
+		s := sorter{ls}
+		s.auxSort(1, n, 2*bits.Len64(uint64(n)))
+	}
+	return 0
+}
+
+type sorter struct {
+	ls api.LuaState
+}
+
+// does the element at index a come before the one at index b?
+func (s sorter) comp(a, b int) bool {
+	ls := s.ls
+	if ls.IsNil(2) { // no function?
+		return ls.Compare(a, b, api.LUA_OPLT) // a < b
+	}
+	a, b = ls.AbsIndex(a), ls.AbsIndex(b)
+	ls.PushValue(2) // push function
+	ls.PushValue(a) // first argument
+	ls.PushValue(b) // second argument
+	ls.Call(2, 1)   // call function
+	res := ls.ToBoolean(-1)
+	ls.Pop(1)
+	return res
+}
+
+// pops two values and stores them at t[i] and t[j]
+func (s sorter) set2(i, j int64) {
+	s.ls.SetI(1, i)
+	s.ls.SetI(1, j)
+}
+
+/**
+ * Does the partition: pivot P is at the top of the stack. Precondition:
+ * a[lo] <= P == a[up-1] <= a[up], so it only needs to do the partition
+ * from lo + 1 to up - 2. Pos-condition: a[lo .. i - 1] <= a[i] == P <=
+ * a[i + 1 .. up], returns 'i'.
+ */
+func (s sorter) partition(lo, up int64) int64 {
+	ls := s.ls
+	i := lo     // will be incremented before first use
+	j := up - 1 // will be decremented before first use
+	for {       // loop invariant: a[lo .. i] <= P <= a[j .. up], a[up - 1] == P
+		// next loop: repeat ++i while a[i] < P
+		for {
+			i++
+			ls.GetI(1, i)
+			if !s.comp(-1, -2) {
+				break
+			}
+			if i == up-1 { // a[i] < P  but a[up - 1] == P  ??
+				ls.Error2("invalid order function for sorting")
+			}
+			ls.Pop(1) // remove a[i]
+		}
+		// after the loop, a[i] >= P and a[lo .. i - 1] < P
+		// next loop: repeat --j while P < a[j]
+		for {
+			j--
+			ls.GetI(1, j)
+			if !s.comp(-3, -1) {
+				break
+			}
+			if j < i { // j < i  but  a[j] > P ??
+				ls.Error2("invalid order function for sorting")
+			}
+			ls.Pop(1) // remove a[j]
+		}
+		// after the loop, a[j] <= P and a[j + 1 .. up] >= P
+		if j < i { // no elements to be exchanged?
+			ls.Pop(1) // pop a[j]
+			// swap pivot (a[up - 1]) with a[i] to satisfy pos-condition
+			s.set2(up-1, i)
+			return i
+		}
+		// otherwise, swap a[i] - a[j] to restore invariant and repeat
+		s.set2(i, j)
+	}
+}
+
+func (s sorter) auxSort(lo, up int64, depth int) {
+	ls := s.ls
+	for lo < up { // loop for tail recursion
+		if depth == 0 { // too many unbalanced partitions?
+			s.heapSort(lo, up)
+			return
+		}
+		depth--
+		// sort elements 'lo', 'p', and 'up'
+		ls.GetI(1, lo)
+		ls.GetI(1, up)
+		if s.comp(-1, -2) { // a[up] < a[lo]?
+			s.set2(lo, up) // swap a[lo] - a[up]
+		} else {
+			ls.Pop(2) // remove both values
+		}
+		if up-lo == 1 { // only 2 elements?
+			break // already sorted
+		}
+		p := lo + (up-lo)/2 // middle element is a good pivot
+		ls.GetI(1, p)
+		ls.GetI(1, lo)
+		if s.comp(-2, -1) { // a[p] < a[lo]?
+			s.set2(p, lo) // swap a[p] - a[lo]
+		} else {
+			ls.Pop(1) // remove second element
+			ls.GetI(1, up)
+			if s.comp(-1, -2) { // a[up] < a[p]?
+				s.set2(p, up) // swap up - p
+			} else {
+				ls.Pop(2) // clean stack
+			}
+		}
+		if up-lo == 2 { // only 3 elements?
+			break // already sorted
+		}
+		ls.GetI(1, p)    // get median (Pivot)
+		ls.PushValue(-1) // push Pivot
+		ls.GetI(1, up-1) // push a[up - 1]
+		s.set2(p, up-1)  // a[p] = a[up - 1]; a[up - 1] = a[p]
+		p = s.partition(lo, up)
+		// a[lo .. p - 1] <= a[p] == P <= a[p + 1 .. up]
+		if p-lo < up-p { // lower interval is shorter?
+			s.auxSort(lo, p-1, depth) // call recursively for lower interval
+			lo = p + 1                // tail call for [p + 1 .. up] (upper interval)
+		} else {
+			s.auxSort(p+1, up, depth) // call recursively for upper interval
+			up = p - 1                // tail call for [lo .. p - 1]  (lower interval)
+		}
+	}
+}
+
+// sorts a[lo .. up] in place with heapsort
+func (s sorter) heapSort(lo, up int64) {
+	n := up - lo + 1
+	for i := (n - 2) / 2; i >= 0; i-- {
+		s.siftDown(lo, i, n)
+	}
+	for i := n - 1; i > 0; i-- {
+		s.swap(lo, lo+i)
+		s.siftDown(lo, 0, i)
+	}
+}
+
+// restores the heap property of the n-element heap at a[lo ..] below root
+func (s sorter) siftDown(lo, root, n int64) {
+	for {
+		child := 2*root + 1
+		if child >= n {
+			return
+		}
+		if child+1 < n && s.less(lo+child, lo+child+1) {
+			child++
+		}
+		if !s.less(lo+root, lo+child) {
+			return
+		}
+		s.swap(lo+root, lo+child)
+		root = child
+	}
+}
+
+func (s sorter) less(i, j int64) bool {
+	s.ls.GetI(1, i)
+	s.ls.GetI(1, j)
+	res := s.comp(-2, -1)
+	s.ls.Pop(2)
+	return res
+}
+
+func (s sorter) swap(i, j int64) {
+	s.ls.GetI(1, i)
+	s.ls.GetI(1, j)
+	s.set2(i, j)
+}
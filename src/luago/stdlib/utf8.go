@@ -0,0 +1,218 @@
+package stdlib
+
+import (
+	"luago/api"
+	"luago/number"
+	"strings"
+)
+
+const maxUnicode = 0x10FFFF
+
+// pattern which matches exactly one UTF-8 byte sequence
+const utf8Patt = "[\x00-\x7F\xC2-\xF4][\x80-\xBF]*"
+
+var utf8Funcs = api.FuncReg{
+	"offset":    byteOffset,
+	"codepoint": codePoint,
+	"char":      utfChar,
+	"len":       utfLen,
+	"codes":     iterCodes,
+}
+
+// installs the `utf8` table in the global table
+func OpenUTF8(ls api.LuaState) {
+	ls.NewLib(utf8Funcs)
+	ls.PushString(utf8Patt)
+	ls.SetField(-2, "charpattern")
+	ls.SetGlobal("utf8")
+}
+
+// reports whether s[i] is a continuation byte; the end of s is not
+func isCont(s string, i int64) bool {
+	return i < int64(len(s)) && s[i]&0xC0 == 0x80
+}
+
+/**
+ * Decodes one UTF-8 sequence starting at s[i], returning its code point and
+ * the index of the next byte, or -1 if the sequence is invalid.
+ */
+func utf8Decode(s string, i int64) (rune, int64) {
+	limits := [...]uint32{0xFF, 0x7F, 0x7FF, 0xFFFF}
+	c := uint32(s[i])
+	res := uint32(0) // final result
+	if c < 0x80 {    // ascii?
+		res = c
+	} else {
+		count := 0                   // to count number of continuation bytes
+		for ; c&0x40 != 0; c <<= 1 { // still have continuation bytes?
+			count++
+			if !isCont(s, i+int64(count)) { // not a continuation byte?
+				return 0, -1 // invalid byte sequence
+			}
+			res = res<<6 | uint32(s[i+int64(count)]&0x3F) // add lower 6 bits from cont. byte
+		}
+		res |= (c & 0x7F) << (count * 5) // add first byte
+		if count > 3 || res > maxUnicode || res <= limits[count] {
+			return 0, -1 // invalid byte sequence
+		}
+		i += int64(count) // skip continuation bytes read
+	}
+	return rune(res), i + 1 // +1 to include first byte
+}
+
+// utf8.len (s [, i [, j]])
+// returns the number of UTF-8 characters that start between positions
+// i and j, or nil plus the position of the first invalid byte
+func utfLen(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	l := int64(len(s))
+	posi := posRelat(ls.OptInteger(2, 1), len(s))
+	posj := posRelat(ls.OptInteger(3, -1), len(s))
+	ls.ArgCheck(1 <= posi && posi-1 <= l, 2, "initial position out of string")
+	posi--
+	posj--
+	ls.ArgCheck(posj < l, 3, "final position out of string")
+	n := int64(0)
+	for posi <= posj {
+		_, next := utf8Decode(s, posi)
+		if next < 0 { // conversion error?
+			ls.PushNil()             // return nil ...
+			ls.PushInteger(posi + 1) // ... and current position
+			return 2
+		}
+		posi = next
+		n++
+	}
+	ls.PushInteger(n)
+	return 1
+}
+
+// utf8.codepoint (s [, i [, j]])
+// returns the code points of all characters that start in s[i:j]
+func codePoint(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	posi := posRelat(ls.OptInteger(2, 1), len(s))
+	pose := posRelat(ls.OptInteger(3, posi), len(s))
+	ls.ArgCheck(posi >= 1, 2, "out of range")
+	ls.ArgCheck(pose <= int64(len(s)), 3, "out of range")
+	if posi > pose {
+		return 0 // empty interval; return no values
+	}
+	if pose-posi >= maxStringSize { // (int64 -> int) overflow?
+		return ls.Error2("string slice too long")
+	}
+	ls.CheckStack2(int(pose-posi)+1, "string slice too long")
+	n := 0
+	for i := posi - 1; i < pose; {
+		code, next := utf8Decode(s, i)
+		if next < 0 {
+			return ls.Error2("invalid UTF-8 code")
+		}
+		ls.PushInteger(int64(code))
+		i = next
+		n++
+	}
+	return n
+}
+
+func utfCharAt(ls api.LuaState, arg int) string {
+	code := ls.CheckInteger(arg)
+	ls.ArgCheck(0 <= code && code <= maxUnicode, arg, "value out of range")
+	return number.UTF8Esc(uint32(code))
+}
+
+// utf8.char (···)
+// receives zero or more integers and returns a string with their encodings
+func utfChar(ls api.LuaState) int {
+	n := ls.GetTop() // number of arguments
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		b.WriteString(utfCharAt(ls, i))
+	}
+	ls.PushString(b.String())
+	return 1
+}
+
+// utf8.offset (s, n [, i])
+// returns the position where the n-th character (counting from
+// position i) starts; 0 means the character containing position i
+func byteOffset(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	l := int64(len(s))
+	n := ls.CheckInteger(2)
+	posi := int64(1)
+	if n < 0 {
+		posi = l + 1
+	}
+	posi = posRelat(ls.OptInteger(3, posi), len(s))
+	ls.ArgCheck(1 <= posi && posi-1 <= l, 3, "position out of range")
+	posi--
+	if n == 0 {
+		// find beginning of current byte sequence
+		for posi > 0 && isCont(s, posi) {
+			posi--
+		}
+	} else {
+		if isCont(s, posi) {
+			return ls.Error2("initial position is a continuation byte")
+		}
+		if n < 0 {
+			for n < 0 && posi > 0 { // move back
+				posi-- // find beginning of previous character
+				for posi > 0 && isCont(s, posi) {
+					posi--
+				}
+				n++
+			}
+		} else {
+			n-- // do not move for 1st character
+			for n > 0 && posi < l {
+				posi++ // find beginning of next character
+				for isCont(s, posi) {
+					posi++ // (cannot pass final '\0')
+				}
+				n--
+			}
+		}
+	}
+	if n == 0 { // did it find given character?
+		ls.PushInteger(posi + 1)
+	} else { // no such character
+		ls.PushNil()
+	}
+	return 1
+}
+
+func iterAux(ls api.LuaState) int {
+	s := ls.CheckString(1)
+	l := int64(len(s))
+	n, _ := ls.ToIntegerX(2)
+	n--        // byte before the current character
+	if n < 0 { // first iteration?
+		n = 0 // start from here
+	} else if n < l {
+		n++ // skip current byte
+		for isCont(s, n) {
+			n++ // and its continuations
+		}
+	}
+	if n >= l {
+		return 0 // no more codepoints
+	}
+	code, next := utf8Decode(s, n)
+	if next < 0 || isCont(s, next) {
+		return ls.Error2("invalid UTF-8 code")
+	}
+	ls.PushInteger(n + 1)
+	ls.PushInteger(int64(code))
+	return 2
+}
+
+// utf8.codes (s)
+func iterCodes(ls api.LuaState) int {
+	ls.CheckString(1)
+	ls.PushGoFunction(iterAux)
+	ls.PushValue(1)
+	ls.PushInteger(0)
+	return 3
+}
@@ -0,0 +1,192 @@
+package vfs
+
+import (
+	"io"
+	"io/fs"
+	"luago/api"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+/**
+ * MemFS is a flat in-memory file system: every cleaned name is a file and
+ * there are no directories. Like on Unix, a removed or renamed file stays
+ * usable through the handles already opened on it.
+ */
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memData
+}
+
+// the contents of a file, shared by its handles
+type memData struct {
+	data []byte
+}
+
+// an open handle on a MemFS file
+type memFile struct {
+	fsys   *MemFS
+	name   string
+	d      *memData
+	flag   int
+	pos    int64
+	closed bool
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{files: map[string]*memData{}}
+}
+
+// creates or replaces the file name with the given contents
+func (m *MemFS) WriteFile(name string, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[path.Clean(name)] = &memData{append([]byte(nil), data...)}
+}
+
+// returns a copy of the contents of the file name
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.files[path.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.ENOENT}
+	}
+	return append([]byte(nil), d.data...), nil
+}
+
+// returns the sorted names of all files
+func (m *MemFS) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (api.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := path.Clean(name)
+	d, ok := m.files[key]
+	switch {
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EEXIST}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.ENOENT}
+	case !ok:
+		d = &memData{}
+		m.files[key] = d
+	case flag&os.O_TRUNC != 0:
+		d.data = nil
+	}
+	return &memFile{fsys: m, name: name, d: d, flag: flag}, nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := path.Clean(name)
+	if _, ok := m.files[key]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOENT}
+	}
+	delete(m.files, key)
+	return nil
+}
+
+func (m *MemFS) Rename(oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldKey, newKey := path.Clean(oldName), path.Clean(newName)
+	d, ok := m.files[oldKey]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: syscall.ENOENT}
+	}
+	delete(m.files, oldKey)
+	m.files[newKey] = d
+	return nil
+}
+
+// fails if the handle is closed or was not opened for reading or writing as asked
+func (f *memFile) check(op string, read, write bool) error {
+	if f.closed {
+		return &fs.PathError{Op: op, Path: f.name, Err: os.ErrClosed}
+	}
+	readable := f.flag&os.O_WRONLY == 0
+	writable := f.flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if read && !readable || write && !writable {
+		return &fs.PathError{Op: op, Path: f.name, Err: syscall.EBADF}
+	}
+	return nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	if err := f.check("read", true, false); err != nil {
+		return 0, err
+	}
+	if f.pos >= int64(len(f.d.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.d.data[f.pos:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	if err := f.check("write", false, true); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.pos = int64(len(f.d.data))
+	}
+	if end := f.pos + int64(len(p)); end > int64(len(f.d.data)) {
+		if end > int64(cap(f.d.data)) {
+			data := make([]byte, len(f.d.data), 2*end)
+			copy(data, f.d.data)
+			f.d.data = data
+		}
+		f.d.data = f.d.data[:end] // the gap left by a seek past the end reads as zeros
+	}
+	n := copy(f.d.data[f.pos:], p)
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	if err := f.check("seek", false, false); err != nil {
+		return 0, err
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += int64(len(f.d.data))
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *memFile) Close() error {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	if err := f.check("close", false, false); err != nil {
+		return err
+	}
+	f.closed = true
+	return nil
+}
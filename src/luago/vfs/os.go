@@ -0,0 +1,28 @@
+package vfs
+
+import (
+	"io/fs"
+	"luago/api"
+	"os"
+)
+
+// the file system of the host, used by default
+var OS api.FileSystem = osFS{}
+
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm fs.FileMode) (api.File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err // avoid a non-nil interface holding a nil *os.File
+	}
+	return f, nil
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
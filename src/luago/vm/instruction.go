@@ -6,6 +6,7 @@ import (
 
 const MAXARG_Bx = (1<<18) - 1
 const MAXARG_sBx = MAXARG_Bx >> 1
+const MAXARG_C = (1<<9) - 1
 
 const LFIELDS_PER_FLUSH = 50
 
@@ -55,6 +56,11 @@ func (inst Instruction) CMode() byte {
 	return opcodes[inst.Opcode()].argCMode
 }
 
+// does the instruction set register A?
+func (inst Instruction) SetsA() bool {
+	return opcodes[inst.Opcode()].setAFlag != 0
+}
+
 func (inst Instruction) Execute(vm api.LuaVM) {
 	switch inst.Opcode() {
 	case OP_MOVE: // R(A) := R(B)
@@ -241,16 +247,15 @@ func (inst Instruction) Execute(vm api.LuaVM) {
 		a += 1
 
 		nArgs := _preCall(a, b, vm)
-		vm.Call(nArgs, c-1)
-		_postCall(a, c, vm)
+		if vm.PreCall(nArgs, c-1) { // a Go function, already run?
+			_postCall(a, c, vm)
+		} // else the caller of Execute runs the Lua function, then calls FinishCall
 	case OP_TAILCALL: // return R(A)(R(A+1), ..., R(A+B-1))
 		a, b, _ := inst.ABC()
 		a += 1
 
-		// TODO: optimize tail call!
 		nArgs := _preCall(a, b, vm)
-		vm.Call(nArgs, -1)
-		_postCall(a, 0, vm)
+		vm.TailCall(nArgs)
 	case OP_RETURN: // return R(A), ..., R(A+B-2)
 		a, b, _ := inst.ABC()
 		a += 1
@@ -266,26 +271,12 @@ func (inst Instruction) Execute(vm api.LuaVM) {
 		}
 	case OP_FORLOOP: // R(A) += R(A+2); if R(A) <?= R(A+1) then { pc += sBx; R(A+3) := R(A) }
 		a, sbx := inst.AsBx()
-		a += 1
-
-		vm.PushValue(a)
-		vm.PushValue(a + 2)
-		vm.Arith(api.LUA_OPADD)
-		vm.Replace(a)
-
-		step := vm.ToNumber(a + 2)
-		if step >= 0 && vm.Compare(a, a+1, api.LUA_OPLE) || step < 0 && vm.Compare(a+1, a, api.LUA_OPLE) {
+		if vm.ForLoop(a) {
 			vm.AddPC(sbx)
-			vm.Copy(a, a+3)
 		}
 	case OP_FORPREP: // R(A) -= R(A+2); pc += sBx
 		a, sbx := inst.AsBx()
-		a += 1
-
-		vm.PushValue(a)
-		vm.PushValue(a + 2)
-		vm.Arith(api.LUA_OPSUB)
-		vm.Replace(a)
+		vm.ForPrep(a)
 		vm.AddPC(sbx)
 	case OP_TFORCALL: // R(A+3), ..., R(A+2+C) := R(A)(R(A+1), R(A+2))
 		a, _, c := inst.ABC()
@@ -353,38 +344,30 @@ func (inst Instruction) Execute(vm api.LuaVM) {
 	}
 }
 
-// TODO: R(A) := RK(B) op RK(C)
+// completes a CALL whose Lua function has returned its results to the top of the stack
+func (inst Instruction) FinishCall(vm api.LuaVM) {
+	a, _, c := inst.ABC()
+	_postCall(a+1, c, vm)
+}
+
+// R(A) := RK(B) op RK(C)
 func _binaryArith(inst Instruction, vm api.LuaVM, op api.ArithOp) {
 	a, b, c := inst.ABC()
-	a += 1
-
-	_getRK(vm, b)
-	_getRK(vm, c)
-	vm.Arith(op)
-	vm.Replace(a)
+	vm.RegArith(a, b, c, op)
 }
 
-// TODO: R(A) := op R(B)
+// R(A) := op R(B)
 func _unaryArith(inst Instruction, vm api.LuaVM, op api.ArithOp) {
 	a, b, _ := inst.ABC()
-	a += 1
-	b += 1
-
-	vm.PushValue(b)
-	vm.Arith(op)
-	vm.Replace(a)
+	vm.RegArith(a, b, b, op)
 }
 
-// TODO: if ((RK(B) op RK(C) ~= A)) then pc++
+// if ((RK(B) op RK(C)) ~= A) then pc++
 func _compare(inst Instruction, vm api.LuaVM, op api.CompareOp) {
 	a, b, c := inst.ABC()
-
-	_getRK(vm, b)
-	_getRK(vm, c)
-	if vm.Compare(-2, -1, op) != (a != 0) {
+	if vm.RegCompare(b, c, op) != (a != 0) {
 		vm.AddPC(1)
 	}
-	vm.Pop(2)
 }
 
 func _getRK(vm api.LuaVM, idx int) {
@@ -0,0 +1,13 @@
+-- comparisons and mixed arithmetic: lengths of Collatz sequences
+local n = tonumber(arg and arg[1]) or 100000
+local start = os.clock()
+local longest, best = 0, 0
+for i = 1, n do
+  local x, steps = i, 0
+  while x ~= 1 do
+    if x & 1 == 0 then x = x >> 1 else x = 3 * x + 1 end
+    steps = steps + 1
+  end
+  if steps > longest then longest, best = steps, i end
+end
+print(string.format("bench_compare: %d (%d steps) in %.2fs", best, longest, os.clock() - start))
@@ -0,0 +1,18 @@
+-- float arithmetic: points of the Mandelbrot set on a grid
+local n = tonumber(arg and arg[1]) or 200
+local start = os.clock()
+local inside = 0
+for y = 0, n - 1 do
+  local ci = 2.0 * y / n - 1.0
+  for x = 0, n - 1 do
+    local cr = 2.5 * x / n - 2.0
+    local zr, zi = 0.0, 0.0
+    local i = 0
+    while i < 50 and zr * zr + zi * zi <= 4.0 do
+      zr, zi = zr * zr - zi * zi + cr, 2.0 * zr * zi + ci
+      i = i + 1
+    end
+    if i == 50 then inside = inside + 1 end
+  end
+end
+print(string.format("bench_float: %d in %.2fs", inside, os.clock() - start))
@@ -0,0 +1,12 @@
+-- integer arithmetic and comparisons in a numeric loop, as sum.lua at scale
+local n = tonumber(arg and arg[1]) or 5000000
+local start = os.clock()
+local sum = 0
+for i = 1, n do
+  if i % 2 == 0 then
+    sum = sum + i
+  else
+    sum = sum - i // 3
+  end
+end
+print(string.format("bench_sum: %d in %.2fs", sum, os.clock() - start))
@@ -0,0 +1,24 @@
+-- table traffic: an array of records filled, sorted by field and summed
+local n = tonumber(arg and arg[1]) or 200000
+local start = os.clock()
+local points = {}
+for i = 1, n do
+  points[i] = {x = i % 1000, y = (i * 7919) % 1000 / 10}
+end
+local sum = 0.0
+for round = 1, 5 do
+  for i = 1, n do
+    local p = points[i]
+    p.x = p.x + 1
+    sum = sum + p.x * p.y
+  end
+end
+local index = {}
+for i = 1, n do
+  index["k" .. (i % 5000)] = i
+end
+local count = 0
+for _, v in pairs(index) do
+  count = count + v % 7
+end
+print(string.format("bench_table: %.1f %d in %.2fs", sum, count, os.clock() - start))
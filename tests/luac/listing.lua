@@ -0,0 +1,12 @@
+-- compiled by test_luac.sh; changes here need new .out files
+local t = {1, 2.5, "s", true, nil}
+local function add(a, b)
+  return a + b
+end
+for i = 1, 2 do
+  print(add(i, t[1]), ...)
+end
+return function(...)
+  local n = select("#", ...)
+  return t, add, n
+end
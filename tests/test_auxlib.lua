@@ -0,0 +1,75 @@
+-- argument checks and errors of the auxiliary library, seen through the stdlib
+local function check(msg, f, ...)
+  local ok, err = pcall(f, ...)
+  assert(not ok, msg)
+  assert(err == msg, "\ngot:      " .. tostring(err) .. "\nexpected: " .. msg)
+end
+
+-- errors of functions called by Lua code have the caller's position, and the
+-- name the function was called by
+local function checkSrc(msg, src)
+  check(msg, assert(load(src, "=t")))
+end
+checkSrc("t:1: bad argument #1 to 'rep' (string expected, got no value)", "string.rep()")
+checkSrc("t:2: bad argument #2 to 'r' (number expected, got table)", "local r = string.rep\nr('x', {})")
+checkSrc("t:2: bad argument #1 to 'rep' (number expected, got table)", "local s = 'x'\nlocal r = s:rep({})")
+checkSrc("t:2: calling 'write' on bad self (FILE* expected, got table)", "local t = {write = io.stdout.write}\nt:write('x')")
+checkSrc("t:2: bad argument #1 to 'seek' (invalid option 'nowhere')", "local f = io.stdout\nf:seek('nowhere')")
+-- without a name from the call, the function is looked up in the loaded modules
+check("bad argument #1 to 'string.rep' (string expected, got no value)", string.rep)
+check("bad argument #1 to 'tostring' (value expected)", tostring)
+
+-- CheckInteger, CheckNumber, CheckString and CheckAny
+check("bad argument #2 to 'string.rep' (number has no integer representation)", string.rep, "x", 1.5)
+check("bad argument #2 to 'string.rep' (number expected, got string)", string.rep, "x", "two")
+assert(string.rep("x", "2") == "xx" and string.rep("x", 2.0) == "xx") -- convertible values
+check("bad argument #1 to 'math.floor' (number expected, got string)", math.floor, "a")
+assert(math.floor("2.5") == 2)
+check("bad argument #1 to 'string.upper' (string expected, got table)", string.upper, {})
+check("bad argument #1 to 'string.upper' (string expected, got nil)", string.upper, nil)
+assert(string.upper(12) == "12")
+check("bad argument #1 to 'type' (value expected)", type)
+assert(type(nil) == "nil")
+
+-- OptInteger, OptNumber and OptString accept nothing or nil
+assert(string.sub("hello", 2) == "ello" and string.sub("hello", 2, nil) == "ello")
+check("bad argument #3 to 'string.sub' (number expected, got string)", string.sub, "hello", 2, "x")
+check("bad argument #3 to 'string.sub' (number has no integer representation)", string.sub, "hello", 2, 2.5)
+assert(table.concat({1, 2}) == "12" and table.concat({1, 2}, nil) == "12")
+check("bad argument #2 to 'table.concat' (string expected, got table)", table.concat, {1, 2}, {})
+assert(tonumber("10", nil) == 10 and tonumber("z", 36) == 35)
+check("bad argument #2 to 'tonumber' (number expected, got table)", tonumber, "10", {})
+
+-- CheckOption, CheckType and ArgCheck
+check("bad argument #1 to 'collectgarbage' (invalid option 'bogus')", collectgarbage, "bogus")
+assert(type(collectgarbage("count")) == "number" and collectgarbage() == 0)
+check("bad argument #1 to 'setmetatable' (table expected, got number)", setmetatable, 1, {})
+check("bad argument #2 to 'setmetatable' (nil or table expected)", setmetatable, {}, 1)
+check("bad argument #1 to 'ipairs' (value expected)", ipairs)
+check("bad argument #1 to 'select' (number has no integer representation)", select, 1.5)
+check("bad argument #1 to 'select' (index out of range)", select, 0)
+
+-- TypeError names values by their __name, and ToStringMeta uses it too
+local obj = setmetatable({}, {__name = "MyType"})
+check("bad argument #1 to 'string.upper' (string expected, got MyType)", string.upper, obj)
+assert(tostring(obj):find("^MyType: 0x"))
+local bad = setmetatable({}, {__tostring = function() return {} end})
+check("'__tostring' must return a string", tostring, bad)
+assert(tostring(setmetatable({}, {__tostring = function() return 42 end})) == "42")
+assert(tostring(setmetatable({}, {__tostring = function() return "fine" end})) == "fine")
+assert(tostring(setmetatable({}, {__name = 1})):find("^table: 0x")) -- not a string
+
+-- Len2 wants an integer length
+local weird = setmetatable({}, {__len = function() return "many" end})
+check("object length is not an integer", table.insert, weird, 1)
+check("object length is not an integer", table.unpack, weird)
+
+-- Where gives the position of the function at the given level
+local function whereAt(level) error("here", level) end
+local ok, msg = pcall(whereAt, 1)
+assert(msg:find(":%d+: here$") and msg:find("test_auxlib"), msg)
+checkSrc("t:3: up", "local function f() error('up', 2) end\n\nf()")
+checkSrc("t:3: up", "local function f() error('up', 3) end\nlocal function g() f() end\ng()")
+ok, msg = pcall(error, "in pcall", 1) -- level 1 is pcall itself, which has no line
+assert(msg == "in pcall", msg)
+print("auxlib ok")
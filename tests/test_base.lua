@@ -0,0 +1,72 @@
+print(_VERSION, _G == _G._G, type(print))
+print(nil, true, 10, 1.5, 1e100, 2^63, -0.0, 3 // 0.0, 10 // 3)
+
+-- tonumber
+print(tonumber("0x10"), tonumber("  12  "), tonumber("1e2"), tonumber("z"))
+print(tonumber("ff", 16), tonumber("zz", 36), tonumber("-101", 2), tonumber("8", 8))
+print(pcall(tonumber, "10", 99))
+print(pcall(tonumber))
+
+-- tostring and __tostring / __name
+local p = setmetatable({}, {__tostring = function() return "point" end})
+print(tostring(p), tostring(nil), tostring(12))
+
+-- print converts its arguments with the global tostring
+local seen, nSeen = {}, 0
+local savedToString = tostring
+tostring = function(v) nSeen = nSeen + 1; seen[nSeen] = v; return "<" .. savedToString(v) .. ">" end
+print(1, nil, "x")
+tostring = function() return {} end
+local ok, msg = pcall(print, 1)
+tostring = savedToString
+assert(nSeen == 3 and seen[1] == 1 and seen[2] == nil and seen[3] == "x")
+assert(not ok and msg == "'tostring' must return a string to 'print'")
+
+-- select
+print(select("#"), select("#", 1, nil, 3), select(2, "a", "b", "c"), select(-1, "a", "b"))
+print(pcall(select, 0, 1))
+
+-- error levels and assert
+local function f(level) error("boom", level) end
+print(pcall(f, 1))
+print(pcall(f, 2))
+print(pcall(f, 0))
+print(pcall(assert, false))
+print(pcall(assert, nil, "custom"))
+print(assert(1, "unused"))
+print(select("#", pcall(error)))
+
+-- raw access
+local t = setmetatable({}, {__index = function() return "meta" end, __newindex = function() end})
+t.x = 1
+rawset(t, "y", 2)
+print(t.x, rawget(t, "x"), t.y, rawlen({1, 2, 3}), rawlen("abcd"), rawequal(t, t), rawequal(t, {}))
+print(pcall(rawlen, 5))
+
+-- metatables
+local mt = {__metatable = "locked"}
+local locked = setmetatable({}, mt)
+print(getmetatable(locked), pcall(setmetatable, locked, {}))
+print(getmetatable("abc"), pcall(setmetatable, {}, 1))
+
+-- pairs with __pairs, ipairs with __index
+local proxy = setmetatable({}, {__pairs = function(t)
+  return function(_, k) if not k then return 1, "one" end end, t, nil
+end})
+for k, v in pairs(proxy) do print("pairs", k, v) end
+local seq = setmetatable({}, {__index = function(_, i) if i <= 3 then return i * 10 end end})
+for i, v in ipairs(seq) do print("ipairs", i, v) end
+print(next({}), pcall(next, {}, "nokey"))
+
+-- load
+print(load("return 1 + 1")())
+print(load("syntax error here"))
+local parts = {"return ", "'from ", "reader'"}
+local i = 0
+print(load(function() i = i + 1; return parts[i] end)())
+print(load("return x", "=chunk", "t", {x = "env"})())
+print(pcall(load("error('in chunk')", "=mychunk")))
+print(loadfile("/nonexistent/file.lua"))
+
+print(type(collectgarbage("count")), collectgarbage(), collectgarbage("isrunning"))
+print(pcall(collectgarbage, "bogus"))
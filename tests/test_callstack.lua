@@ -0,0 +1,36 @@
+-- deep recursion that is not a tail call
+local function sum(n) if n == 0 then return 0 end return n + sum(n - 1) end
+print(sum(100000))
+
+-- runaway recursion is a Lua error, not a crash
+local function rec(n) return 1 + rec(n + 1) end
+print(pcall(rec, 1))
+local depth = 0
+local function count() depth = depth + 1; count() end
+print(pcall(count))
+print(depth > 1000)
+
+-- frames with many registers overflow at a total number of slots, long
+-- before the depth limit
+local src = {"local function f(n) local "}
+for i = 1, 80 do src[#src + 1] = "a" .. i .. (i < 80 and ", " or " = 1\n") end
+src[#src + 1] = "WIDE = n return f(n + 1) + 1 end\nreturn f"
+local ok, msg = pcall(load(table.concat(src), "=wide")(), 1)
+assert(not ok and msg == "wide:2: stack overflow" and WIDE > 1000 and WIDE < 20000, msg)
+
+-- message handlers run at the point of the overflow
+print(xpcall(rec, function(m) return "handled: " .. m end, 1))
+local function badhandler(m) return badhandler(m) .. "!" end
+print(xpcall(rec, badhandler, 1))
+
+-- nested calls from Go functions and metamethods are limited too
+local function viapcall() return select(2, pcall(viapcall)) end
+print(viapcall())
+local t = setmetatable({}, {__index = function(t, k) return t[k + 1] end})
+print(pcall(function() return t[1] end))
+
+-- inside coroutines, and afterwards the stack is usable again
+local co = coroutine.create(function() return rec(1) end)
+print(coroutine.resume(co))
+print(sum(1000))
+print("callstack ok")
@@ -0,0 +1,54 @@
+local f = load("local a = ... return a + 1, 'k'", "=f")
+local bin = string.dump(f, true)
+
+local function patch(s, pos, bytes)
+  return s:sub(1, pos - 1) .. bytes .. s:sub(pos + #bytes)
+end
+
+-- layout of a stripped chunk: header, number of upvalues, then the main
+-- function with its sizes at 46 and the size of its code at 47
+local ncode = string.unpack("<I4", bin, 47)
+local inst = string.unpack("<I4", bin, 51)
+print(#bin, ncode, bin:byte(34), bin:byte(46))
+print(load(bin)(41))
+
+-- truncated input and hostile counts
+for _, n in ipairs({33, 34, 46, 50, 60, #bin - 1}) do
+  print(load(bin:sub(1, n), "=cut"))
+end
+print(load(patch(bin, 47, string.pack("<I4", 0xffffffff)), "=count"))
+print(load(patch(bin, 51 + 4 * ncode, string.pack("<I4", 0x7fffffff)), "=count"))
+print(load(patch(bin, 55 + 4 * ncode, "\x42"), "=tag"))
+print(load(patch(bin, 34, "\5"), "=upvalues"))
+
+-- bytecode verifier
+print(load(patch(bin, 46, "\0"), "=stack"))
+print(load(patch(bin, 51, string.pack("<I4", inst & ~(0xff << 6) | 200 << 6)), "=register"))
+print(load(patch(bin, 51, string.pack("<I4", 63)), "=opcode"))
+print(load(patch(bin, 47 + 4 * ncode, string.pack("<I4", 30)), "=return"))
+print(load(patch(bin, 51, string.pack("<I4", 30 | (0x1ffff + 100) << 14)), "=jump"))
+print(load(patch(bin, 51, string.pack("<I4", 1 | 9 << 14)), "=constant"))
+print(load(patch(bin, 51, string.pack("<I4", 46)), "=extraarg"))
+
+-- the upvalues of a loaded function other than _ENV start as nil
+local x, y = 1, 2
+local g = load(string.dump(function() return x, y end))
+print(type(g()), select(2, g()))
+
+-- random corruption never gets past the reader unnoticed
+math.randomseed(42)
+local rejected = 0
+for _ = 1, 2000 do
+  local s = bin
+  for _ = 1, math.random(3) do
+    local pos = math.random(#s)
+    s = patch(s, pos, string.char(math.random(0, 255)))
+  end
+  local fn, err = load(s, "=fuzz", "b")
+  if not fn then
+    rejected = rejected + 1
+    assert(type(err) == "string")
+  end
+end
+print(rejected > 0)
+print("chunk ok")
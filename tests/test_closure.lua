@@ -13,4 +13,20 @@ print(c1()) --> 2
 c2 = newCounter()
 print(c2()) --> 1
 print(c1()) --> 3
-print(c2()) --> 2
\ No newline at end of file
+print(c2()) --> 2
+-- open upvalues follow their variables when the stack grows
+local many = {}
+for i = 1, 1000 do many[i] = i end
+local x
+local getX = function() return x end
+print(select('#', table.unpack(many))) --> 1000
+x = 1
+assert(getX() == 1)
+local function grow(...)
+  local y = 0
+  local incY = function() y = y + 1 end
+  local n = select('#', table.unpack(many))
+  incY()
+  return y, n
+end
+assert(grow() == 1)
@@ -0,0 +1,92 @@
+-- generator
+local function range(n)
+  return coroutine.wrap(function()
+    for i = 1, n do coroutine.yield(i) end
+  end)
+end
+
+local sum = 0
+for i in range(10) do sum = sum + i end
+print(sum)
+
+-- values passed both ways
+local co = coroutine.create(function(a, b)
+  print("start", a, b)
+  local c = coroutine.yield(a + b)
+  print("got", c)
+  local d, e = coroutine.yield(c * 2)
+  return d + e
+end)
+print(coroutine.status(co))
+print(coroutine.resume(co, 1, 2))
+print(coroutine.status(co))
+print(coroutine.resume(co, 10))
+print(coroutine.resume(co, 3, 4))
+print(coroutine.status(co))
+print(coroutine.resume(co))
+
+-- errors
+co = coroutine.create(function() error("oops") end)
+print(coroutine.resume(co))
+print(coroutine.status(co))
+
+-- status, running and isyieldable
+local outer
+outer = coroutine.create(function()
+  local inner = coroutine.create(function()
+    print(coroutine.status(outer), coroutine.isyieldable())
+  end)
+  coroutine.resume(inner)
+  print(coroutine.status(outer), coroutine.running() == outer)
+end)
+coroutine.resume(outer)
+print(coroutine.isyieldable())
+
+-- round-robin scheduler
+local tasks = {}
+for id = 1, 3 do
+  tasks[id] = coroutine.create(function()
+    for step = 1, id do coroutine.yield(id .. ":" .. step) end
+  end)
+end
+local running = true
+while running do
+  running = false
+  for _, task in ipairs(tasks) do
+    if coroutine.status(task) ~= "dead" then
+      local ok, msg = coroutine.resume(task)
+      if msg then print(msg) end
+      running = true
+    end
+  end
+end
+
+-- suspended coroutines that nothing refers to anymore are collected
+local function spawn(n)
+  for i = 1, n do
+    local gen = coroutine.wrap(function() coroutine.yield(i) end)
+    assert(gen() == i)
+  end
+end
+spawn(1000)
+for _ = 1, 3 do collectgarbage() end
+local before = collectgarbage("count")
+spawn(20000)
+for _ = 1, 3 do collectgarbage() end -- the first ones only end the goroutines
+assert(collectgarbage("count") - before < 10 * 1024, "suspended coroutines leak")
+local kept = coroutine.wrap(function()
+  for i = 1, math.huge do coroutine.yield(i) end
+end)
+assert(kept() == 1)
+for _ = 1, 3 do collectgarbage() end
+assert(kept() == 2) -- still referenced, so still suspended
+local co
+co = coroutine.create(function()
+  assert(coroutine.running() == co and coroutine.status(co) == "running")
+  coroutine.yield()
+  assert(coroutine.running() == co)
+end)
+local set = {[co] = true}
+assert(coroutine.resume(co) and set[co] and coroutine.status(co) == "suspended")
+assert(coroutine.resume(co) and coroutine.status(co) == "dead")
+print("collected")
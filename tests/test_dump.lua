@@ -0,0 +1,112 @@
+-- string.dump and load round trips, with and without debug information
+local function pack(...) return {n = select("#", ...), ...} end
+
+local function same(a, b)
+  if a ~= a then return b ~= b end -- nan
+  if math.type(a) ~= math.type(b) then return false end
+  if a == 0 and b == 0 and math.type(a) == "float" then return 1 / a == 1 / b end -- -0.0
+  return a == b
+end
+
+local function sameResults(f, g, ...)
+  local r1, r2 = pack(pcall(f, ...)), pack(pcall(g, ...))
+  if r1.n ~= r2.n then return false end
+  for i = 1, r1.n do
+    local a, b = r1[i], r2[i]
+    if type(a) == "table" and type(b) == "table" then
+      a, b = table.concat(a, ","), table.concat(b, ",")
+    end
+    if not same(a, b) then return false, tostring(a) .. " ~= " .. tostring(b) end
+  end
+  return true
+end
+
+-- functions using every kind of constant and most instructions
+local funcs = {
+  function() return nil, true, false, 0, -1, math.maxinteger, math.mininteger end,
+  function() return 1.5, -0.0, 1e308, 2^-1074, 1 / 0, -1 / 0, 0 / 0 end,
+  function() return "", "short", "with\0zero", string.rep("long string ", 30) end,
+  function(a, b) return a + b, a - b, a * b, a / b, a // b, a % b, a ^ b, -a end,
+  function(a, b) return a & b, a | b, a ~ b, a << b, a >> b, ~a, a == b, a < b, a <= b end,
+  function(s) return #s, s .. "!", not s, s and 1 or 2 end,
+  function(...) local t = {...} return select("#", ...), #t, ... end,
+  function(n)
+    local t = {}
+    for i = 1, n do t[i] = i * i end
+    for i = n, 1, -2 do t[#t + 1] = -i end
+    return t
+  end,
+  function(t)
+    local keys = {}
+    for k in pairs(t) do keys[#keys + 1] = tostring(k) end
+    table.sort(keys)
+    return keys
+  end,
+  function(n)
+    local fs = {}
+    for i = 1, n do fs[i] = function() i = i + 1 return i end end
+    local count = 0
+    local function inc() count = count + 1 return count end
+    return fs[1](), fs[1](), fs[n](), inc(), inc()
+  end,
+  function(n)
+    local i = 0
+    ::top::
+    i = i + 1
+    if i < n then goto top end
+    while true do
+      i = i * 2
+      if i > 100 then break end
+    end
+    repeat local j = i; i = i - 1 until j < 50
+    return i
+  end,
+  function()
+    local obj = {v = 3}
+    function obj:get(k) return self.v * k end
+    local t = {1, 2, 3, x = "x", [10] = 10, {nested = true}}
+    return obj:get(2), #t, t.x, t[10], t[4].nested
+  end,
+  function(x) return x.field end, -- an error
+  function() return undefined_global + 1 end,
+}
+
+for i, f in ipairs(funcs) do
+  local full, stripped = string.dump(f), string.dump(f, true)
+  assert(#stripped < #full, i)
+  local g, h = assert(load(full, "full", "b")), assert(load(stripped, "stripped", "b"))
+  for _, args in ipairs{{}, {7, 3}, {"str"}, {4}, {{a = 1, b = 2}}, {3.5, 2}} do
+    assert(sameResults(f, g, table.unpack(args)), i)
+    local ok = sameResults(g, h, table.unpack(args))
+    assert(ok or not pcall(f, table.unpack(args)), i) -- only error messages differ
+  end
+  -- dumping a reloaded function gives back the same bytes
+  assert(string.dump(g) == full, i)
+  assert(string.dump(h, true) == stripped and string.dump(g, true) == stripped, i)
+end
+
+-- the debug information survives unless stripped
+local f = load("local x\nlocal y = 1\nreturn x.field", "=src")
+local full = load(string.dump(f))
+local stripped = load(string.dump(f, true))
+local msg1, msg2, msg3 = select(2, pcall(f)), select(2, pcall(full)), select(2, pcall(stripped))
+assert(msg1 == "src:3: attempt to index a nil value (local 'x')", msg1)
+assert(msg2 == msg1, msg2)
+assert(msg3 == "?:?: attempt to index a nil value", msg3)
+msg1 = select(2, pcall(funcs[#funcs]))
+msg2 = select(2, pcall(load(string.dump(funcs[#funcs]))))
+msg3 = select(2, pcall(load(string.dump(funcs[#funcs], true))))
+assert(msg1 == msg2 and msg1:find("(global 'undefined_global')", 1, true), msg2)
+assert(msg3 == "?:?: attempt to perform arithmetic on a nil value (field 'undefined_global')", msg3) -- no _ENV name
+
+-- the main chunk of a file, and a chunk loaded from text
+local main = assert(loadfile("vector.lua"))
+assert(string.dump(load(string.dump(main))) == string.dump(main))
+local src = "local a, b = ... return b, a"
+local chunk = load(string.dump(load(src)), "=bin", "b")
+assert(select("#", chunk(1, 2)) == 2 and chunk(1, 2) == 2)
+
+-- only Lua functions can be dumped
+assert(select(2, pcall(string.dump, print)) == "unable to dump given function")
+assert(select(2, pcall(string.dump, 1)):find("bad argument #1 to 'string.dump' (function expected, got number)", 1, true))
+print("dump ok")
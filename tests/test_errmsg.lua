@@ -0,0 +1,91 @@
+-- exact texts of runtime and compile-time errors
+local function check(src, msg)
+  local f, err = load(src, "=t")
+  if f then
+    local ok
+    ok, err = pcall(f)
+    assert(not ok, src)
+  end
+  assert(err == msg, "\n" .. src .. "\ngot:      " .. tostring(err) .. "\nexpected: " .. msg)
+end
+
+-- what is being operated on, and where it comes from
+check("return x.y", "t:1: attempt to index a nil value (global 'x')")
+check("x = nil; x.y = 1", "t:1: attempt to index a nil value (global 'x')")
+check("local v = {}; return v + 1", "t:1: attempt to perform arithmetic on a table value (local 'v')")
+check("local t = {}; return t.a.b", "t:1: attempt to index a nil value (field 'a')")
+check("local t = {}; t.a.b = 1", "t:1: attempt to index a nil value (field 'a')")
+check("local t = {}; t:m()", "t:1: attempt to call a nil value (method 'm')")
+check("local t = {}; return t.f()", "t:1: attempt to call a nil value (field 'f')")
+check("undefined()", "t:1: attempt to call a nil value (global 'undefined')")
+check("local s = 'x'; return -s", "t:1: attempt to perform arithmetic on a string value (local 's')")
+check("local u = {} return (function() return u() end)()", "t:1: attempt to call a table value (upvalue 'u')")
+check("local a = {}; return #a.b", "t:1: attempt to get length of a nil value (field 'b')")
+check("return ~{}", "t:1: attempt to perform bitwise operation on a table value")
+check("local b = true; return 1 | b", "t:1: attempt to perform bitwise operation on a boolean value (local 'b')")
+check("local f = 1.5; return f & 1", "t:1: number (local 'f') has no integer representation")
+check("return '1.5' >> 1", "t:1: number (constant '1.5') has no integer representation") -- loaded by LOADK
+check("local a, b = 'x', {}; return a .. b", "t:1: attempt to concatenate a table value (local 'b')")
+check("local a = {}; return a .. 'x'", "t:1: attempt to concatenate a table value (local 'a')")
+check("return 'a' .. nil .. 'b'", "t:1: attempt to concatenate a nil value")
+check("return {} < {}", "t:1: attempt to compare two table values")
+check("return 1 < 'x'", "t:1: attempt to compare number with string")
+check("return {} <= 1", "t:1: attempt to compare table with number")
+check("local z = 0; return 1 // z", "t:1: attempt to perform 'n//0'")
+check("local z = 0; return 1 % z", "t:1: attempt to perform 'n%0'")
+check("return ('x')()", "t:1: attempt to call a string value (constant 'x')")
+check("local t = setmetatable({}, {__index = function(t, k) return k.x end}); return t[1]",
+  "t:1: attempt to index a number value (local 'k')")
+
+-- numeric for loops
+check("for i = 'a', 2 do end", "t:1: 'for' initial value must be a number")
+check("for i = 1, {} do end", "t:1: 'for' limit must be a number")
+check("for i = 1, 2, nil do end", "t:1: 'for' step must be a number")
+local seen = {}
+for i = 1, "2" do seen[#seen + 1] = math.type(i) end -- an integer loop
+for i = "1", 2 do seen[#seen + 1] = math.type(i) end -- a float loop
+for i = 1, 2, 1.0 do seen[#seen + 1] = math.type(i) end
+for i = 1, 2.5 do seen[#seen + 1] = i end
+assert(table.concat(seen, " ") == "integer integer float float float float 1 2", table.concat(seen, " "))
+
+-- error values other than strings are kept, and levels add a position
+local ok, e = pcall(error, {code = 1})
+assert(not ok and type(e) == "table" and e.code == 1)
+ok, e = pcall(error)
+assert(not ok and e == nil)
+ok, e = pcall(error, "no position", 0)
+assert(e == "no position")
+check("error('msg')", "t:1: msg")
+check("local function f() error('up', 2) end\nf()", "t:2: up")
+check("error(42)", 42)
+
+-- syntax errors
+check("x = = 1", "t:1: unexpected symbol near '='")
+check("x = 'abc\ny = 1", "t:1: unfinished string near ''abc'")
+check("local function f()\nreturn 1", "t:2: 'end' expected (to close 'function' at line 1) near <eof>")
+check("if x then\n\nelse", "t:3: 'end' expected (to close 'if' at line 1) near <eof>")
+check("local 1 = 2", "t:1: <name> expected near '1'")
+check("f(", "t:1: unexpected symbol near <eof>")
+check("x = {1, 2", "t:1: '}' expected near <eof>")
+check("return return", "t:1: unexpected symbol near 'return'")
+check("x = 1 end", "t:1: <eof> expected near 'end'")
+check("break", "t:1: <break> at line 1 not inside a loop")
+check("goto nowhere", "t:1: no visible label 'nowhere' for <goto> at line 1")
+check("function f() return ... end", "t:1: cannot use '...' outside a vararg function near '...'")
+check("x = 1 y = 2 z", "t:1: syntax error near <eof>")
+check("f() = 1", "t:1: syntax error near '='")
+check("x, (y) = 1, 2", "t:1: syntax error near '='")
+check("a.b:c = 1", "t:1: function arguments expected near '='")
+check("f(1,\n2", "t:2: ')' expected (to close '(' at line 1) near <eof>")
+check("t = {\n1\n", "t:3: '}' expected (to close '{' at line 1) near <eof>")
+check("repeat\nx = 1", "t:2: 'until' expected (to close 'repeat' at line 1) near <eof>")
+check("while x do y() end end", "t:1: <eof> expected near 'end'")
+check("x = 'a\\qb'", "t:1: invalid escape sequence near ''a\\q'")
+check("x = 'a\\tb\\x5g'", "t:1: hexadecimal digit expected near ''a\tb\\x5g'")
+check("x = '\\u{12'", "t:1: missing '}' near ''\\u{12''")
+check("x = '\\300'", "t:1: decimal escape too large near ''\\300''")
+check("x = 'ab\\", "t:1: unfinished string near <eof>")
+check("x = [==[abc", "t:1: unfinished long string (starting at line 1) near <eof>")
+check("x = [=a", "t:1: invalid long string delimiter near '[='")
+check("x = 3 .. 4 .. 5e", "t:1: malformed number near '5e'")
+print("errmsg ok")
@@ -0,0 +1,47 @@
+-- continue
+local sum = 0
+for i = 1, 10 do
+  if i % 2 == 0 then goto continue end
+  sum = sum + i
+  ::continue::
+end
+print(sum) --> 25
+
+-- backward jump
+local n = 0
+::top::
+n = n + 1
+if n < 5 then goto top end
+print(n) --> 5
+
+-- nested loops
+for i = 1, 3 do
+  for j = 1, 3 do
+    if i * j == 4 then goto done end
+  end
+end
+::done::
+print("done")
+
+-- fresh upvalue per iteration
+local fs = {}
+do
+  local i = 1
+  ::again::
+  local x = i
+  fs[i] = function() return x end
+  i = i + 1
+  if i <= 3 then goto again end
+end
+print(fs[1](), fs[2](), fs[3]()) --> 1 2 3
+
+-- errors carry the line of the offending statement
+local function check(src, msg)
+  local f, err = load(src)
+  assert(f == nil and err == msg, err)
+end
+check("::a:: ::a::", [[[string "::a:: ::a::"]:1: label 'a' already defined on line 1]])
+check("\n::a::\n::a::", [[[string "..."]:3: label 'a' already defined on line 2]])
+check("do\n goto l\n local x\n ::l:: print(x)\nend",
+  [[[string "do..."]:4: <goto l> at line 2 jumps into the scope of local 'x']])
+print("goto errors ok")
@@ -0,0 +1,95 @@
+local name = os.tmpname()
+print(type(name), io.type(io.stdout), io.type(42), tostring(io.stdout):match("^file %(") ~= nil)
+
+local f = assert(io.open(name, "w"))
+print(io.type(f), f:write("hello\n", 42, " ", 1.5, " ", 2.0, "\n") == f)
+f:write("0x10 -3.5e1 .5 zzz\n", "last line")
+print(f:close(), io.type(f), tostring(f))
+print(pcall(f.write, f, "x"))
+
+f = assert(io.open(name))
+print(f:read())
+print(f:read("L"))
+print(f:read("n", "n", "*n"))
+print(f:read("n"), f:read(3), f:read(0))
+print(f:read("l"), f:read("a"))
+print(f:read("a"), f:read("l"), f:read(0), f:read(1))
+print(f:seek("set", 2), f:read(3), f:seek(), f:seek("end"))
+print(pcall(f.read, f, "x"))
+f:close()
+
+local lines = {}
+for l in io.lines(name) do lines[#lines + 1] = l end
+print(#lines, lines[1], lines[4])
+for a, b in io.lines(name, 1, "l") do print(a, b) break end
+f = io.open(name)
+local n = 0
+for l in f:lines("L") do n = n + #l end
+print(n, io.type(f), f:seek("set"))
+f:close()
+print(pcall(io.lines, "/nonexistent/file"))
+
+f = io.open(name, "a+")
+f:write("\nappended")
+f:seek("set")
+print(f:read("a"):sub(-8))
+f:close()
+
+f = io.open(name, "r+")
+f:setvbuf("full")
+f:write("HELLO")
+f:seek("set")
+print(f:read("l"))
+f:close()
+
+print(io.open("/nonexistent/file"))
+print(pcall(io.open, name, "rw"))
+print(io.stdout:close())
+
+local old = io.output()
+io.output(name)
+io.write("via default output")
+io.close()
+io.output(old)
+io.input(name)
+print(io.read("a"))
+io.input(io.stdin)
+
+local new = name .. ".renamed"
+print(os.rename(name, new), io.open(name), os.remove(new))
+print(os.remove(new))
+
+f = io.tmpfile()
+f:write("temporary")
+f:seek("set")
+print(f:read("a"))
+f:close()
+
+print(type(os.time()), type(os.clock()), os.getenv("NO_SUCH_VARIABLE_HOPEFULLY"))
+local t = os.time({year = 2020, month = 2, day = 30, hour = 0})
+local d = os.date("*t", t)
+print(d.year, d.month, d.day, d.hour, d.min, d.sec, d.wday, d.yday, d.isdst)
+print(os.date("!%Y-%m-%d %H:%M:%S %j %a %A %b %B %p %y %C %e|%D|%F|%T|%R", 86400 * 365 + 3661))
+print(os.date("!%c|%x|%X|%I|%r|%u %w|%U %W %V %G %g|%%|%Ey|%Od", 0))
+print(os.date("!*t", 0).year, os.difftime(10, 4), pcall(os.date, "%Q"))
+print(pcall(os.time, {year = 2020}), pcall(os.time, {year = 2020, month = "x", day = 1}))
+local tt = {year = 2021, month = 13, day = 1}
+os.time(tt)
+print(tt.year, tt.month, tt.day, tt.hour)
+-- a buffered file that is never closed is flushed when its handle is collected
+local unclosed = os.tmpname()
+do
+  local g = assert(io.open(unclosed, "w"))
+  g:setvbuf("full")
+  g:write("kept")
+end
+local content
+for _ = 1, 100 do -- finalizers run after the collection
+  collectgarbage()
+  local g = io.open(unclosed)
+  content = g:read("a")
+  g:close()
+  if content ~= "" then break end
+end
+print(content, os.remove(unclosed))
+print("io ok")
@@ -0,0 +1,49 @@
+-- source lines and local variable ranges recorded in compiled functions
+local function errorOf(src, ...)
+  local f = assert(load(src, "=t"))
+  local ok, msg = pcall(f, ...)
+  assert(not ok)
+  return msg
+end
+
+local function check(src, msg)
+  local got = errorOf(src)
+  assert(got == msg, "\n" .. src .. "\ngot:      " .. tostring(got) .. "\nexpected: " .. msg)
+end
+
+-- errors are raised at the line of the failing instruction
+check("local x = nil\nreturn x.y", "t:2: attempt to index a nil value (local 'x')")
+check("\n\n\nerror('boom')", "t:4: boom")
+check("--[[ a\nlong\ncomment ]] local s = [[\n\n]] error('after strings')", "t:5: after strings")
+check("local s = 'a\\\nb'\nreturn s + 1", "t:3: attempt to perform arithmetic on a string value (local 's')")
+check("local t = {}\nlocal u = t\n  .a\n  .b", "t:4: attempt to index a nil value (field 'a')")
+check("local f\nlocal function g()\n  return 1\nend\nf()", "t:5: attempt to call a nil value (local 'f')")
+check("local a = {}\nfunction a.b\n  .c()\nend", "t:2: attempt to index a nil value (field 'b')")
+check("for i = 1, 3 do\n  if i == 3 then\n    error('third')\n  end\nend", "t:3: third")
+
+-- inside functions, at their own lines, and error levels
+check("local function f()\n  error('in f')\nend\nf()", "t:2: in f")
+check("local function f()\n  error('caller', 2)\nend\n\nf()", "t:5: caller")
+check("local function f(x)\n  return x.y\nend\nlocal function g()\n  return f(nil) + 1\nend\nreturn g()",
+  "t:2: attempt to index a nil value (local 'x')")
+check("local t = setmetatable({}, {__index = function(t, k)\n  error('no ' .. k)\nend})\nreturn t.key", "t:2: no key")
+
+-- a local is only known from the end of its declaration to the end of its block
+check("local a\nlocal b = a.x", "t:2: attempt to index a nil value (local 'a')")
+check("local n = n + 1", "t:1: attempt to perform arithmetic on a nil value (global 'n')")
+check("do local gone = 1 end\nlocal n\nreturn -n", "t:3: attempt to perform arithmetic on a nil value (local 'n')")
+check("local a, b = 1\nreturn b()", "t:2: attempt to call a nil value (local 'b')")
+check("for k = 1, 1 do local v = k .. {} end", "t:1: attempt to concatenate a table value")
+check("for k, v in pairs{1} do local w = v < {} end", "t:1: attempt to compare number with table")
+check("local function f(p, q)\n  return q.x\nend\nreturn f(1)", "t:2: attempt to index a nil value (local 'q')")
+check("local x = 1\ndo\n  local x = nil\n  x()\nend", "t:4: attempt to call a nil value (local 'x')")
+check("local up\nlocal function f()\n  return up.x\nend\nf()", "t:3: attempt to index a nil value (upvalue 'up')")
+
+-- functions loaded from files report the file and its lines
+local msg
+xpcall(function()
+  local t = nil
+  return t.x
+end, function(m) msg = m end)
+assert(msg == "test_lineinfo.lua:46: attempt to index a nil value (local 't')", msg)
+print("lineinfo ok")
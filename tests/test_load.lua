@@ -0,0 +1,35 @@
+local bin = string.dump(function() return "binary" end)
+
+-- mode
+print(load(bin, "b", "b")(), load("return 'text'", "t", "t")())
+print(load(bin, "=bin", "t"))
+print(load("return 1", "=src", "b"))
+print(load(bin, "=bin", "bt")(), load("return 2", "=src", "bt")())
+print(load("return 3", "=src", ""))
+
+-- syntax errors are returned, not raised
+print(load("x = = 1", "=bad"))
+print(load("return 'unfinished", "@file.lua"))
+print(load("for", "=eof"))
+print(load("goto nowhere", "=goto"))
+print(type(load(function() return nil end)))
+
+-- malformed binary chunks
+print(load(bin:sub(1, 10), "=short"))
+print(load(bin:sub(1, #bin - 3), "=truncated"))
+print(load("\27Lua\x52" .. bin:sub(6), "=version"))
+print(load(bin:sub(1, 6) .. "\0\0" .. bin:sub(9), "=corrupt"))
+
+-- errors from the reader function
+print(load(function() error("reader failed") end))
+print(load(function() return {} end))
+local parts, i = {"return ", "'pieces'"}, 0
+print(load(function() i = i + 1; return parts[i] end)())
+
+-- the loaded chunk gets its own environment
+local env = {y = 5}
+local f = load("x = 10; return y", "=env", "t", env)
+print(f(), env.x, x)
+print(load("local a = 1", "=noupvalue", "t", env) ~= nil)
+print(pcall(load("return undefined_global.field", "=nilenv", "t", {})))
+print("load ok")
@@ -0,0 +1,97 @@
+#!/bin/sh
+# Checks the options of cmd/luac and compares its listings with the .out files
+# under luac/. The listings are of chunks compiled by cmd/luac, so luac5.3 can
+# list them too: when it is installed, the .out files are checked against its
+# listings, and -u rewrites them from luac5.3 after a change to the compiler.
+set -e
+cd "$(dirname "$0")"
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+(cd ../src/luago && go build -o "$tmp/luac" ./cmd/luac && go build -o "$tmp/lua" .)
+luac="$tmp/luac"
+
+fail() {
+	echo "test_luac.sh: $*" >&2
+	exit 1
+}
+
+# hides the fields of a listing that differ between runs and luacs: the
+# addresses and the chunk names
+normalise() {
+	sed -E -e 's/0x[0-9a-f]+/0x?/g' -e 's/^(main|function) <.*:([0-9]+,[0-9]+)>/\1 <?:\2>/'
+}
+
+# compares the listing printed by luac with the given options to luac/$1,
+# and to the one printed by luac5.3 if there is one
+check_listing() {
+	out=$1
+	shift
+	"$luac" "$@" | normalise >"$tmp/$out"
+	if [ -n "$ref" ]; then
+		"$ref" "$@" | normalise >"$tmp/ref.out"
+		if [ "$update" = 1 ]; then
+			cp "$tmp/ref.out" "luac/$out"
+		fi
+		diff -u "$tmp/ref.out" "$tmp/$out" || fail "luac $* differs from $ref $*"
+	fi
+	diff -u "luac/$out" "$tmp/$out" || fail "luac $* differs from luac/$out"
+}
+
+# checks that luac with the given options fails with the message $1
+check_error() {
+	msg=$1
+	shift
+	if "$luac" "$@" >"$tmp/out" 2>"$tmp/err"; then
+		fail "luac $* succeeded"
+	fi
+	grep -qF "$msg" "$tmp/err" || fail "luac $*: expected '$msg', got '$(head -1 "$tmp/err")'"
+}
+
+ref=$(command -v luac5.3 || true)
+update=0
+if [ "$1" = -u ]; then
+	[ -n "$ref" ] || fail "-u needs luac5.3 to list the chunks"
+	update=1
+fi
+
+# listings, short and full, of a compiled and of a stripped chunk
+"$luac" -o "$tmp/full.luac" luac/listing.lua
+"$luac" -s -o "$tmp/stripped.luac" luac/listing.lua
+check_listing listing.out -l -p "$tmp/full.luac"
+check_listing listing_full.out -l -l -p "$tmp/full.luac"
+check_listing stripped.out -l -l -p "$tmp/stripped.luac"
+
+# a source is listed as its chunk is
+"$luac" -l -l -p luac/listing.lua | normalise >"$tmp/source.out"
+diff -u luac/listing_full.out "$tmp/source.out" || fail "luac -l -l -p luac/listing.lua differs from luac/listing_full.out"
+
+# -p writes nothing, and luac.out is the default output
+(cd "$tmp" && "$luac" -p "$OLDPWD/luac/listing.lua" && [ ! -e luac.out ]) || fail "luac -p wrote luac.out"
+(cd "$tmp" && "$luac" "$OLDPWD/luac/listing.lua" && [ -s luac.out ]) || fail "luac did not write luac.out"
+
+# -o writes a chunk that runs as the source does, to stdout with -o -
+"$luac" -o - luac/listing.lua >"$tmp/stdout.luac"
+cmp -s "$tmp/full.luac" "$tmp/stdout.luac" || fail "luac -o - differs from luac -o file"
+"$tmp/lua" luac/listing.lua >"$tmp/expected"
+for chunk in full.luac stripped.luac; do
+	"$tmp/lua" "$tmp/$chunk" >"$tmp/got"
+	cmp -s "$tmp/expected" "$tmp/got" || fail "$chunk does not run as luac/listing.lua"
+done
+[ "$(wc -c <"$tmp/stripped.luac")" -lt "$(wc -c <"$tmp/full.luac")" ] || fail "luac -s did not strip"
+
+# several files are combined into a chunk running them in turn
+"$luac" -o "$tmp/both.luac" luac/listing.lua hello_world.lua
+"$tmp/lua" hello_world.lua >>"$tmp/expected"
+"$tmp/lua" "$tmp/both.luac" >"$tmp/got"
+cmp -s "$tmp/expected" "$tmp/got" || fail "combined chunk does not run both files"
+
+# errors
+echo "x = = 1" >"$tmp/bad.lua"
+check_error "luac: $tmp/bad.lua:1: unexpected symbol near '='" -p "$tmp/bad.lua"
+check_error "luac: cannot open $tmp/missing.lua" "$tmp/missing.lua"
+check_error "luac: '-o' needs argument" -o
+check_error "luac: unrecognized option '-x'" -x luac/listing.lua
+check_error "luac: no input files given"
+[ "$("$luac" -v)" = "Lua 5.3 (luago)" ] || fail "luac -v"
+
+echo "luac ok"
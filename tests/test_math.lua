@@ -0,0 +1,66 @@
+print(math.floor(3.7), math.floor(-3.2), math.floor(5), math.floor(2^70))
+print(math.ceil(3.2), math.ceil(-3.7), math.ceil(5), math.ceil(-0.5))
+print(math.type(1), math.type(1.0), math.type("1"), pcall(math.type))
+print(math.tointeger(3.0), math.tointeger(3.5), math.tointeger("x"), math.tointeger(2^63))
+print(math.maxinteger, math.mininteger, math.maxinteger + 1 == math.mininteger)
+print(math.ult(1, -1), math.ult(-1, 1), math.ult(2, 3))
+print(math.fmod(7, 3), math.fmod(-7, 3), math.fmod(7, -3), math.fmod(math.mininteger, -1))
+print(math.fmod(7.5, 2), math.fmod(-7.5, 2), pcall(math.fmod, 1, 0))
+print(math.fmod(1, 0.0) ~= math.fmod(1, 0.0))
+print(math.abs(-3), math.abs(-3.5), math.abs(math.mininteger), math.abs(4))
+print(math.max(1, 2.5, 2), math.max(3, 1.0), math.min(1.0, 1), math.min(-1, 2), pcall(math.max))
+print(math.huge, -math.huge, math.pi)
+print(math.modf(3.7), math.modf(-3.7), math.modf(5), math.modf(math.huge))
+print(math.sqrt(16), math.exp(0), math.log(1), math.log(8, 2), math.log(100, 10), math.log(27, 3))
+print(math.sin(0), math.cos(0), math.tan(0), math.asin(1) == math.pi / 2, math.acos(1))
+print(math.atan(1, 1) == math.pi / 4, math.atan(0), math.deg(math.pi), math.rad(180) == math.pi)
+
+math.randomseed(42)
+local a = {}
+for i = 1, 5 do a[i] = math.random(100) end
+math.randomseed(42)
+for i = 1, 5 do assert(a[i] == math.random(100)) end
+for i = 1, 1000 do
+  local x = math.random()
+  assert(0 <= x and x < 1 and math.type(x) == "float")
+  local n = math.random(-3, 3)
+  assert(-3 <= n and n <= 3 and math.type(n) == "integer")
+end
+print(math.random(5, 5), math.type(math.random(math.mininteger, math.maxinteger)))
+print(pcall(math.random, 2, 1))
+print(pcall(math.random, 1, 2, 3))
+local zero = 0
+print(pcall(function() return 1 // zero end))
+print(pcall(function() return 1 % zero end))
+print(1 // 0.0, -1 % 0.0, 7 // -2, 7 % -2, 7.5 % -2, 5 % math.huge, -5 % math.huge)
+print(3 & 5.0, "10" + 1, 2^2, 7 / 7, math.mininteger // -1, 1 < 1.5, 2.5 <= 2)
+-- integers and floats compare by their exact values
+local maxi, mini = math.maxinteger, math.mininteger
+assert(maxi < 2^63 and maxi <= 2^63 and not (maxi >= 2^63) and maxi ~= 2^63)
+assert(2^63 > maxi and not (2^63 <= maxi) and maxi + 0.0 == 2^63)
+assert(mini == -2^63 and mini <= -2^63 and not (mini < -2^63) and -2^63 <= mini)
+assert(maxi - 1 < maxi + 0.0 and maxi - 1 ~= maxi + 0.0 and (1 << 53) + 1 > 2^53)
+assert((1 << 53) + 1 ~= 2^53 and (1 << 53) + 1 < 2^53 + 2 and 2^53 + 2 > (1 << 53) + 1)
+assert(maxi < math.huge and mini > -math.huge and not (maxi > math.huge))
+local nan = 0 / 0
+assert(not (maxi < nan) and not (maxi <= nan) and not (nan < mini) and not (nan <= mini) and maxi ~= nan)
+assert(1 < 1.5 and 2 <= 2.0 and 2.0 <= 2 and not (2 < 2.0) and 3 == 3.0 and -0.0 == 0)
+assert(maxi - 1 < 2^63 - 1 and not (2^63 - 1 < maxi)) -- 2^63 - 1 rounds to 2^63
+assert(math.max(maxi, 2^63) == 2^63 and math.type(math.max(maxi, 2^63)) == "float")
+-- integer loops clip a float limit to an integer, float loops count in floats
+local function loop(init, limit, step)
+  local r = {}
+  for i = init, limit, step do
+    r[#r + 1] = math.type(i) .. " " .. i
+    if #r == 3 then break end
+  end
+  return table.concat(r, ", ")
+end
+assert(loop(1, 2.5, 1) == "integer 1, integer 2" and loop(3, 1.5, -1) == "integer 3, integer 2")
+assert(loop(1, math.huge, 1) == "integer 1, integer 2, integer 3" and loop(1, -math.huge, 1) == "")
+assert(loop(mini, -math.huge, 1) == "" and loop(maxi, math.huge, -1) == "" and loop(1, 0 / 0, 1) == "")
+assert(loop(1, 2, 0) == "" and loop(0.5, 2, 1) == "float 0.5, float 1.5" and loop(2, 1, -0.5) == "float 2.0, float 1.5, float 1.0")
+-- shifts by 64 bits or more in either direction give 0
+assert(1 << math.mininteger == 0 and 1 >> math.mininteger == 0)
+assert(-1 << 64 == 0 and -1 >> -64 == 0 and 1 << 63 == mini and -1 >> 63 == 1 and 2 >> -62 == mini)
+print("math ok")
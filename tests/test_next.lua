@@ -0,0 +1,133 @@
+-- table traversal, borders and the moves between the array and hash parts
+
+local function count(t)
+  local n = 0
+  for _ in pairs(t) do n = n + 1 end
+  return n
+end
+
+-- clearing fields during a traversal, as 5.3 allows
+local t = {}
+for i = 1, 100 do t[i] = i; t["k" .. i] = i end
+local seen = 0
+for k in pairs(t) do
+  seen = seen + 1
+  t[k] = nil
+end
+assert(seen == 200 and next(t) == nil)
+
+-- assigning existing fields during a traversal
+t = {x = 1, y = 2, z = 3, 10, 20}
+for k, v in pairs(t) do t[k] = v * 2 end
+assert(t.x == 2 and t.y == 4 and t.z == 6 and t[1] == 20 and t[2] == 40)
+
+-- a cleared key can still be passed to next
+t = {a = 1, b = 2, c = 3}
+local k1 = next(t)
+t[k1] = nil
+local k2 = next(t, k1)
+assert(k2 ~= nil and k2 ~= k1 and t[k2] ~= nil)
+assert(not pcall(next, t, "nokey"))
+assert(not pcall(next, {}, 1))
+
+-- traversal after many writes, and next from the start after each write
+t = {}
+for i = 1, 2000 do
+  t["s" .. i] = i
+  if i % 100 == 0 then assert(next(t) ~= nil) end
+end
+for i = 1, 2000, 2 do t["s" .. i] = nil end
+assert(count(t) == 1000)
+for i = 1, 2000, 2 do t["s" .. i] = i end -- revives the dead entries
+assert(count(t) == 2000)
+
+-- borders
+assert(#{} == 0 and #{nil} == 0 and #{1, 2, 3} == 3 and #{n = 1} == 0)
+t = {1, 2, 3, nil, 5}
+local n = #t
+assert((n == 3 or n == 5) and t[n] ~= nil and t[n + 1] == nil)
+t = {}
+for i = 1, 100 do t[i] = i end
+for i = 100, 51, -1 do t[i] = nil; assert(#t == i - 1) end
+t[#t + 1] = "x"
+assert(#t == 51 and t[51] == "x")
+t = {}
+for i = 10, 1, -1 do t[i] = i end -- built backwards, through the hash part
+assert(#t == 10)
+t = {}
+t[1], t[2], t[4] = 1, 2, 4
+n = #t
+assert(t[n] ~= nil and t[n + 1] == nil)
+t = {[1] = 1, [2] = 2, [3] = 3, [1000] = 1000}
+assert(#t == 3)
+t = {}
+t[1.0], t[2.0], t[3] = "a", "b", "c"
+assert(#t == 3 and t[2] == "b" and math.type(next(t)) == "integer")
+
+-- a border is any n with t[n] ~= nil and t[n+1] == nil
+local function isBorder(t, n)
+  return (n == 0 or t[n] ~= nil) and t[n + 1] == nil
+end
+
+-- random writes checked against a model kept in plain lists
+math.randomseed(7)
+for round = 1, 20 do
+  local keys, vals, nKeys = {}, {}, 0
+  local function find(k)
+    for i = 1, nKeys do
+      if keys[i] == k then return i end
+    end
+  end
+  t = {}
+  for step = 1, 300 do
+    local k
+    local r = math.random(4)
+    if r == 1 then k = math.random(40)
+    elseif r == 2 then k = math.random(40) + 0.0
+    elseif r == 3 then k = "k" .. math.random(30)
+    else k = math.random(1000) end
+    local v = math.random(3) > 1 and step or nil
+    t[k] = v
+    local i = find(k)
+    if not i then
+      nKeys = nKeys + 1
+      i = nKeys
+      keys[i] = k
+    end
+    vals[i] = v
+
+    if step % 25 == 0 then
+      local live = 0
+      for j = 1, nKeys do
+        assert(t[keys[j]] == vals[j])
+        if vals[j] ~= nil then live = live + 1 end
+      end
+      local visited = {}
+      for k, v in pairs(t) do
+        assert(not visited[k] and v == vals[find(k)])
+        visited[k] = true
+      end
+      assert(count(t) == live and isBorder(t, #t))
+    end
+  end
+end
+
+-- a queue that appends at one end and clears the other keeps a bounded size
+do
+  local q, head, tail = {}, 1, 0
+  collectgarbage()
+  local before = collectgarbage("count")
+  for i = 1, 200000 do
+    tail = tail + 1
+    q[tail] = i
+    if tail - head >= 10 then
+      q[head] = nil
+      head = head + 1
+    end
+  end
+  collectgarbage()
+  assert(collectgarbage("count") - before < 1024) -- KB
+  assert(count(q) == 10 and q[head] == head and q[tail] == tail)
+end
+
+print("next ok")
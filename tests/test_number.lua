@@ -0,0 +1,85 @@
+-- numerals in the source and in strings converted to numbers
+local function check(x, t, v)
+  assert(math.type(x) == t, tostring(x) .. " is not an " .. t)
+  assert(x == v, tostring(x) .. " ~= " .. tostring(v))
+end
+
+-- decimal and hexadecimal integers
+check(0, "integer", 0)
+check(42, "integer", 6 * 7)
+check(0x10, "integer", 16)
+check(0XfF, "integer", 255)
+check(9223372036854775807, "integer", math.maxinteger)
+check(0x7fffffffffffffff, "integer", math.maxinteger)
+
+-- hexadecimal integers wrap around, decimal ones become floats
+check(0xffffffffffffffff, "integer", -1)
+check(0x10000000000000000, "integer", 0)
+check(0x8000000000000000, "integer", math.mininteger)
+check(9223372036854775808, "float", 2^63)
+check(-9223372036854775808, "float", -2^63) -- minus applied to a float
+check(18446744073709551616, "float", 2^64)
+check(1e400 // 1, "float", math.huge)
+assert(math.maxinteger + 1 == math.mininteger and math.mininteger - 1 == math.maxinteger)
+assert(math.maxinteger * 2 == -2)
+
+-- decimal and hexadecimal floats
+check(1.0, "float", 1)
+check(.5, "float", 1 / 2)
+check(3., "float", 3)
+check(1e2, "float", 100)
+check(1E+2, "float", 100)
+check(25e-2, "float", 0.25)
+check(0x.8, "float", 0.5)
+check(0xA.8p0, "float", 10.5)
+check(0x1p4, "float", 16)
+check(0x1P-2, "float", 0.25)
+check(0x.1p4, "float", 1)
+check(0xffffffffffffffff.0, "float", 2^64 - 1)
+
+-- malformed numerals are syntax errors
+for s, near in pairs{["1e"] = "1e", ["0x"] = "0x", ["1..2"] = "1..2", ["0xg"] = "0x", ["1e+"] = "1e+", ["0x1p"] = "0x1p"} do
+  local f, msg = load("return " .. s)
+  assert(not f and msg:find("malformed number near '" .. near .. "'", 1, true), s .. ": " .. tostring(msg))
+end
+
+-- strings follow the same grammar, with spaces around the numeral
+check(tonumber("10"), "integer", 10)
+check(tonumber("  0x10\t\n"), "integer", 16)
+check(tonumber("-0x10"), "integer", -16)
+check(tonumber("0xffffffffffffffff"), "integer", -1)
+check(tonumber("9223372036854775807"), "integer", math.maxinteger)
+check(tonumber("-9223372036854775808"), "integer", math.mininteger)
+check(tonumber("9223372036854775808"), "float", 2^63)
+check(tonumber(" 1e1 "), "float", 10)
+check(tonumber("0x1p-1"), "float", 0.5)
+check(tonumber(".5"), "float", 0.5)
+check(tonumber("5."), "float", 5)
+for _, s in ipairs{"", " ", "1 2", "0x", "1e", "inf", "nan", "- 1", "1_000", "0x1.p", "1e1.5"} do
+  assert(tonumber(s) == nil, s)
+end
+
+-- arithmetic converts strings with the same rules
+check("10" + 1, "integer", 11)
+check("0x10" * 2, "integer", 32)
+check(" 3 " - 1, "integer", 2)
+check("1e1" + 0, "float", 10)
+check("10" / 2, "float", 5)
+check(-"2", "integer", -2)
+check("3" // "2", "integer", 1)
+check("0x7fffffffffffffff" + 1, "integer", math.mininteger)
+check("9223372036854775808" + 0, "float", 2^63)
+check("2" ^ "3", "float", 8)
+check("7" & 3, "integer", 3)
+check("3.0" | 0, "integer", 3) -- floats with integral values
+assert(not pcall(function() return "3.5" | 0 end))
+assert(not pcall(function() return "abc" + 1 end))
+assert(select(2, pcall(function() return {} + 1 end)):find("attempt to perform arithmetic on a table value"))
+assert(10 == "10" + 0 and "10" ~= 10) -- comparison does not convert
+
+-- numbers convert to strings
+assert(tostring(10) == "10" and tostring(-0x10) == "-16" and tostring(1e15) == "1e+15")
+assert(tostring(1.0) == "1.0" and tostring(-0.0) == "-0.0" and tostring(2^63) == "9.2233720368548e+18")
+assert(10 .. "" == "10" and 1.5 .. "" == "1.5" and math.mininteger .. "" == "-9223372036854775808")
+assert(math.tointeger("8") == 8 and math.tointeger(3.0) == 3 and string.rep("x", "3") == "xxx")
+print("number ok")
@@ -0,0 +1,74 @@
+-- expected results are those of the reference Lua 5.3 interpreter
+local function hex(s)
+  return (s:gsub(".", function(c) return string.format("%02x", c:byte()) end))
+end
+local function check(got, want)
+  if got ~= want then error(string.format("got %s, want %s", tostring(got), tostring(want)), 2) end
+end
+
+check(hex(string.pack("<i4", 100)), "64000000")
+check(hex(string.pack(">i4", 100)), "00000064")
+check(hex(string.pack("<i2", -2)), "feff")
+check(hex(string.pack(">I3", 0x010203)), "010203")
+check(hex(string.pack("<i16", -1)), string.rep("ff", 16))
+check(hex(string.pack(">i9", 1)), "000000000000000001")
+check(hex(string.pack("b B h H", -1, 255, -2, 65535)), "fffffeffffff")
+check(hex(string.pack("<d", 1.5)), "000000000000f83f")
+check(hex(string.pack(">f", -2.0)), "c0000000")
+check(hex(string.pack("z", "ab")), "616200")
+check(hex(string.pack("s1", "abc")), "03616263")
+check(hex(string.pack(">s2", "ab")), "00026162")
+check(hex(string.pack("c5", "ab")), "6162000000")
+check(hex(string.pack("<!4 b i4", 1, 2)), "0100000002000000")
+check(hex(string.pack("<!8 b d", 1, 0)), "01" .. string.rep("00", 15))
+check(hex(string.pack("<b x h", 1, 2)), "01000200")
+check(hex(string.pack("<!4 b Xi4 b", 1, 2)), "0100000002")
+check(hex(string.pack("<b h", 1, 2)), "010200") -- no alignment by default
+
+check(string.packsize("i4 i8 d"), 20)
+check(string.packsize("!8 b d"), 16)
+check(string.packsize("c10"), 10)
+check(string.packsize("!4 b Xi4"), 4)
+
+local a, b, c, nextpos = string.unpack("<i4 >i2 z", string.pack("<i4 >i2 z", -7, 300, "hi"))
+check(a, -7); check(b, 300); check(c, "hi"); check(nextpos, 10)
+check(string.unpack("<i2", "\1\0\2\0", 3), 2)
+check(string.unpack("<i2", "\1\0\2\0", -2), 2)
+check(string.unpack("<I2", "\255\255"), 65535)
+check(string.unpack("<i2", "\255\255"), -1)
+check(string.unpack("<j", string.pack("<j", 0x7fffffffffffffff)), 0x7fffffffffffffff)
+check(string.unpack("<i16", string.pack("<i16", -3)), -3)
+check(string.unpack("s1", "\3abcX"), "abc")
+check(string.unpack("c3", "abcdef"), "abc")
+check(string.unpack("<d", string.pack("<d", 3.25)), 3.25)
+check(string.unpack("<f", string.pack("<f", 0.5)), 0.5)
+check(select("#", string.unpack("i4 i4", string.pack("i4 i4", 1, 2))), 3)
+
+-- errors
+local function err(f, ...)
+  local ok, msg = pcall(f, ...)
+  check(ok, false)
+  return msg
+end
+check(err(string.pack, "i17", 1), "integral size (17) out of limits [1,16]")
+check(err(string.pack, "i0", 1), "integral size (0) out of limits [1,16]")
+check(err(string.pack, "b", 200), "bad argument #2 to 'string.pack' (integer overflow)")
+check(err(string.pack, "B", -1), "bad argument #2 to 'string.pack' (unsigned overflow)")
+check(err(string.pack, "c2", "abc"), "bad argument #2 to 'string.pack' (string longer than given size)")
+check(err(string.pack, "s1", string.rep("x", 256)), "bad argument #2 to 'string.pack' (string length does not fit in given size)")
+check(err(string.pack, "z", "a\0b"), "bad argument #2 to 'string.pack' (string contains zeros)")
+check(err(string.pack, "y", 1), "invalid format option 'y'")
+check(err(string.pack, "c", "a"), "missing size for format option 'c'")
+check(err(string.pack, "!3 i4", 1), "bad argument #1 to 'string.pack' (format asks for alignment not power of 2)")
+check(err(string.pack, "!4 i3", 1), "bad argument #1 to 'string.pack' (format asks for alignment not power of 2)")
+check(err(string.pack, "X", 1), "bad argument #1 to 'string.pack' (invalid next option for option 'X')")
+check(err(string.pack, "Xc1"), "bad argument #1 to 'string.pack' (invalid next option for option 'X')")
+check(err(string.pack, "i4"), "bad argument #2 to 'string.pack' (number expected, got no value)")
+check(err(string.packsize, "s"), "bad argument #1 to 'string.packsize' (variable-length format)")
+check(err(string.packsize, "z"), "bad argument #1 to 'string.packsize' (variable-length format)")
+check(err(string.unpack, "i4", "abc"), "bad argument #2 to 'string.unpack' (data string too short)")
+check(err(string.unpack, "z", "abc"), "bad argument #2 to 'string.unpack' (unfinished string for format 'z')")
+check(err(string.unpack, "s1", "\5ab"), "bad argument #2 to 'string.unpack' (data string too short)")
+check(err(string.unpack, "i4", "abcd", 6), "bad argument #3 to 'string.unpack' (initial position out of string)")
+check(err(string.unpack, "<i9", "\0\0\0\0\0\0\0\0\1"), "9-byte integer does not fit into Lua Integer")
+print("pack ok")
@@ -0,0 +1,49 @@
+print(type(package.path), type(package.loaded), type(package.preload), #package.searchers)
+print(package.loaded.string == string, package.loaded._G == _G, package.loaded.package == package)
+print(require("string") == string, package.config:sub(1, 1))
+
+package.preload.greet = function(name, extra)
+  return {hello = function() return "hello from " .. name end, extra = extra}
+end
+local greet = require("greet")
+print(greet.hello(), greet.extra, require("greet") == greet)
+
+local base = os.tmpname()
+package.path = base .. "_?.lua"
+
+local f = assert(io.open(base .. "_counter.lua", "w"))
+f:write("local name, file = ...\ncount = (count or 0) + 1\nreturn {name = name, file = file}\n")
+f:close()
+local c = require("counter")
+print(c.name, c.file == base .. "_counter.lua", count, require("counter") == c, count)
+
+f = assert(io.open(base .. "_noreturn.lua", "w"))
+f:write("loaded_noreturn = true\n")
+f:close()
+print(require("noreturn"), loaded_noreturn, package.loaded.noreturn)
+
+-- precompiled chunks are found along the same path
+f = assert(io.open(base .. "_compiled.lua", "wb"))
+f:write(string.dump(function(...) return {answer = 42, name = ...} end))
+f:close()
+local m = require("compiled")
+print(m.answer, m.name)
+
+print(package.searchpath("counter", package.path) == base .. "_counter.lua")
+print(select(2, package.searchpath("a.b", "x/?.lua;;y/?.so")))
+print(select(2, package.searchpath("a.b", "?.x", "", "")))
+
+local ok, err = pcall(require, "no.such.module")
+print(ok, (err:gsub(base, "TMP")))
+
+package.searchers[3] = function(name) return function() return "from searcher " .. name end end
+print(require("custom"))
+package.searchers[3] = nil
+package.path = {}
+print(pcall(require, "other"))
+
+for _, suffix in ipairs({"_counter.lua", "_noreturn.lua", "_compiled.lua"}) do
+  assert(os.remove(base .. suffix))
+end
+os.remove(base)
+print("package ok")
@@ -0,0 +1,61 @@
+-- basic functions
+print(("hello"):upper(), string.lower("MiXeD"), ("abc"):len(), #"abc", ("abc"):reverse())
+print(("hello"):sub(2, 4), ("hello"):sub(-3), ("hello"):sub(2), ("hello"):sub(0), ("hello"):sub(10))
+print(("ABC"):byte(), ("ABC"):byte(1, -1), ("ABC"):byte(10))
+print(string.char(72, 105), pcall(string.char, 256))
+print(("ab"):rep(3), ("ab"):rep(3, ","), ("x"):rep(0), ("x"):rep(-1))
+
+-- find
+print(("hello world"):find("wor"), ("hello world"):find("o", 6), ("hello"):find("l+"))
+print(("a.b"):find(".", 1, true), ("hello"):find(""), ("hello"):find("", 10), ("hello"):find("xyz"))
+print(("hello world"):find("(o)(r)"), ("  x"):find("^%s*"), ("abc"):find("b", -1))
+
+-- match
+print(("key = value"):match("(%w+)%s*=%s*(%w+)"))
+print(("2024-01-15"):match("(%d+)-(%d+)-(%d+)"))
+print(("hello"):match("()ll()"), ("hello"):match(".-l"), ("hello"):match(".*l"))
+print(("THE (quick) fox"):match("%((%a+)%)"), ("f(a(b)c)d"):match("%b()"))
+print(("THE (quick) fox"):find("%f[%a]%a+%f[%A]", 5))
+print(("hello"):match("^(h)(e)"), ("hello"):match("^e"), ("x = 1"):match("^(%w+)$"))
+print(("abcabc"):match("(a)(b)c%1%2"), ("[test]"):match("^%[(.*)%]$"), ("a-b"):match("[%-]"))
+print(("0x1F"):match("^0[xX](%x+)$"), ("tab\there"):match("%c"), ("a,b;c"):match("[^%w]"))
+
+-- gmatch
+for w in ("one two  three"):gmatch("%a+") do io = io; print(w) end
+for k, v in ("a=1, b=2, c=3"):gmatch("(%w+)=(%w+)") do print(k, v) end
+local n = 0
+for _ in ("abc"):gmatch("") do n = n + 1 end
+print(n)
+
+-- gsub
+print(("hello world"):gsub("o", "0"))
+print(("hello world"):gsub("(%w+)", "<%1>"))
+print(("hello world"):gsub("%w+", "%0 %0", 1))
+print(("abc"):gsub("", "-"))
+print(("$name is $age"):gsub("%$(%w+)", {name = "Bob", age = 42}))
+print(("1 2 3"):gsub("%d", function(d) return d * 2 end))
+print(("keep this"):gsub("%w+", function() return nil end))
+print(("hello"):gsub("^h", "H"), ("x"):gsub("x", "%%"))
+print(pcall(string.gsub, "x", "x", "%2"))
+print(pcall(string.gsub, "x", "(", "y"))
+print(pcall(string.find, "x", "[a"))
+print(pcall(string.rep, "x", 1099511627776))
+
+-- format
+print(string.format("%d %5d %-5d| %05d %+d %x %X %o %#x", 42, 42, 42, 42, 42, 255, 255, 8, 255))
+print(string.format("%5.2f %e %g %g %g %.3g", 3.14159, 12345.678, 0.1, 1e20, 100000, 2/3))
+print(string.format("%s %10s|%-10s|%.2s", "str", "right", "left", "truncate"))
+print(string.format("%q", 'a "quoted"\n\0 string\1' .. "2"))
+print(string.format("%q %q %q %q", 1/0, -1/0, 42, math and 0 or 0.5))
+print(string.format("%a %A %.3a", 1.0, 0.5, 3.0))
+print(string.format("%c%c%c", 76, 117, 97), string.format("%%"), string.format("%5.1s|", "abc"))
+print(string.format("%s %s %s", nil, true, setmetatable({}, {__tostring = function() return "obj" end})))
+print(string.format("%f %F %e", 1/0, -1/0, 0/0 ~= 0/0 and 1/0 or 0))
+print(string.format("%d", 3.0), pcall(string.format, "%d", 3.5))
+print(pcall(string.format, "%y", 1))
+print(pcall(string.format, "%d"))
+print(pcall(string.format, "%123d", 1))
+
+-- dump and string metatable
+local f = load(string.dump(function(a, b) return a + b end))
+print(f(2, 3), getmetatable("").__index == string, pcall(string.dump, print))
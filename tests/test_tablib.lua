@@ -0,0 +1,65 @@
+local t = {1, 2, 3}
+table.insert(t, 4)
+table.insert(t, 1, 0)
+print(table.concat(t, ","), #t)
+print(table.remove(t), table.remove(t, 1), table.concat(t, ","))
+print(table.remove({}), #t, pcall(table.insert, t, 10, 1))
+print(pcall(table.insert, t), pcall(table.insert, t, 1, 2, 3))
+
+print(table.concat({}), table.concat({1, 2.5, "x"}, "-"), table.concat({1, 2, 3, 4}, ",", 2, 3))
+print(pcall(table.concat, {1, {}, 3}))
+
+local p = table.pack(1, nil, 3)
+print(p.n, p[1], p[2], p[3])
+print(table.unpack({1, 2, 3}), table.unpack({1, 2, 3}, 2), table.unpack({1, 2, 3}, 2, 5))
+print(select("#", table.unpack({}, 1, 0)), pcall(table.unpack, {}, 1, 1e8))
+
+print(table.concat(table.move({1, 2, 3, 4, 5}, 2, 4, 1), ","))
+print(table.concat(table.move({1, 2, 3, 4, 5}, 1, 3, 3), ","))
+print(table.concat(table.move({1, 2, 3}, 1, 3, 2, {}), ",", 2, 4))
+
+-- metamethods are respected
+local log = {}
+local proxy = setmetatable({}, {
+  __index = function(_, k) return rawget(log, k) end,
+  __newindex = function(_, k, v) rawset(log, k, v) end,
+  __len = function() return #log end,
+})
+table.insert(proxy, "a")
+table.insert(proxy, "b")
+table.insert(proxy, 1, "c")
+print(#proxy, table.concat(proxy, ","), rawget(proxy, 1))
+print(pcall(table.insert, setmetatable({}, {}), 1), pcall(table.insert, 1, 1))
+
+-- sort
+local function str(a) return table.concat(a, " ") end
+local a = {5, 2, 8, 1, 9, 3, 7, 4, 6, 0}
+table.sort(a)
+print(str(a))
+table.sort(a, function(x, y) return x > y end)
+print(str(a))
+local words = {"pear", "apple", "fig", "banana"}
+table.sort(words)
+print(str(words))
+table.sort(words, function(x, y) return #x < #y end)
+print(words[1], words[4])
+print(pcall(table.sort, {3, 1, "x"}))
+print(pcall(table.sort, {1, 2, 3, 4, 5}, function() return true end))
+
+-- large inputs, including already sorted and adversarial ones
+local function check(arr, lt)
+  for i = 2, #arr do
+    if lt(arr[i], arr[i - 1]) then return false end
+  end
+  return true
+end
+local big, n = {}, 20000
+for i = 1, n do big[i] = (i * 7919) % n end
+table.sort(big)
+print(check(big, function(x, y) return x < y end))
+for i = 1, n do big[i] = i end
+table.sort(big, function(x, y) return x > y end)
+print(big[1], big[n], check(big, function(x, y) return x > y end))
+for i = 1, n do big[i] = i % 2 end
+table.sort(big)
+print(big[1], big[n], check(big, function(x, y) return x < y end))
@@ -0,0 +1,50 @@
+-- unbounded tail recursion runs in constant space
+local function count(n, acc)
+  if n == 0 then return acc end
+  return count(n - 1, acc + 1)
+end
+print(count(1000000, 0))
+
+local even, odd
+function even(n) if n == 0 then return true end return odd(n - 1) end
+function odd(n) if n == 0 then return false end return even(n - 1) end
+print(even(300001), odd(300001))
+
+-- results, varargs and methods
+local function pack(...) return select("#", ...), ... end
+local function forward(...) return pack(...) end
+print(forward(1, nil, 3, nil))
+print(forward())
+local obj = {n = 10}
+function obj:add(x) return self.n + x end
+local function method(o) return o:add(5) end
+print(method(obj))
+
+-- callable tables and Go functions in tail position
+local callable = setmetatable({}, {__call = function(self, x) return x * 2, self end})
+local function viacall(x) return callable(x) end
+print(viacall(21) == 42, select(2, viacall(1)) == callable)
+local function gofunc(s) return string.byte(s, 1, -1) end
+print(gofunc("abc"))
+local function loop(n) if n == 0 then return tostring(n) end return loop(n - 1) end
+print(loop(100000))
+
+-- errors raised in tail position
+local function bad() return error("boom") end
+local function caller() bad() end
+print(pcall(caller))
+local function notfunc() local t = {} return t.missing() end
+print(pcall(notfunc))
+local function badarg() return string.rep() end
+print(pcall(badarg))
+
+-- tail calls inside coroutines
+local co = coroutine.wrap(function(a)
+  local function step(n)
+    if n == 0 then return coroutine.yield("down") end
+    return step(n - 1)
+  end
+  return coroutine.yield(step(1000) .. a)
+end)
+print(co("!"), co("up"))
+print("tailcall ok")
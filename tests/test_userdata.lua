@@ -0,0 +1,51 @@
+-- full and light userdata through the Go API; run with cmd/luatest
+local T = require "T"
+
+local u = T.newuserdata("Point", "p1")
+local empty = T.newuserdata("Point") -- a valid userdata without data
+local other = T.newuserdata("Other", "o1")
+local light = T.lightuserdata("l1")
+assert(type(u) == "userdata" and type(empty) == "userdata" and type(light) == "userdata")
+assert(getmetatable(u) == getmetatable(empty) and getmetatable(u) ~= getmetatable(other))
+assert(getmetatable(light) == nil)
+
+-- TestUdata tells a missing userdata apart from one holding no data
+local ok, data = T.testudata(u, "Point")
+assert(ok and data == "p1")
+ok, data = T.testudata(empty, "Point")
+assert(ok and data == nil)
+assert(not T.testudata(other, "Point"))
+assert(not T.testudata(T.newuserdata(), "Point")) -- no metatable
+assert(not T.testudata(light, "Point"))
+assert(not T.testudata({}, "Point") and not T.testudata(nil, "Point"))
+
+-- CheckUdata only fails on the wrong kind of value
+assert(T.checkudata(u, "Point") == "p1")
+assert(T.checkudata(empty, "Point") == nil)
+local msg = select(2, pcall(T.checkudata, other, "Point"))
+assert(msg:find("bad argument #1 to '?' (Point expected, got Other)", 1, true), msg) -- T is not global
+msg = select(2, pcall(T.checkudata, 42, "Point"))
+assert(msg:find("(Point expected, got number)", 1, true), msg)
+msg = select(2, pcall(T.checkudata, light, "Point"))
+assert(msg:find("(Point expected, got light userdata)", 1, true), msg)
+
+-- metamethods of the shared metatable
+local mt = getmetatable(u)
+mt.__index = function(self, k) return k .. "!" end
+mt.__eq = function(a, b) return true end
+mt.__len = function() return 7 end
+assert(u.x == "x!" and empty.y == "y!" and #u == 7)
+assert(u == empty and u == other and rawequal(u, u) and not rawequal(u, empty)) -- either __eq
+assert(tostring(u):find("^Point: ")) -- __name
+mt.__tostring = function() return "a point" end
+assert(tostring(empty) == "a point")
+
+-- light userdata compare by value and have no metatable of their own
+assert(T.lightuserdata("l1") == light and T.lightuserdata("l2") ~= light)
+assert(not pcall(function() return light.x end))
+
+-- userdata as table keys
+local t = {[u] = 1, [empty] = 2}
+assert(t[u] == 1 and t[empty] == 2)
+
+print("userdata ok")
@@ -0,0 +1,25 @@
+local s = "h\u{E9}llo \u{4E16}\u{754C}"
+print(#s, utf8.len(s), utf8.char(104, 233, 0x4E16), utf8.char())
+print(utf8.codepoint(s, 1, -1))
+print(utf8.len("abc\xffdef"), utf8.len(s, 3), pcall(utf8.len, s, 20))
+print(utf8.len("\xe4\xb8"), utf8.len(""), utf8.len(s, -6))
+local t = {}
+for p, c in utf8.codes(s) do t[#t + 1] = p .. ":" .. c end
+print(table.concat(t, " "))
+print(pcall(function() for _ in utf8.codes("a\xffb") do end end))
+print(utf8.offset(s, 3), utf8.offset(s, -1), utf8.offset(s, 0, 3), utf8.offset(s, 20))
+print(utf8.offset(s, 1, -3), pcall(utf8.offset, s, 1, 3))
+print(pcall(utf8.char, 0x110000), pcall(utf8.codepoint, "\xff"))
+local n = 0
+for c in s:gmatch(utf8.charpattern) do n = n + 1 end
+print(n, utf8.charpattern == "[\0-\x7F\xC2-\xF4][\x80-\xBF]*")
+
+-- escapes beyond the Unicode range use the original 6-byte scheme
+print(("\u{7FFFFFFF}"):byte(1, -1))
+print(("\u{D800}"):byte(1, -1))
+print(("\u{10FFFF}"):byte(1, -1))
+print(("\u{0}\u{7F}\u{80}\u{7FF}\u{800}"):byte(1, -1))
+print(#"\u{110000}", #"\u{3FFFFFF}", #"\u{4000000}")
+print(load('return "\\u{80000000}"'))
+print(1 << 4, 256 >> 4, -1 >> 63, 1 << 64, 3 << -1)
+print("utf8 ok")
@@ -0,0 +1,14 @@
+local function handler(msg)
+  return "handled: " .. msg
+end
+
+print(xpcall(function(a, b) return a + b end, handler, 1, 2))
+
+ok, err = xpcall(function() local t = nil; return t.x end, handler)
+print(ok, err)
+
+ok, err = xpcall(error, function(msg) error("again") end, "boom")
+print(ok, err)
+
+ok, err = xpcall(function() error({code = 42}) end, function(e) return e.code end)
+print(ok, err)